@@ -0,0 +1,146 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+// Interface to access accumulator tree, a segment tree for range updates
+// and point queries
+type Accumulator interface {
+	// Add delta to every coordinate of range
+	AddToRange(from, to, delta int)
+	// Clear the range stack
+	Clear()
+	// Build accumulator tree out of range stack, ErrNoIntervals if stack is empty
+	BuildTree() error
+	// Summed value at point
+	ValueAt(point int) int
+}
+
+// accumulator is the dual of stree: instead of a list of overlapping
+// intervals every node holds the sum of the deltas of all ranges that
+// contain the segment of the node. A point query adds up the values on
+// the path from the root to the leaf containing the point.
+type accumulator struct {
+	root *anode
+	// Range stack
+	base []Interval
+	// Delta of each range in stack, indexed by Id
+	delta []int
+	// Min value of all ranges
+	min int
+	// Max value of all ranges
+	max int
+}
+
+type anode struct {
+	// A segment is a interval represented by the node
+	segment     Segment
+	left, right *anode
+	// Sum of deltas of all ranges that contain segment
+	value int
+}
+
+func (n *anode) Segment() Segment {
+	return n.segment
+}
+
+func (n *anode) Left() Node {
+	return n.left
+}
+
+func (n *anode) Right() Node {
+	return n.right
+}
+
+// Overlap returns nil, the node holds the sum of deltas instead of intervals
+func (n *anode) Overlap() []Interval {
+	return nil
+}
+
+// NewAccumulatorTree returns an Accumulator interface with underlying segment tree implementation
+func NewAccumulatorTree() Accumulator {
+	t := new(accumulator)
+	t.Clear()
+	return t
+}
+
+// AddToRange pushes range with delta to stack. If the tree is already built
+// and from and to are boundaries of elementary intervals of the tree, the
+// nodes are updated in O(log n), otherwise the tree is rebuilt.
+func (t *accumulator) AddToRange(from, to, delta int) {
+	t.base = append(t.base, Interval{Id: len(t.base), Segment: Segment{from, to}})
+	t.delta = append(t.delta, delta)
+	if t.root == nil {
+		return
+	}
+	if from >= t.min && to <= t.max && Aligned(t.root, from, to) {
+		addToRange(t.root, &t.base[len(t.base)-1].Segment, delta)
+	} else {
+		t.BuildTree()
+	}
+}
+
+// Clear the range stack
+func (t *accumulator) Clear() {
+	t.root = nil
+	t.base = t.base[:0]
+	t.delta = t.delta[:0]
+	t.min = 0
+	t.max = 0
+}
+
+// Build accumulator tree out of range stack, returns ErrNoIntervals and
+// leaves the tree unchanged if the stack is empty
+func (t *accumulator) BuildTree() error {
+	if len(t.base) == 0 {
+		return ErrNoIntervals
+	}
+	var endpoint []int
+	endpoint, t.min, t.max = Endpoints(t.base)
+	t.root = buildNodes(ElementaryIntervals(endpoint), func(seg Segment, left, right *anode) *anode {
+		return &anode{segment: seg, left: left, right: right}
+	})
+	for i := range t.base {
+		addToRange(t.root, &t.base[i].Segment, t.delta[i])
+	}
+	return nil
+}
+
+// ValueAt returns the sum of deltas of all ranges that contain point
+func (t *accumulator) ValueAt(point int) int {
+	if t.root == nil {
+		panic(ErrEmptyTree)
+	}
+	if t.root.segment.Disjoint(point, point) {
+		return 0
+	}
+	value := 0
+	node := t.root
+	for node != nil {
+		value += node.value
+		if node.left != nil && point <= node.left.segment.To {
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+	return value
+}
+
+// addToRange adds delta to the canonical nodes of segment
+func addToRange(node *anode, seg *Segment, delta int) {
+	switch node.segment.CompareTo(seg) {
+	case SUBSET:
+		node.value += delta
+	case INTERSECT_OR_SUPERSET:
+		if node.left != nil {
+			addToRange(node.left, seg, delta)
+		}
+		if node.right != nil {
+			addToRange(node.right, seg, delta)
+		}
+	case DISJOINT:
+		// nothing to do
+	}
+}
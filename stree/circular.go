@@ -0,0 +1,339 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"slices"
+)
+
+// circular is a segment tree over the cyclic coordinate space [0, period),
+// e.g. angles or time of day. Intervals and queries with from > to wrap
+// around the end of the period. Wrapping intervals are split into two
+// intervals of the underlying tree, methods that are not overridden
+// operate on these split intervals.
+type circular struct {
+	Tree
+	period int
+	// Pushed intervals as seen by the caller
+	base []Interval
+	// Id of next interval pushed without Id
+	count int
+	// Maps Id of an interval in the underlying tree to the position of the pushed interval
+	owner []int
+	// Index of pushed intervals by external key
+	keys map[string]int
+	// Number of pushed intervals with From == To
+	points int
+}
+
+// NewCircularTree returns a Tree interface with underlying segment tree
+// implementation for coordinates in the cyclic space [0, period)
+func NewCircularTree(period int) Tree {
+	if period <= 0 {
+		panic(ErrInvalidPeriod)
+	}
+	t := &circular{Tree: NewTree(), period: period}
+	t.Clear()
+	return t
+}
+
+// Push new interval to stack, splits interval if from > to
+func (t *circular) Push(from, to int) {
+	t.PushWithId(t.count, from, to)
+}
+
+// Push new interval with external key to stack, splits interval if from > to
+func (t *circular) PushKey(from, to int, key string) {
+	t.Push(from, to)
+	t.base[len(t.base)-1].Key = key
+	t.keys[key] = len(t.base) - 1
+}
+
+// Push new interval with given Id to stack, splits interval if from > to
+func (t *circular) PushWithId(id, from, to int) {
+	t.check(from, to)
+	pos := len(t.base)
+	t.base = append(t.base, Interval{Id: id, Segment: Segment{from, to}})
+	if id >= t.count {
+		t.count = id + 1
+	}
+	if from == to {
+		t.points++
+	}
+	if from <= to {
+		t.Tree.Push(from, to)
+		t.owner = append(t.owner, pos)
+	} else {
+		t.Tree.Push(from, t.period-1)
+		t.Tree.Push(0, to)
+		t.owner = append(t.owner, pos, pos)
+	}
+}
+
+// PushIntervals pushes intervals with their Ids and keys, splits
+// intervals with From > To
+func (t *circular) PushIntervals(intervals []Interval) {
+	for _, intrvl := range intervals {
+		t.PushWithId(intrvl.Id, intrvl.From, intrvl.To)
+		if intrvl.Key != "" {
+			t.base[len(t.base)-1].Key = intrvl.Key
+			t.keys[intrvl.Key] = len(t.base) - 1
+		}
+	}
+}
+
+// Get interval by external key
+func (t *circular) GetByKey(key string) (Interval, bool) {
+	if i, ok := t.keys[key]; ok {
+		return t.base[i], true
+	}
+	return Interval{}, false
+}
+
+// Intervals returns a copy of the pushed intervals, wrapping intervals
+// are not split
+func (t *circular) Intervals() []Interval {
+	return slices.Clone(t.base)
+}
+
+// Push array of intervals to stack
+func (t *circular) PushArray(from, to []int) {
+	for i := 0; i < len(from); i++ {
+		t.Push(from[i], to[i])
+	}
+}
+
+// Clear the interval stack
+func (t *circular) Clear() {
+	t.Tree.Clear()
+	t.count = 0
+	t.base = make([]Interval, 0, 100)
+	t.owner = make([]int, 0, 100)
+	t.keys = make(map[string]int)
+	t.points = 0
+}
+
+// PointIntervalCount returns the number of pushed intervals with From == To,
+// the parts of split intervals are not counted
+func (t *circular) PointIntervalCount() int {
+	return t.points
+}
+
+// Len returns the number of pushed intervals, wrapping intervals count once
+func (t *circular) Len() int {
+	return len(t.base)
+}
+
+// Query interval, splits query if from > to
+func (t *circular) Query(from, to int) []Interval {
+	return t.QueryArray([]int{from}, []int{to})
+}
+
+// Query interval, the expected number of results is ignored
+func (t *circular) QueryHint(from, to, expected int) []Interval {
+	return t.Query(from, to)
+}
+
+// QueryOrdered returns the pushed intervals overlapping the query sorted
+// by From, splits query if from > to
+func (t *circular) QueryOrdered(from, to int) []Interval {
+	result := t.Query(from, to)
+	SortByFrom(result)
+	return result
+}
+
+// QueryView returns a sequence that yields pushed intervals overlapping
+// the query, splits query if from > to
+func (t *circular) QueryView(from, to int) IntervalSeq {
+	linearFrom, linearTo := t.linear([]int{from}, []int{to})
+	return func(yield func(Interval) bool) {
+		seen := make(map[int]struct{})
+		for i, fromvalue := range linearFrom {
+			for intrvl := range t.Tree.QueryView(fromvalue, linearTo[i]) {
+				pos := t.owner[intrvl.Id]
+				if _, ok := seen[pos]; !ok {
+					seen[pos] = struct{}{}
+					if !yield(t.base[pos]) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// QueryFunc calls fn for every pushed interval overlapping the query and
+// stops when fn returns false, splits query if from > to
+func (t *circular) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.QueryView(from, to)(fn)
+}
+
+// Query interval array, splits every query with from > to
+func (t *circular) QueryArray(from, to []int) []Interval {
+	linearFrom, linearTo := t.linear(from, to)
+	seen := make(map[int]struct{})
+	sl := make([]Interval, 0, 10)
+	for _, intrvl := range t.Tree.QueryArray(linearFrom, linearTo) {
+		pos := t.owner[intrvl.Id]
+		if _, ok := seen[pos]; !ok {
+			seen[pos] = struct{}{}
+			sl = append(sl, t.base[pos])
+		}
+	}
+	return sl
+}
+
+// QueryArrayAnnotated returns the pushed intervals that overlap any interval
+// of the array with the indices of the queries they overlap
+func (t *circular) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	groups := make([][]Interval, len(from))
+	for i, fromvalue := range from {
+		groups[i] = t.Query(fromvalue, to[i])
+	}
+	return Annotate(groups)
+}
+
+// QueryArrayAll returns the pushed intervals that overlap every interval
+// of the array, splits queries with from > to
+func (t *circular) QueryArrayAll(from, to []int) []Interval {
+	return QueryAll(t.Query, from, to)
+}
+
+// Canonical returns the distinct segments of the pushed intervals, wrapping
+// intervals are not split
+func (t *circular) Canonical() []Segment {
+	return Canonical(t.base)
+}
+
+// CanonicalCounted returns the distinct segments of the pushed intervals
+// with the number of intervals of each segment
+func (t *circular) CanonicalCounted() []SegmentCount {
+	return CanonicalCounted(t.base)
+}
+
+// CanonicalNodes returns the canonical nodes of the underlying tree,
+// splits query if from > to
+func (t *circular) CanonicalNodes(from, to int) []Node {
+	linearFrom, linearTo := t.linear([]int{from}, []int{to})
+	nodes := make([]Node, 0, 10)
+	for i, fromvalue := range linearFrom {
+		nodes = append(nodes, t.Tree.(SegmentTree).CanonicalNodes(fromvalue, linearTo[i])...)
+	}
+	return nodes
+}
+
+// QueryDetailed returns the nodes of the underlying tree with overlaps,
+// splits query if from > to. The intervals are those of the underlying
+// tree, i.e. wrapping intervals are split.
+func (t *circular) QueryDetailed(from, to int) []SegmentOverlap {
+	linearFrom, linearTo := t.linear([]int{from}, []int{to})
+	result := make([]SegmentOverlap, 0, 10)
+	for i, fromvalue := range linearFrom {
+		result = append(result, t.Tree.(SegmentTree).QueryDetailed(fromvalue, linearTo[i])...)
+	}
+	return result
+}
+
+// Join returns the pairs of Ids of overlapping intervals, the pushed
+// intervals are queried in other, so other should be a circular tree too
+func (t *circular) Join(other Tree) [][2]int {
+	return JoinIntervals(t.base, other)
+}
+
+// FullyCovered returns true if every coordinate of the range is covered,
+// splits range if from > to
+func (t *circular) FullyCovered(from, to int) bool {
+	linearFrom, linearTo := t.linear([]int{from}, []int{to})
+	for i, fromvalue := range linearFrom {
+		if !t.Tree.FullyCovered(fromvalue, linearTo[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryMinLength returns the overlapping intervals with a length of at
+// least minLen, the length of a wrapping interval is To + period - From
+func (t *circular) QueryMinLength(from, to, minLen int) []Interval {
+	result := make([]Interval, 0, 10)
+	for intrvl := range t.QueryView(from, to) {
+		length := intrvl.To - intrvl.From
+		if length < 0 {
+			length += t.period
+		}
+		if length >= minLen {
+			result = append(result, intrvl)
+		}
+	}
+	return result
+}
+
+// Count returns the number of pushed intervals overlapping the query,
+// splits query if from > to
+func (t *circular) Count(from, to int) int {
+	count := 0
+	for range t.QueryView(from, to) {
+		count++
+	}
+	return count
+}
+
+// QueryRecent returns the n pushed intervals with the highest Ids
+// overlapping the query, splits query if from > to
+func (t *circular) QueryRecent(from, to, n int) []Interval {
+	return mostRecent(t.Query(from, to), n)
+}
+
+// QueryInsertionOrder returns the pushed intervals overlapping the query
+// sorted by Id, splits query if from > to
+func (t *circular) QueryInsertionOrder(from, to int) []Interval {
+	return insertionOrder(t.Query(from, to))
+}
+
+// StabBest returns the pushed interval containing point that is preferred by prefer
+func (t *circular) StabBest(point int, prefer Preference) (Interval, bool) {
+	return Best(t.QueryView(point, point), prefer)
+}
+
+// QueryTopK returns the k pushed intervals overlapping the query that are
+// greatest by less, splits query if from > to
+func (t *circular) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	return greatest(t.Query(from, to), k, less)
+}
+
+// Stab returns the pushed intervals that contain point
+func (t *circular) Stab(point int) []Interval {
+	return t.Query(point, point)
+}
+
+// linear splits queries with from > to into two queries
+func (t *circular) linear(from, to []int) (linearFrom, linearTo []int) {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	linearFrom = make([]int, 0, len(from)+1)
+	linearTo = make([]int, 0, len(to)+1)
+	for i, fromvalue := range from {
+		t.check(fromvalue, to[i])
+		if fromvalue <= to[i] {
+			linearFrom = append(linearFrom, fromvalue)
+			linearTo = append(linearTo, to[i])
+		} else {
+			linearFrom = append(linearFrom, fromvalue, 0)
+			linearTo = append(linearTo, t.period-1, to[i])
+		}
+	}
+	return
+}
+
+// check panics if coordinates are outside of period
+func (t *circular) check(from, to int) {
+	if from < 0 || from >= t.period || to < 0 || to >= t.period {
+		panic(ErrOutsidePeriod)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+// Cursor queries a range that changes step by step, e.g. a selection that
+// is dragged wider, and returns only the intervals that are new in each
+// step. Intervals are compared with the default closed interval overlap.
+type Cursor struct {
+	tree Tree
+	// previous range, valid if started
+	from, to int
+	started  bool
+}
+
+// NewCursor returns a Cursor for queries of tree, which must be built
+func NewCursor(tree Tree) *Cursor {
+	return &Cursor{tree: tree}
+}
+
+// Expand moves the cursor to the range (from, to) and returns the intervals
+// overlapping it that didn't overlap the previous range. Such an interval
+// overlaps the part of the range outside the previous one, so only these
+// parts are queried. The first call queries the whole range.
+func (c *Cursor) Expand(from, to int) []Interval {
+	if !c.started || from > to || c.from > c.to {
+		c.from, c.to, c.started = from, to, true
+		return c.tree.Query(from, to)
+	}
+	prevFrom, prevTo := c.from, c.to
+	c.from, c.to = from, to
+	return difference(c.tree, from, to, prevFrom, prevTo)
+}
+
+// difference returns the intervals of tree overlapping (from, to) that don't
+// overlap (prevFrom, prevTo), only the parts of the range outside the
+// previous one are queried. Both ranges must not be empty.
+func difference(tree Tree, from, to, prevFrom, prevTo int) []Interval {
+	partFrom := make([]int, 0, 2)
+	partTo := make([]int, 0, 2)
+	if from < prevFrom {
+		partFrom = append(partFrom, from)
+		partTo = append(partTo, min(to, prevFrom-1))
+	}
+	if to > prevTo {
+		partFrom = append(partFrom, max(from, prevTo+1))
+		partTo = append(partTo, to)
+	}
+	result := make([]Interval, 0, 10)
+	if len(partFrom) == 0 {
+		return result
+	}
+	for _, intrvl := range tree.QueryArray(partFrom, partTo) {
+		if !Overlaps(intrvl.Segment, prevFrom, prevTo) {
+			result = append(result, intrvl)
+		}
+	}
+	return result
+}
+
+// Reset forgets the previous range, the next Expand queries the whole range
+func (c *Cursor) Reset() {
+	c.started = false
+}
+
+// Window is a range of fixed width that moves over a tree, e.g. the time
+// window of a streaming analytic, and returns the intervals that entered
+// and exited the window in each step. Only the parts of the range that are
+// covered or vacated by a step are queried. Intervals are compared with the
+// default closed interval overlap.
+type Window struct {
+	tree  Tree
+	width int
+	// start of the window, valid if started
+	start   int
+	started bool
+}
+
+// NewWindow returns a Window of the range (start, start + width) for
+// queries of tree, which must be built
+func NewWindow(tree Tree, width int) *Window {
+	if width < 0 {
+		panic(ErrNegativeWidth)
+	}
+	return &Window{tree: tree, width: width}
+}
+
+// Advance moves the window to (start, start + width) and returns the
+// intervals that overlap it but not the previous window, and those that
+// overlapped the previous window but not this one. The window may move in
+// both directions. The first call returns the intervals of the window as
+// entered.
+func (w *Window) Advance(start int) (entered, exited []Interval) {
+	if !w.started {
+		w.start, w.started = start, true
+		return w.tree.Query(start, start+w.width), []Interval{}
+	}
+	prev := w.start
+	w.start = start
+	entered = difference(w.tree, start, start+w.width, prev, prev+w.width)
+	exited = difference(w.tree, prev, prev+w.width, start, start+w.width)
+	return entered, exited
+}
+
+// Reset forgets the previous window, the next Advance queries the whole window
+func (w *Window) Reset() {
+	w.started = false
+}
@@ -0,0 +1,49 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// WriteDOT writes tree as GraphViz digraph to w, e.g. to render it with
+// dot -Tpng. Every node is labeled with its segment and the Ids of its
+// intervals, if any, and has edges to its left and right child. Returns
+// ErrEmptyTree if root is nil.
+func WriteDOT(w io.Writer, root Node) error {
+	if root == nil || reflect.ValueOf(root).IsNil() {
+		return ErrEmptyTree
+	}
+	buf := bufio.NewWriter(w)
+	name := make(map[Node]int)
+	fmt.Fprintln(buf, "digraph stree {")
+	fmt.Fprintln(buf, "\tnode [shape=box];")
+	traverse(root, func(node Node) {
+		name[node] = len(name)
+		label := fmt.Sprintf("(%d,%d)", node.Segment().From, node.Segment().To)
+		if len(node.Overlap()) > 0 {
+			ids := make([]string, 0, len(node.Overlap()))
+			for _, intrvl := range node.Overlap() {
+				ids = append(ids, strconv.Itoa(intrvl.Id))
+			}
+			label += "\\nIds: " + strings.Join(ids, ", ")
+		}
+		fmt.Fprintf(buf, "\tn%d [label=\"%s\"];\n", name[node], label)
+	}, func(node Node) {
+		// left first, dot places the first child on the left
+		for _, child := range []Node{node.Left(), node.Right()} {
+			if !reflect.ValueOf(child).IsNil() {
+				fmt.Fprintf(buf, "\tn%d -> n%d;\n", name[node], name[child])
+			}
+		}
+	})
+	fmt.Fprintln(buf, "}")
+	return buf.Flush()
+}
@@ -0,0 +1,237 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"slices"
+	"sort"
+)
+
+// Equal compares the structure of two built trees: both trees must have
+// the same nodes with equal segments and each node must hold the same
+// overlapping intervals, the order of intervals within a node is ignored.
+// Both trees need to support Tree2Array, e.g. serial trees can't be compared.
+func Equal(a, b Tree) bool {
+	aSegArray := a.Tree2Array()
+	bSegArray := b.Tree2Array()
+	if len(aSegArray) != len(bSegArray) {
+		return false
+	}
+	for i, seg := range aSegArray {
+		if seg.Segment != bSegArray[i].Segment {
+			return false
+		}
+		if !equalIntervals(seg.Interval, bSegArray[i].Interval) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualResults compares the query behavior of two trees: for every query
+// segment both trees must return the same intervals, the order of the
+// results is ignored. Trees of any implementation can be compared.
+func EqualResults(a, b Tree, queries []Segment) bool {
+	for _, query := range queries {
+		if !equalIntervals(a.Query(query.From, query.To), b.Query(query.From, query.To)) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalIntervals returns true if both slices contain the same intervals in any order
+func equalIntervals(a, b []Interval) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[Interval]int, len(a))
+	for _, intrvl := range a {
+		count[intrvl]++
+	}
+	for _, intrvl := range b {
+		if count[intrvl] == 0 {
+			return false
+		}
+		count[intrvl]--
+	}
+	return true
+}
+
+// JoinIntervals returns the pairs of Ids (interval, result) of all intervals
+// and the results of querying other with their segments. Pairs are ordered
+// by the Id of the interval, then by the Id of the result.
+func JoinIntervals(intervals []Interval, other Tree) [][2]int {
+	pairs := make([][2]int, 0, len(intervals))
+	for _, intrvl := range intervals {
+		result := other.Query(intrvl.From, intrvl.To)
+		sort.Sort(ById(result))
+		for _, match := range result {
+			pairs = append(pairs, [2]int{intrvl.Id, match.Id})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	return pairs
+}
+
+// QueryAll returns the intervals found by query for every range (from[i],
+// to[i]) in the order of the result of the first range, the intersection of
+// the results. Intervals are identified by Id. Queries stop as soon as the
+// intersection is empty, no ranges give an empty result.
+func QueryAll(query func(from, to int) []Interval, from, to []int) []Interval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	if len(from) == 0 {
+		return []Interval{}
+	}
+	result := query(from[0], to[0])
+	for i := 1; i < len(from) && len(result) > 0; i++ {
+		found := make(map[int]bool, len(result))
+		for _, intrvl := range query(from[i], to[i]) {
+			found[intrvl.Id] = true
+		}
+		all := result[:0]
+		for _, intrvl := range result {
+			if found[intrvl.Id] {
+				all = append(all, intrvl)
+			}
+		}
+		result = all
+	}
+	return result
+}
+
+// streamWindow is the number of Ids that CompareQueryStreaming compares
+// per pass, its two bitsets take 2 * streamWindow / 8 bytes
+const streamWindow = 1 << 20
+
+// CompareQueryStreaming returns true if both trees return the same set of
+// Ids for the query (from, to). Results are consumed with QueryView and
+// never collected, so memory is bounded independent of the result size:
+// a first pass compares the smallest and largest Id of both results, then
+// the Ids are compared in windows of streamWindow Ids, one pass over both
+// views per window. Each pass also finds the smallest Id after its window,
+// where the next window starts, so windows without Ids are skipped and
+// there are at most min(k, (max - min) / streamWindow + 1) passes for k
+// results, e.g. a single pass for dense Ids. Duplicate
+// Ids within a result are ignored, intervals are compared by Id only. A
+// panic of type Error, e.g. ErrEmptyTree, is returned as error.
+func CompareQueryStreaming(a, b Tree, from, to int) (equal bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(Error)
+			if !ok {
+				panic(r)
+			}
+			equal, err = false, e
+		}
+	}()
+	aMin, aMax, aFound := idRange(a.QueryView(from, to))
+	bMin, bMax, bFound := idRange(b.QueryView(from, to))
+	if aFound != bFound || aMin != bMin || aMax != bMax {
+		return false, nil
+	}
+	if !aFound {
+		return true, nil
+	}
+	aBits := make(visitedBits, streamWindow/64)
+	bBits := make(visitedBits, streamWindow/64)
+	for lo := uint(0); ; {
+		clear(aBits)
+		clear(bBits)
+		aNext, aMore := markWindow(a.QueryView(from, to), aMin, lo, aBits)
+		bNext, bMore := markWindow(b.QueryView(from, to), aMin, lo, bBits)
+		if !slices.Equal(aBits, bBits) || aMore != bMore || aNext != bNext {
+			return false, nil
+		}
+		if !aMore {
+			return true, nil
+		}
+		lo = aNext
+	}
+}
+
+// idRange returns the smallest and largest Id of the sequence, found is
+// false if the sequence is empty
+func idRange(seq IntervalSeq) (lo, hi int, found bool) {
+	for intrvl := range seq {
+		if !found || intrvl.Id < lo {
+			lo = intrvl.Id
+		}
+		if !found || intrvl.Id > hi {
+			hi = intrvl.Id
+		}
+		found = true
+	}
+	return
+}
+
+// markWindow marks the Ids of the sequence with an offset to base in
+// [lo, lo + streamWindow) in bits and returns the smallest offset after
+// the window, more is false if there is none. Offsets are unsigned, Ids
+// may span the whole range of int.
+func markWindow(seq IntervalSeq, base int, lo uint, bits visitedBits) (next uint, more bool) {
+	for intrvl := range seq {
+		offset := uint(intrvl.Id) - uint(base)
+		if offset < lo {
+			continue
+		}
+		if offset-lo < streamWindow {
+			bits.visit(int(offset - lo))
+		} else if !more || offset < next {
+			next, more = offset, true
+		}
+	}
+	return
+}
+
+// Diff queries both trees over the same range and returns the intervals
+// that are only in the result of new tree (added) and those only in the
+// result of old tree (removed). Intervals are identified by Id, both
+// results are sorted by Id.
+func Diff(old, new Tree, from, to int) (added, removed []Interval) {
+	oldResult := old.Query(from, to)
+	newResult := new.Query(from, to)
+	oldIds := make(map[int]bool, len(oldResult))
+	for _, intrvl := range oldResult {
+		oldIds[intrvl.Id] = true
+	}
+	newIds := make(map[int]bool, len(newResult))
+	added = make([]Interval, 0, 10)
+	for _, intrvl := range newResult {
+		newIds[intrvl.Id] = true
+		if !oldIds[intrvl.Id] {
+			added = append(added, intrvl)
+		}
+	}
+	removed = make([]Interval, 0, 10)
+	for _, intrvl := range oldResult {
+		if !newIds[intrvl.Id] {
+			removed = append(removed, intrvl)
+		}
+	}
+	sort.Sort(ById(added))
+	sort.Sort(ById(removed))
+	return
+}
+
+// Union returns a new unbuilt segment tree with the intervals of all
+// trees, e.g. of shards that are queried as one. The intervals get new
+// Ids in the order of the trees and their stacks, so Ids of different
+// trees don't collide. Keys are kept, of equal keys GetByKey returns the
+// interval of the last tree. The trees need to hold closed intervals.
+func Union(trees ...Tree) Tree {
+	union := NewTree()
+	intervals := make([]Interval, 0, 64)
+	for _, tree := range trees {
+		for _, intrvl := range tree.Intervals() {
+			intrvl.Id = len(intervals)
+			intervals = append(intervals, intrvl)
+		}
+	}
+	union.PushIntervals(intervals)
+	return union
+}
@@ -0,0 +1,291 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// Flat format of a built tree, all values are little endian int64:
+//
+//	header    magic, number of nodes, intervals and overlap entries
+//	intervals Id, From, To per interval, sorted by Id
+//	nodes     From, To, left, right, first and last+1 overlap entry per node
+//	overlap   position of an interval in intervals per entry
+//
+// Nodes are stored in the preorder of Tree2Array, the root is node 0 and
+// children are referenced by their position, -1 if there is no child.
+// The format contains no pointers, so a file can be mapped into memory by
+// any number of processes and queried in place with NewFlatTree. Keys are
+// not stored.
+const FLAT_MAGIC uint64 = 0x31544c4645455254 // "STREEFL1"
+
+const (
+	// Number of int64 values of header, interval and node
+	flatHeader   = 4
+	flatInterval = 3
+	flatNode     = 6
+)
+
+// Interface to query a flat tree, a read-only segment tree stored in a byte
+// slice. It is not a Tree: the data can't be pushed to, built or changed,
+// so it offers the queries that run on the nodes alone.
+type FlatTree interface {
+	// Query interval
+	Query(from, to int) []Interval
+	// Query interval array, intervals overlapping any interval (union)
+	QueryArray(from, to []int) []Interval
+	// Query interval lazily as sequence
+	QueryView(from, to int) IntervalSeq
+	// Number of intervals overlapping interval
+	Count(from, to int) int
+	// Intervals that contain point
+	Stab(point int) []Interval
+}
+
+// flat queries a tree in flat format by index arithmetic on data
+type flat struct {
+	data []byte
+	// offsets of sections in data, in int64 values
+	intervals, nodes, overlap int
+}
+
+// WriteFlat writes tree in flat format to w, returns ErrEmptyTree if root
+// is nil. The intervals are collected from the nodes, intervals with the
+// same Id are written once.
+func WriteFlat(w io.Writer, root Node) error {
+	if root == nil {
+		return ErrEmptyTree
+	}
+	type record struct {
+		segment     Segment
+		left, right int
+		// overlap entries first to last-1
+		first, last int
+		overlap     []Interval
+	}
+	nodes := make([]record, 0, 64)
+	intervals := make([]Interval, 0, 64)
+	ids := make(map[int]bool)
+	var flatten func(node Node) int
+	flatten = func(node Node) int {
+		if node == nil || reflect.ValueOf(node).IsNil() {
+			return -1
+		}
+		i := len(nodes)
+		nodes = append(nodes, record{segment: node.Segment(), overlap: node.Overlap()})
+		for _, intrvl := range nodes[i].overlap {
+			if !ids[intrvl.Id] {
+				ids[intrvl.Id] = true
+				intervals = append(intervals, intrvl)
+			}
+		}
+		right := flatten(node.Right())
+		left := flatten(node.Left())
+		nodes[i].left, nodes[i].right = left, right
+		return i
+	}
+	flatten(root)
+	sort.Sort(ById(intervals))
+	position := make(map[int]int, len(intervals))
+	for i, intrvl := range intervals {
+		position[intrvl.Id] = i
+	}
+	entries := 0
+	for i := range nodes {
+		nodes[i].first = entries
+		entries += len(nodes[i].overlap)
+		nodes[i].last = entries
+	}
+	buf := bufio.NewWriter(w)
+	if err := writeWord(buf, FLAT_MAGIC); err != nil {
+		return err
+	}
+	values := []int{len(nodes), len(intervals), entries}
+	for _, intrvl := range intervals {
+		values = append(values, intrvl.Id, intrvl.From, intrvl.To)
+	}
+	if err := writeInts(buf, values); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := writeInts(buf, []int{node.segment.From, node.segment.To, node.left, node.right, node.first, node.last}); err != nil {
+			return err
+		}
+	}
+	for _, node := range nodes {
+		values = values[:0]
+		for _, intrvl := range node.overlap {
+			values = append(values, position[intrvl.Id])
+		}
+		if err := writeInts(buf, values); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// writeInts writes values as little endian int64
+func writeInts(w io.Writer, values []int) error {
+	for _, value := range values {
+		if err := writeWord(w, uint64(int64(value))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWord writes a little endian 64 bit word
+func writeWord(w io.Writer, word uint64) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], word)
+	_, err := w.Write(b[:])
+	return err
+}
+
+// NewFlatTree returns a FlatTree that queries data written by WriteFlat
+// in place, e.g. a memory mapped file. Data is not copied and must not be
+// modified while the tree is used. Returns ErrInvalidFlat if the header
+// doesn't match the length of data or the sections reference positions
+// outside of data. Data is validated in one pass over the nodes and
+// overlap entries, so corrupt data can't make queries panic or loop.
+func NewFlatTree(data []byte) (FlatTree, error) {
+	t := &flat{data: data}
+	if len(data) < flatHeader*8 || len(data)%8 != 0 || t.word(0) != FLAT_MAGIC {
+		return nil, ErrInvalidFlat
+	}
+	nodes, intervals, entries := t.value(1), t.value(2), t.value(3)
+	size := len(data)/8 - flatHeader
+	if nodes < 1 || intervals < 0 || entries < 0 ||
+		nodes > size/flatNode || intervals > size/flatInterval || entries > size ||
+		size != intervals*flatInterval+nodes*flatNode+entries {
+		return nil, ErrInvalidFlat
+	}
+	t.intervals = flatHeader
+	t.nodes = t.intervals + intervals*flatInterval
+	t.overlap = t.nodes + nodes*flatNode
+	if !t.valid(nodes, intervals, entries) {
+		return nil, ErrInvalidFlat
+	}
+	return t, nil
+}
+
+// valid returns true if every child of a node follows it in preorder and
+// exists and the overlap entries of every node reference intervals. Child
+// positions greater than the position of the parent rule out cycles.
+func (t *flat) valid(nodes, intervals, entries int) bool {
+	for i := 0; i < nodes; i++ {
+		n := t.nodes + i*flatNode
+		for _, child := range []int{t.value(n + 2), t.value(n + 3)} {
+			if child != -1 && (child <= i || child >= nodes) {
+				return false
+			}
+		}
+		if first, last := t.value(n+4), t.value(n+5); first < 0 || first > last || last > entries {
+			return false
+		}
+	}
+	for e := 0; e < entries; e++ {
+		if pos := t.value(t.overlap + e); pos < 0 || pos >= intervals {
+			return false
+		}
+	}
+	return true
+}
+
+// word returns the 64 bit word at offset i
+func (t *flat) word(i int) uint64 {
+	return binary.LittleEndian.Uint64(t.data[i*8:])
+}
+
+// value returns the int64 value at offset i
+func (t *flat) value(i int) int {
+	return int(int64(t.word(i)))
+}
+
+// interval returns the interval at position pos
+func (t *flat) interval(pos int) Interval {
+	i := t.intervals + pos*flatInterval
+	return Interval{Id: t.value(i), Segment: Segment{t.value(i + 1), t.value(i + 2)}}
+}
+
+// Query interval
+func (t *flat) Query(from, to int) []Interval {
+	return t.QueryArray([]int{from}, []int{to})
+}
+
+// Query interval array, intervals that overlap any of the intervals of
+// the array are returned once
+func (t *flat) QueryArray(from, to []int) []Interval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	result := make([]Interval, 0, 10)
+	found := make(map[int]bool)
+	for i, fromvalue := range from {
+		t.query(0, fromvalue, to[i], func(pos int) bool {
+			if !found[pos] {
+				found[pos] = true
+				result = append(result, t.interval(pos))
+			}
+			return true
+		})
+	}
+	return result
+}
+
+// QueryView returns a sequence that yields overlapping intervals while
+// the tree is traversed
+func (t *flat) QueryView(from, to int) IntervalSeq {
+	return func(yield func(Interval) bool) {
+		found := make(map[int]bool)
+		t.query(0, from, to, func(pos int) bool {
+			if found[pos] {
+				return true
+			}
+			found[pos] = true
+			return yield(t.interval(pos))
+		})
+	}
+}
+
+// Count returns the number of intervals that overlap (from, to)
+func (t *flat) Count(from, to int) int {
+	count := 0
+	for range t.QueryView(from, to) {
+		count++
+	}
+	return count
+}
+
+// Stab returns the intervals that contain point
+func (t *flat) Stab(point int) []Interval {
+	return t.Query(point, point)
+}
+
+// query traverses tree from node i and passes the positions of overlapping
+// intervals to visit, returns false if visit stopped the traversal
+func (t *flat) query(i, from, to int, visit func(pos int) bool) bool {
+	n := t.nodes + i*flatNode
+	if !Overlaps(Segment{t.value(n), t.value(n + 1)}, from, to) {
+		return true
+	}
+	for e := t.value(n + 4); e < t.value(n+5); e++ {
+		if !visit(t.value(t.overlap + e)) {
+			return false
+		}
+	}
+	if right := t.value(n + 3); right >= 0 && !t.query(right, from, to, visit) {
+		return false
+	}
+	if left := t.value(n + 2); left >= 0 && !t.query(left, from, to, visit) {
+		return false
+	}
+	return true
+}
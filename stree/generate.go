@@ -0,0 +1,75 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Distribution of generated intervals
+type Distribution int
+
+const (
+	// Both endpoints uniformly distributed over [0, maxCoord]
+	UNIFORM Distribution = iota
+	// Short intervals around a few cluster centers, like reads of a gene
+	// sequence or events during peak hours
+	CLUSTERED
+)
+
+// GenerateIntervals returns n random intervals with coordinates in [0, maxCoord]
+// as from and to slices for PushArray. The intervals are generated with an own
+// source seeded by seed, the same arguments always result in the same intervals
+// and concurrent calls don't interfere. Panics with ErrUnknownDistribution if
+// dist is not defined.
+func GenerateIntervals(n, maxCoord int, seed int64, dist Distribution) (from, to []int) {
+	r := rand.New(rand.NewSource(seed))
+	coord := func() int {
+		if maxCoord == math.MaxInt {
+			return r.Int()
+		}
+		return r.Intn(maxCoord + 1)
+	}
+	from = make([]int, n)
+	to = make([]int, n)
+	switch dist {
+	case UNIFORM:
+		for i := range from {
+			from[i], to[i] = coord(), coord()
+			if from[i] > to[i] {
+				from[i], to[i] = to[i], from[i]
+			}
+		}
+	case CLUSTERED:
+		centers := make([]int, int(math.Sqrt(float64(n)))+1)
+		for i := range centers {
+			centers[i] = coord()
+		}
+		// spread of intervals around a center and mean length
+		spread := float64(maxCoord) / float64(len(centers)) / 10
+		length := spread / 10
+		for i := range from {
+			center := float64(centers[r.Intn(len(centers))])
+			start := clamp(center+r.NormFloat64()*spread, maxCoord)
+			from[i] = start
+			to[i] = clamp(float64(start)+r.ExpFloat64()*length, maxCoord)
+		}
+	default:
+		panic(ErrUnknownDistribution)
+	}
+	return
+}
+
+// clamp converts value to a coordinate in [0, maxCoord]
+func clamp(value float64, maxCoord int) int {
+	if value <= 0 {
+		return 0
+	}
+	if value >= float64(maxCoord) {
+		return maxCoord
+	}
+	return int(value)
+}
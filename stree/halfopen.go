@@ -0,0 +1,444 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import "math"
+
+// halfOpen is a segment tree of half-open intervals [from, to), e.g. time
+// ranges where an interval ending at 3 doesn't overlap one starting at 3.
+// On integer coordinates [from, to) covers the same coordinates as the
+// closed interval (from, to-1), which is stored in the underlying tree, so
+// its endpoints and elementary intervals are those of a closed tree.
+// Intervals and queries are converted by the overridden methods, methods
+// that are not overridden operate on the closed intervals of the underlying
+// tree, e.g. Root and SnapshotOverlaps.
+type halfOpen struct {
+	*stree
+}
+
+// NewTreeHalfOpen returns a SegmentTree interface with underlying segment tree
+// implementation for half-open intervals [from, to). Intervals need from < to,
+// queries with from >= to are empty and match no interval.
+func NewTreeHalfOpen() SegmentTree {
+	t := &halfOpen{stree: new(stree)}
+	t.Clear()
+	return t
+}
+
+// Push new interval [from, to) to stack
+func (t *halfOpen) Push(from, to int) {
+	t.check(from, to)
+	t.stree.Push(from, to-1)
+}
+
+// Push array of intervals to stack
+func (t *halfOpen) PushArray(from, to []int) {
+	for i := 0; i < len(from); i++ {
+		t.Push(from[i], to[i])
+	}
+}
+
+// Push new interval [from, to) with external key to stack
+func (t *halfOpen) PushKey(from, to int, key string) {
+	t.check(from, to)
+	t.stree.PushKey(from, to-1, key)
+}
+
+// Push new interval [from, to) with given Id to stack
+func (t *halfOpen) PushWithId(id, from, to int) {
+	t.check(from, to)
+	t.stree.PushWithId(id, from, to-1)
+}
+
+// PushIntervals pushes half-open intervals with their Ids and keys
+func (t *halfOpen) PushIntervals(intervals []Interval) {
+	closed := make([]Interval, len(intervals))
+	for i, intrvl := range intervals {
+		t.check(intrvl.From, intrvl.To)
+		closed[i] = intrvl
+		closed[i].To--
+	}
+	t.stree.PushIntervals(closed)
+}
+
+// Insert pushes interval [from, to) and inserts it into the built tree
+func (t *halfOpen) Insert(from, to int) {
+	t.check(from, to)
+	t.stree.Insert(from, to-1)
+}
+
+// Get interval by external key
+func (t *halfOpen) GetByKey(key string) (Interval, bool) {
+	intrvl, ok := t.stree.GetByKey(key)
+	if ok {
+		intrvl.To++
+	}
+	return intrvl, ok
+}
+
+// Intervals returns a copy of the pushed half-open intervals
+func (t *halfOpen) Intervals() []Interval {
+	return opened(t.stree.Intervals())
+}
+
+// PointIntervalCount returns 0, a half-open interval is never a point
+func (t *halfOpen) PointIntervalCount() int {
+	return 0
+}
+
+// CloneEmpty returns a half-open tree with a copy of the nodes of t but
+// no intervals
+func (t *halfOpen) CloneEmpty() SegmentTree {
+	return &halfOpen{stree: t.stree.CloneEmpty().(*stree)}
+}
+
+// Query interval [from, to)
+func (t *halfOpen) Query(from, to int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.Query(from, to-1))
+}
+
+// Query interval [from, to) with expected number of results
+func (t *halfOpen) QueryHint(from, to, expected int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.QueryHint(from, to-1, expected))
+}
+
+// Query interval array, empty queries are left out
+func (t *halfOpen) QueryArray(from, to []int) []Interval {
+	closedFrom, closedTo := closedQueries(from, to)
+	return opened(t.stree.QueryArray(closedFrom, closedTo))
+}
+
+// QueryArrayAll returns the intervals that overlap every interval of the array
+func (t *halfOpen) QueryArrayAll(from, to []int) []Interval {
+	return QueryAll(t.Query, from, to)
+}
+
+// QueryArrayAnnotated returns the intervals that overlap any interval of
+// the array with the indices of the queries they overlap
+func (t *halfOpen) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	groups := make([][]Interval, len(from))
+	for i, fromvalue := range from {
+		groups[i] = t.Query(fromvalue, to[i])
+	}
+	return Annotate(groups)
+}
+
+// Count returns the number of intervals that overlap [from, to)
+func (t *halfOpen) Count(from, to int) int {
+	if from >= to {
+		return 0
+	}
+	return t.stree.Count(from, to-1)
+}
+
+// QueryView returns a sequence that yields intervals overlapping [from, to)
+func (t *halfOpen) QueryView(from, to int) IntervalSeq {
+	if from >= to {
+		return func(yield func(Interval) bool) {}
+	}
+	seq := t.stree.QueryView(from, to-1)
+	return func(yield func(Interval) bool) {
+		for intrvl := range seq {
+			intrvl.To++
+			if !yield(intrvl) {
+				return
+			}
+		}
+	}
+}
+
+// QueryFunc calls fn for every interval overlapping [from, to) and stops
+// when fn returns false
+func (t *halfOpen) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.QueryView(from, to)(fn)
+}
+
+// QueryOrdered returns the intervals overlapping [from, to) sorted by From
+func (t *halfOpen) QueryOrdered(from, to int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.QueryOrdered(from, to-1))
+}
+
+// QueryInsertionOrder returns the intervals overlapping [from, to) sorted by Id
+func (t *halfOpen) QueryInsertionOrder(from, to int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.QueryInsertionOrder(from, to-1))
+}
+
+// QueryRecent returns the n intervals with the highest Ids overlapping [from, to)
+func (t *halfOpen) QueryRecent(from, to, n int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.QueryRecent(from, to-1, n))
+}
+
+// QueryTopK returns the k intervals overlapping [from, to) that are
+// greatest by less
+func (t *halfOpen) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	return greatest(t.Query(from, to), k, less)
+}
+
+// QueryMinLength returns the intervals overlapping [from, to) with
+// to - from >= minLen
+func (t *halfOpen) QueryMinLength(from, to, minLen int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.QueryMinLength(from, to-1, minLen-1))
+}
+
+// QueryLayered assigns the intervals overlapping [from, to) to layers,
+// intervals that only touch each other share a layer
+func (t *halfOpen) QueryLayered(from, to int) [][]Interval {
+	if from >= to {
+		return [][]Interval{}
+	}
+	layers := t.stree.QueryLayered(from, to-1)
+	for _, layer := range layers {
+		opened(layer)
+	}
+	return layers
+}
+
+// LayerCount returns the number of layers of QueryLayered
+func (t *halfOpen) LayerCount(from, to int) int {
+	if from >= to {
+		return 0
+	}
+	return t.stree.LayerCount(from, to-1)
+}
+
+// StabBest returns the interval containing point that is preferred by prefer
+func (t *halfOpen) StabBest(point int, prefer Preference) (Interval, bool) {
+	intrvl, ok := t.stree.StabBest(point, prefer)
+	if ok {
+		intrvl.To++
+	}
+	return intrvl, ok
+}
+
+// Stab returns the intervals that contain point, point = from is
+// contained, point = to isn't
+func (t *halfOpen) Stab(point int) []Interval {
+	return opened(t.stree.Stab(point))
+}
+
+// Nearest returns the interval nearest to point and its distance, the
+// distance of point >= to is point - to + 1 as to isn't contained
+func (t *halfOpen) Nearest(point int) (Interval, int, bool) {
+	intrvl, distance, ok := t.stree.Nearest(point)
+	if ok {
+		intrvl.To++
+	}
+	return intrvl, distance, ok
+}
+
+// Enclosing returns the intervals that contain [from, to)
+func (t *halfOpen) Enclosing(from, to int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.Enclosing(from, to-1))
+}
+
+// QueryStartsIn returns the intervals with From in [from, to), ordered by From
+func (t *halfOpen) QueryStartsIn(from, to int) []Interval {
+	if from >= to {
+		return []Interval{}
+	}
+	return opened(t.stree.QueryStartsIn(from, to-1))
+}
+
+// QueryEndsIn returns the intervals with the exclusive To in [from, to),
+// ordered by To
+func (t *halfOpen) QueryEndsIn(from, to int) []Interval {
+	closedFrom, closedTo, ok := closedEnds(from, to)
+	if !ok {
+		return []Interval{}
+	}
+	return opened(t.stree.QueryEndsIn(closedFrom, closedTo))
+}
+
+// FlowCounts returns the number of intervals with From in [from, to) and
+// the number with the exclusive To in [from, to)
+func (t *halfOpen) FlowCounts(from, to int) (starts, ends int) {
+	if from >= to {
+		return 0, 0
+	}
+	starts, _ = t.stree.FlowCounts(from, to-1)
+	if closedFrom, closedTo, ok := closedEnds(from, to); ok {
+		_, ends = t.stree.FlowCounts(closedFrom, closedTo)
+	}
+	return starts, ends
+}
+
+// closedEnds converts a range [from, to) of exclusive ends To to the range
+// (from-1, to-2) of the stored ends To-1. Stored ends are at least
+// math.MinInt, ok is false if the range contains none.
+func closedEnds(from, to int) (closedFrom, closedTo int, ok bool) {
+	if from >= to || to-1 == math.MinInt {
+		return 0, 0, false
+	}
+	return max(from, math.MinInt+1) - 1, to - 2, true
+}
+
+// FullyCovered returns true if every coordinate of [from, to) is covered,
+// an empty range is always covered
+func (t *halfOpen) FullyCovered(from, to int) bool {
+	if from >= to {
+		return true
+	}
+	return t.stree.FullyCovered(from, to-1)
+}
+
+// IsPartition returns true if the intervals tile [from, to), intervals that
+// touch each other don't overlap. An empty range is no partition.
+func (t *halfOpen) IsPartition(from, to int) bool {
+	if from >= to {
+		return false
+	}
+	return t.stree.IsPartition(from, to-1)
+}
+
+// AllGaps returns the uncovered half-open segments between min and max
+func (t *halfOpen) AllGaps() []Segment {
+	return openedSegments(t.stree.AllGaps())
+}
+
+// MergedSegments returns the union of all intervals as half-open segments,
+// touching intervals are merged
+func (t *halfOpen) MergedSegments() []Segment {
+	return openedSegments(t.stree.MergedSegments())
+}
+
+// Canonical returns the distinct half-open segments of all intervals
+func (t *halfOpen) Canonical() []Segment {
+	return openedSegments(t.stree.Canonical())
+}
+
+// CanonicalCounted returns the distinct half-open segments of all intervals
+// with the number of intervals of each segment
+func (t *halfOpen) CanonicalCounted() []SegmentCount {
+	counted := t.stree.CanonicalCounted()
+	for i := range counted {
+		counted[i].Segment.To++
+	}
+	return counted
+}
+
+// Join returns the pairs of Ids of overlapping intervals, other is queried
+// with the coordinates covered by the intervals of t
+func (t *halfOpen) Join(other Tree) [][2]int {
+	if o, ok := other.(*halfOpen); ok {
+		return t.stree.Join(o.stree)
+	}
+	return t.stree.Join(other)
+}
+
+// BuildTreeWithEndpoints builds the tree with the boundaries From and To
+// of half-open intervals as endpoints, min and max are the first and last
+// boundary. A boundary b is the From b or the stored To b-1 of the closed
+// intervals, so the underlying tree gets both as endpoints.
+func (t *halfOpen) BuildTreeWithEndpoints(endpoint []int, min, max int) {
+	closed := make([]int, 0, 2*len(endpoint))
+	for i, value := range endpoint {
+		if value != math.MinInt && (i == 0 || value-1 > endpoint[i-1]) {
+			closed = append(closed, value-1)
+		}
+		closed = append(closed, value)
+	}
+	if min != math.MinInt {
+		min--
+	}
+	t.stree.BuildTreeWithEndpoints(closed, min, max)
+}
+
+// QueryWithGaps returns the intervals overlapping [from, to) clipped to it
+// and sorted by From, and the half-open segments of the range they don't
+// cover
+func (t *halfOpen) QueryWithGaps(from, to int) ([]Interval, []Segment) {
+	if from >= to {
+		return []Interval{}, []Segment{}
+	}
+	result, gaps := t.stree.QueryWithGaps(from, to-1)
+	return opened(result), openedSegments(gaps)
+}
+
+// RenderView maps the intervals overlapping [from, to) to pixels of a row
+// of width, the row spans the coordinates from to to-1. Panics with
+// ErrInvalidViewport if width <= 0 or from >= to.
+func (t *halfOpen) RenderView(from, to, width int) []RenderedInterval {
+	if from >= to {
+		panic(ErrInvalidViewport)
+	}
+	rendered := t.stree.RenderView(from, to-1, width)
+	for i := range rendered {
+		rendered[i].Interval.To++
+	}
+	return rendered
+}
+
+// QueryRelative returns the intervals overlapping [from, to) clipped to it
+// as half-open segments shifted so that from becomes 0
+func (t *halfOpen) QueryRelative(from, to int) []Segment {
+	if from >= to {
+		return []Segment{}
+	}
+	return openedSegments(t.stree.QueryRelative(from, to-1))
+}
+
+// check panics if interval [from, to) is empty
+func (t *halfOpen) check(from, to int) {
+	if from >= to {
+		panic(ErrEmptyHalfOpen)
+	}
+}
+
+// closedQueries converts the half-open queries of an array to closed
+// queries, empty queries are left out
+func closedQueries(from, to []int) (closedFrom, closedTo []int) {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	closedFrom = make([]int, 0, len(from))
+	closedTo = make([]int, 0, len(to))
+	for i, fromvalue := range from {
+		if fromvalue < to[i] {
+			closedFrom = append(closedFrom, fromvalue)
+			closedTo = append(closedTo, to[i]-1)
+		}
+	}
+	return
+}
+
+// opened converts the closed intervals of result to half-open intervals in place
+func opened(result []Interval) []Interval {
+	for i := range result {
+		result[i].To++
+	}
+	return result
+}
+
+// openedSegments converts closed segments to half-open segments in place
+func openedSegments(segments []Segment) []Segment {
+	for i := range segments {
+		segments[i].To++
+	}
+	return segments
+}
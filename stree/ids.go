@@ -0,0 +1,128 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import "iter"
+
+// IdInterval is an interval of an IdTree with the Id given by the caller
+type IdInterval[ID comparable] struct {
+	Interval
+	ID ID
+}
+
+// IdTree indexes intervals with Ids of any comparable type, e.g. strings,
+// UUIDs or structs. Each ID is mapped to an int Id of an underlying segment
+// tree, results carry the original ID. Intervals pushed with the same ID are
+// merged in query results like intervals pushed with the same Id by
+// PushWithId. Interval and the Tree interface keep their int Ids, a type
+// parameter on them would change every implementation and caller, so
+// IdTree wraps a tree and offers the common methods only: pushing,
+// building, removing and the basic queries.
+type IdTree[ID comparable] struct {
+	tree SegmentTree
+	// ID of each int Id
+	ids []ID
+	// int Id of each ID
+	index map[ID]int
+}
+
+// NewIdTree returns an IdTree with underlying segment tree implementation
+func NewIdTree[ID comparable]() *IdTree[ID] {
+	return &IdTree[ID]{tree: NewTree(), index: make(map[ID]int)}
+}
+
+// Push new interval with given ID to stack
+func (t *IdTree[ID]) Push(id ID, from, to int) {
+	i, ok := t.index[id]
+	if !ok {
+		i = len(t.ids)
+		t.index[id] = i
+		t.ids = append(t.ids, id)
+	}
+	t.tree.PushWithId(i, from, to)
+}
+
+// Clear the interval stack
+func (t *IdTree[ID]) Clear() {
+	t.tree.Clear()
+	t.ids = t.ids[:0]
+	t.index = make(map[ID]int)
+}
+
+// Build segment tree out of interval stack, ErrNoIntervals if stack is empty
+func (t *IdTree[ID]) BuildTree() error {
+	return t.tree.BuildTree()
+}
+
+// Number of pushed intervals
+func (t *IdTree[ID]) Len() int {
+	return t.tree.Len()
+}
+
+// Remove deletes all intervals with given ID from the stack and the built
+// tree, returns false if there is no such interval. The ID keeps its int
+// Id, pushing it again reuses it.
+func (t *IdTree[ID]) Remove(id ID) bool {
+	i, ok := t.index[id]
+	if !ok {
+		return false
+	}
+	removed := false
+	for t.tree.Remove(i) {
+		removed = true
+	}
+	return removed
+}
+
+// Query interval
+func (t *IdTree[ID]) Query(from, to int) []IdInterval[ID] {
+	return t.withIds(t.tree.Query(from, to))
+}
+
+// Query interval array, intervals overlapping any interval (union)
+func (t *IdTree[ID]) QueryArray(from, to []int) []IdInterval[ID] {
+	return t.withIds(t.tree.QueryArray(from, to))
+}
+
+// Number of intervals overlapping interval, intervals with the same ID count once
+func (t *IdTree[ID]) Count(from, to int) int {
+	return t.tree.Count(from, to)
+}
+
+// Intervals that contain point, intervals with the same ID are merged
+func (t *IdTree[ID]) Stab(point int) []IdInterval[ID] {
+	result := t.tree.Stab(point)
+	// int Ids are dense, see Push
+	visited := make(visitedBits, (len(t.ids)+63)/64)
+	n := 0
+	for _, intrvl := range result {
+		if visited.visit(intrvl.Id) {
+			result[n] = intrvl
+			n++
+		}
+	}
+	return t.withIds(result[:n])
+}
+
+// Query interval lazily as sequence
+func (t *IdTree[ID]) QueryView(from, to int) iter.Seq[IdInterval[ID]] {
+	view := t.tree.QueryView(from, to)
+	return func(yield func(IdInterval[ID]) bool) {
+		for intrvl := range view {
+			if !yield(IdInterval[ID]{Interval: intrvl, ID: t.ids[intrvl.Id]}) {
+				return
+			}
+		}
+	}
+}
+
+// withIds adds the ID to each interval of result
+func (t *IdTree[ID]) withIds(result []Interval) []IdInterval[ID] {
+	withIds := make([]IdInterval[ID], len(result))
+	for i, intrvl := range result {
+		withIds[i] = IdInterval[ID]{Interval: intrvl, ID: t.ids[intrvl.Id]}
+	}
+	return withIds
+}
@@ -0,0 +1,203 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"slices"
+	"sort"
+)
+
+// EndpointIndex holds the positions of intervals in the interval stack
+// sorted by From and by To, lookups by endpoint take O(log n).
+// The index is only valid as long as the stack is not modified.
+type EndpointIndex struct {
+	byFrom []int
+	byTo   []int
+}
+
+// NewEndpointIndex builds the index for given interval stack, ties are
+// ordered as in ByFrom and ByTo
+func NewEndpointIndex(base []Interval) EndpointIndex {
+	index := EndpointIndex{byFrom: make([]int, len(base)), byTo: make([]int, len(base))}
+	for i := range base {
+		index.byFrom[i] = i
+		index.byTo[i] = i
+	}
+	sort.Slice(index.byFrom, func(i, j int) bool { return lessFrom(base, index.byFrom[i], index.byFrom[j]) })
+	sort.Slice(index.byTo, func(i, j int) bool { return lessTo(base, index.byTo[i], index.byTo[j]) })
+	return index
+}
+
+// lessFrom orders the positions i and j of base by From, To and position
+func lessFrom(base []Interval, i, j int) bool {
+	a, b := &base[i], &base[j]
+	if a.From != b.From {
+		return a.From < b.From
+	}
+	if a.To != b.To {
+		return a.To < b.To
+	}
+	return i < j
+}
+
+// lessTo orders the positions i and j of base by To, From and position
+func lessTo(base []Interval, i, j int) bool {
+	a, b := &base[i], &base[j]
+	if a.To != b.To {
+		return a.To < b.To
+	}
+	if a.From != b.From {
+		return a.From < b.From
+	}
+	return i < j
+}
+
+// Insert adds the interval at position i of base to an index of the other
+// intervals of base in O(n), which keeps the index valid after an interval
+// was pushed to the stack
+func (index *EndpointIndex) Insert(base []Interval, i int) {
+	index.byFrom = insertPosition(index.byFrom, base, i, lessFrom)
+	index.byTo = insertPosition(index.byTo, base, i, lessTo)
+}
+
+// Remove updates an index of base plus one interval in O(n) after that
+// interval was removed from position i of base and the interval after the
+// end of base, at position len(base), took its place
+func (index *EndpointIndex) Remove(base []Interval, i int) {
+	index.byFrom = removePosition(index.byFrom, base, i, lessFrom)
+	index.byTo = removePosition(index.byTo, base, i, lessTo)
+}
+
+// insertPosition inserts position i into the sorted positions
+func insertPosition(positions []int, base []Interval, i int, less func(base []Interval, i, j int) bool) []int {
+	k := sort.Search(len(positions), func(k int) bool { return less(base, i, positions[k]) })
+	return slices.Insert(positions, k, i)
+}
+
+// removePosition removes position i from the sorted positions and moves
+// position len(base) to i
+func removePosition(positions []int, base []Interval, i int, less func(base []Interval, i, j int) bool) []int {
+	positions = slices.DeleteFunc(positions, func(pos int) bool { return pos == i })
+	if i == len(base) {
+		return positions
+	}
+	// position breaks ties, the moved interval takes a new place among equal ones
+	positions = slices.DeleteFunc(positions, func(pos int) bool { return pos == len(base) })
+	return insertPosition(positions, base, i, less)
+}
+
+// Len returns the number of intervals in the index
+func (index EndpointIndex) Len() int {
+	return len(index.byFrom)
+}
+
+// StartsIn returns the intervals of base with from <= From <= to in ascending order of From
+func (index EndpointIndex) StartsIn(base []Interval, from, to int) []Interval {
+	start, end := index.startsIn(base, from, to)
+	return collect(base, index.byFrom, start, end)
+}
+
+// EndsIn returns the intervals of base with from <= To <= to in ascending order of To
+func (index EndpointIndex) EndsIn(base []Interval, from, to int) []Interval {
+	start, end := index.endsIn(base, from, to)
+	return collect(base, index.byTo, start, end)
+}
+
+// FlowCounts returns the number of intervals of base that start in (from, to)
+// and the number that end in (from, to), counted by binary search in O(log n)
+func (index EndpointIndex) FlowCounts(base []Interval, from, to int) (starts, ends int) {
+	start, end := index.startsIn(base, from, to)
+	starts = max(end-start, 0)
+	start, end = index.endsIn(base, from, to)
+	ends = max(end-start, 0)
+	return
+}
+
+// startsIn returns the range of byFrom with from <= From <= to
+func (index EndpointIndex) startsIn(base []Interval, from, to int) (start, end int) {
+	start = sort.Search(len(index.byFrom), func(i int) bool { return base[index.byFrom[i]].From >= from })
+	end = sort.Search(len(index.byFrom), func(i int) bool { return base[index.byFrom[i]].From > to })
+	return
+}
+
+// endsIn returns the range of byTo with from <= To <= to
+func (index EndpointIndex) endsIn(base []Interval, from, to int) (start, end int) {
+	start = sort.Search(len(index.byTo), func(i int) bool { return base[index.byTo[i]].To >= from })
+	end = sort.Search(len(index.byTo), func(i int) bool { return base[index.byTo[i]].To > to })
+	return
+}
+
+// Covers returns true if every coordinate of (from, to) is covered by an
+// interval of base. The intervals are swept in order of From, the sweep
+// stops at the first uncovered coordinate.
+func (index EndpointIndex) Covers(base []Interval, from, to int) bool {
+	if from > to {
+		return false
+	}
+	// first coordinate not covered yet
+	next := from
+	for _, pos := range index.byFrom {
+		intrvl := &base[pos]
+		if intrvl.From > next {
+			return false
+		}
+		if intrvl.To >= next {
+			if intrvl.To >= to {
+				return true
+			}
+			next = intrvl.To + 1
+		}
+	}
+	return false
+}
+
+// HasOverlaps returns true if any two intervals of base overlap, touching
+// intervals like (1,5) and (5,9) overlap. The intervals are swept in order
+// of From, the sweep stops at the first overlap.
+func (index EndpointIndex) HasOverlaps(base []Interval) bool {
+	for i := 1; i < len(index.byFrom); i++ {
+		// the previous interval ends last, otherwise it would overlap too
+		if base[index.byFrom[i]].From <= base[index.byFrom[i-1]].To {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPartition returns true if the intervals of base tile (from, to): every
+// coordinate of the range is covered by exactly one interval and no interval
+// exceeds the range. Intervals are closed, so touching intervals like (1,5)
+// and (5,9) overlap at 5, while (1,4) and (5,9) tile (1,9). The intervals
+// are swept once in order of From.
+func (index EndpointIndex) IsPartition(base []Interval, from, to int) bool {
+	if from > to || len(index.byFrom) == 0 {
+		return false
+	}
+	// first coordinate not covered yet
+	next := from
+	for i, pos := range index.byFrom {
+		intrvl := &base[pos]
+		if intrvl.From != next || intrvl.To > to {
+			return false
+		}
+		if intrvl.To == to {
+			return i == len(index.byFrom)-1
+		}
+		next = intrvl.To + 1
+	}
+	return false
+}
+
+// collect returns the intervals at positions[start:end]
+func collect(base []Interval, positions []int, start, end int) []Interval {
+	if end < start {
+		end = start
+	}
+	result := make([]Interval, 0, end-start)
+	for _, pos := range positions[start:end] {
+		result = append(result, base[pos])
+	}
+	return result
+}
@@ -0,0 +1,245 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"reflect"
+	"slices"
+)
+
+// Insert pushes a new interval to the stack. If the tree is already built
+// and from and to are boundaries of its leaves, the interval is inserted
+// into the existing nodes in O(log n), otherwise the tree is rebuilt. The
+// interval is added to the endpoint index in O(n). Insert doesn't make the
+// tree dirty, intervals pushed before are still missing until it is rebuilt.
+func (t *stree) Insert(from, to int) {
+	dirty := t.dirty
+	t.Push(from, to)
+	if t.root == nil {
+		return
+	}
+	if from >= t.min && to <= t.max && Aligned(t.root, from, to) {
+		// nodes may still point into the previous array if append reallocated
+		// the stack, these intervals are equal to those of the stack
+		insertInterval(t.root, &t.base[len(t.base)-1])
+		if t.index.Len() == len(t.base)-1 {
+			t.index.Insert(t.base, len(t.base)-1)
+		}
+		t.dirty = dirty
+	} else {
+		t.BuildTree()
+	}
+}
+
+// Remove deletes the interval with given Id from the stack and from the
+// nodes of the built tree, returns false if there is no such interval. The
+// tree is not rebuilt, the nodes of the interval are found by its segment
+// in O(log n). Only the interval with the Id is removed, an equal interval
+// pushed separately is kept. The last interval of the stack takes the place
+// of the removed one, so Ids no longer match positions in the stack. The
+// endpoint index is updated in O(n).
+func (t *stree) Remove(id int) bool {
+	i := t.position(id)
+	if i < 0 {
+		return false
+	}
+	if t.root == nil {
+		t.removeAt(i)
+		return true
+	}
+	removeInterval(t.root, t.base[i].Segment, id, nil)
+	t.removeAt(i)
+	if i < len(t.base) {
+		// nodes of the moved interval point to its previous position
+		removeInterval(t.root, t.base[i].Segment, t.base[i].Id, &t.base[i])
+	}
+	t.removed++
+	if t.autoCompact > 0 && len(t.base) > 0 && t.RemovedRatio() >= t.autoCompact {
+		t.Compact()
+	}
+	return true
+}
+
+// removeAt removes the interval at position i from the stack, the last
+// interval of the stack takes its place
+func (t *stack) removeAt(i int) {
+	removed := t.base[i]
+	if removed.Key != "" && t.keys[removed.Key] == i {
+		delete(t.keys, removed.Key)
+	}
+	last := len(t.base) - 1
+	if i != last {
+		t.base[i] = t.base[last]
+		if t.base[i].Key != "" {
+			t.keys[t.base[i].Key] = i
+		}
+		t.sparse = true
+	}
+	t.base = t.base[:last]
+	if removed.From == removed.To {
+		t.points--
+	}
+	if t.index.Len() == last+1 {
+		t.index.Remove(t.base, i)
+	} else {
+		// pushed intervals are missing in the index, a shorter stack could
+		// match its length
+		t.index = EndpointIndex{}
+	}
+}
+
+// NewTreeAutoCompact returns a segment tree that compacts itself in Remove
+// once RemovedRatio reaches threshold, which must be in (0, 1). A
+// compaction rebuilds the tree in O(n log n) for n remaining intervals
+// after at least threshold * n / (1 - threshold) removals, that is an
+// amortized O(log n / threshold) per Remove. The nodes are replaced, so
+// results derived from them before, e.g. CanonicalNodes, snapshots or
+// cached query results, must not be reused afterwards.
+func NewTreeAutoCompact(threshold float64) SegmentTree {
+	if !(threshold > 0 && threshold < 1) {
+		panic(ErrInvalidThreshold)
+	}
+	t := new(stree)
+	t.autoCompact = threshold
+	t.Clear()
+	return t
+}
+
+// RemovedRatio returns the fraction of intervals removed since the tree was
+// built, 0 if the tree was built from the current stack. Remove deletes an
+// interval from the nodes, but the leaves of its endpoints stay in the tree
+// until it is rebuilt, so queries traverse more nodes than necessary.
+func (t *stree) RemovedRatio() float64 {
+	if t.removed == 0 {
+		return 0
+	}
+	return float64(t.removed) / float64(t.removed+len(t.base))
+}
+
+// IsSkewed returns true if the leaves of the built tree no longer match the
+// endpoints of the stack, so that BuildTree would at least halve them and
+// save a level on the path of every query, see Skewed.
+func (t *stree) IsSkewed() bool {
+	return t.root != nil && Skewed(t.root, t.base)
+}
+
+// Skewed returns true if the tree of root has at least twice the leaves of
+// a tree built from base. Insert keeps the leaves matched to the stack: an
+// interval whose endpoints aren't boundaries of leaves rebuilds the tree,
+// the others are inserted into the existing nodes without adding leaves, no
+// matter how many of them fall into the same few leaves. The leaves of
+// removed intervals however stay in the tree until it is rebuilt. The
+// endpoints are sorted to compare them, which takes O(n log n).
+func Skewed(root Node, base []Interval) bool {
+	if len(base) == 0 {
+		return false
+	}
+	leaves := 0
+	traverse(root, func(n Node) {
+		if reflect.ValueOf(n.Left()).IsNil() {
+			leaves++
+		}
+	}, nil)
+	endpoint, _, _ := Endpoints(base)
+	needed := len(endpoint)
+	for i := 1; i < len(endpoint); i++ {
+		// a leaf between two endpoints, see ElementaryIntervals
+		if endpoint[i]-1 > endpoint[i-1] {
+			needed++
+		}
+	}
+	return leaves >= 2*needed
+}
+
+// Compact rebuilds the tree from the intervals of the stack, which drops
+// the leaves of removed intervals. Returns ErrEmptyTree if the tree isn't
+// built and ErrNoIntervals if all intervals were removed, the tree is
+// unchanged then.
+func (t *stree) Compact() error {
+	if t.root == nil {
+		return ErrEmptyTree
+	}
+	return t.BuildTree()
+}
+
+// position returns the position of the interval with given Id in the
+// stack, -1 if there is no such interval
+func (t *stack) position(id int) int {
+	if !t.sparse {
+		if id >= 0 && id < len(t.base) {
+			return id
+		}
+		return -1
+	}
+	for i := range t.base {
+		if t.base[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeInterval removes the interval with given Id from the nodes of seg,
+// or replaces it by replace if it is not nil
+func removeInterval(node *node, seg Segment, id int, replace *Interval) {
+	switch node.segment.CompareTo(&seg) {
+	case SUBSET:
+		for j, pintrvl := range node.overlap {
+			if pintrvl.Id == id {
+				if replace != nil {
+					node.overlap[j] = replace
+				} else {
+					node.overlap = slices.Delete(node.overlap, j, j+1)
+				}
+				break
+			}
+		}
+	case INTERSECT_OR_SUPERSET:
+		if node.left != nil {
+			removeInterval(node.left, seg, id, replace)
+		}
+		if node.right != nil {
+			removeInterval(node.right, seg, id, replace)
+		}
+	}
+}
+
+// CloneEmpty returns a tree with a copy of the nodes of t but no intervals,
+// e.g. to insert a different set of intervals over the same leaves with
+// Insert. The overlap function and the order of results are kept.
+func (t *stree) CloneEmpty() SegmentTree {
+	if t.root == nil {
+		panic(ErrEmptyTree)
+	}
+	c := new(stree)
+	c.overlaps, c.less = t.overlaps, t.less
+	c.Clear()
+	c.min, c.max = t.min, t.max
+	c.root = cloneEmpty(t.root)
+	return c
+}
+
+// cloneEmpty copies the nodes of tree without overlapping intervals
+func cloneEmpty(n *node) *node {
+	if n == nil {
+		return nil
+	}
+	return &node{segment: n.segment, left: cloneEmpty(n.left), right: cloneEmpty(n.right)}
+}
+
+// Aligned returns true if (from, to) doesn't cover a leaf of tree only
+// partially, i.e. an interval (from, to) can be inserted into the nodes
+func Aligned(root Node, from, to int) bool {
+	seg := root.Segment()
+	if seg.CompareTo(&Segment{from, to}) != INTERSECT_OR_SUPERSET {
+		return true
+	}
+	left, right := root.Left(), root.Right()
+	if reflect.ValueOf(left).IsNil() {
+		// a leaf covered partially
+		return false
+	}
+	return Aligned(left, from, to) && Aligned(right, from, to)
+}
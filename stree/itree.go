@@ -0,0 +1,395 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"slices"
+	"sort"
+)
+
+// itree is an augmented interval tree: a balanced binary search tree of the
+// intervals keyed by From, every node holds the maximum To of its subtree.
+// The tree is stored implicitly in a slice sorted by From, the node of the
+// range [lo, hi) is at the middle of the range and its children are the
+// nodes of the halves. So the tree takes O(n) space compared to O(n log n)
+// of the segment tree, a query takes O(log n + k) for k results. Methods
+// that don't query the tree are those of the serial structure.
+type itree struct {
+	serial
+	// intervals of the stack sorted by ByFrom, nil before tree is built
+	nodes []Interval
+	// maximum To of the subtree of each node
+	maxTo []int
+}
+
+// NewIntervalTree returns a Tree interface with underlying interval tree,
+// queries return the same intervals as the segment tree. Methods that
+// depend on the nodes of a segment tree like Print() and Tree2Array()
+// are not supported.
+func NewIntervalTree() Tree {
+	t := new(itree)
+	t.Clear()
+	return t
+}
+
+// Clear the interval stack and the tree
+func (t *itree) Clear() {
+	t.serial.Clear()
+	t.nodes = nil
+	t.maxTo = nil
+}
+
+// GobDecode replaces the interval stack with an encoded one and clears the
+// tree, see stree.GobDecode
+func (t *itree) GobDecode(data []byte) error {
+	t.nodes, t.maxTo = nil, nil
+	return t.serial.GobDecode(data)
+}
+
+// Built returns true if the tree is built and can be queried
+func (t *itree) Built() bool {
+	return t.nodes != nil
+}
+
+// Dirty returns true if the stack changed since the tree was built, see
+// stree.Dirty. Insert and Remove change the stack and the nodes alike, a
+// push changes only the stack.
+func (t *itree) Dirty() bool {
+	return t.nodes != nil && len(t.nodes) != len(t.base)
+}
+
+// checkBuilt panics with ErrEmptyTree if the tree is not built and with
+// ErrDirtyTree if intervals were pushed since it was built
+func (t *itree) checkBuilt() {
+	if t.nodes == nil {
+		panic(ErrEmptyTree)
+	}
+	if t.Dirty() {
+		panic(ErrDirtyTree)
+	}
+}
+
+// BuildTree sorts the interval stack by From and computes the maximum To
+// of every subtree, returns ErrNoIntervals if the stack is empty
+func (t *itree) BuildTree() error {
+	if len(t.base) == 0 {
+		return ErrNoIntervals
+	}
+	t.nodes = slices.Clone(t.base)
+	SortByFrom(t.nodes)
+	t.maxTo = make([]int, len(t.nodes))
+	t.augment(0, len(t.nodes))
+	return nil
+}
+
+// augment sets maxTo of the node of range [lo, hi) and its subtree
+func (t *itree) augment(lo, hi int) int {
+	mid := int(uint(lo+hi) >> 1)
+	maxTo := t.nodes[mid].To
+	if lo < mid {
+		maxTo = max(maxTo, t.augment(lo, mid))
+	}
+	if mid+1 < hi {
+		maxTo = max(maxTo, t.augment(mid+1, hi))
+	}
+	t.maxTo[mid] = maxTo
+	return maxTo
+}
+
+// Insert pushes a new interval and inserts it into the built tree. The
+// nodes shift by one position, so maxTo is recomputed in O(n).
+func (t *itree) Insert(from, to int) {
+	t.Push(from, to)
+	if t.nodes == nil {
+		return
+	}
+	intrvl := t.base[len(t.base)-1]
+	// position after all nodes that precede the interval in ByFrom order
+	i := sort.Search(len(t.nodes), func(i int) bool {
+		node := t.nodes[i]
+		if node.From != intrvl.From {
+			return node.From > intrvl.From
+		}
+		if node.To != intrvl.To {
+			return node.To > intrvl.To
+		}
+		return node.Id > intrvl.Id
+	})
+	t.nodes = slices.Insert(t.nodes, i, intrvl)
+	t.maxTo = append(t.maxTo, 0)
+	t.augment(0, len(t.nodes))
+}
+
+// Remove deletes the interval with given Id from the stack and from the
+// built tree, see stree.Remove. The nodes shift by one position, so maxTo is
+// recomputed in O(n).
+func (t *itree) Remove(id int) bool {
+	if !t.serial.Remove(id) {
+		return false
+	}
+	if t.nodes != nil {
+		i := slices.IndexFunc(t.nodes, func(intrvl Interval) bool { return intrvl.Id == id })
+		if i >= 0 {
+			t.nodes = slices.Delete(t.nodes, i, i+1)
+			t.maxTo = t.maxTo[:len(t.nodes)]
+			if len(t.nodes) > 0 {
+				t.augment(0, len(t.nodes))
+			}
+		}
+	}
+	return true
+}
+
+// query passes the positions of the nodes of range [lo, hi) that overlap
+// (from, to) to visit in order of From, returns false if visit stopped
+// the traversal
+func (t *itree) query(lo, hi, from, to int, visit func(i int) bool) bool {
+	if lo >= hi {
+		return true
+	}
+	mid := int(uint(lo+hi) >> 1)
+	if t.maxTo[mid] < from {
+		// no interval of the subtree reaches from
+		return true
+	}
+	if !t.query(lo, mid, from, to, visit) {
+		return false
+	}
+	if t.nodes[mid].From > to {
+		// node and right subtree start after to
+		return true
+	}
+	if t.nodes[mid].To >= from && !visit(mid) {
+		return false
+	}
+	return t.query(mid+1, hi, from, to, visit)
+}
+
+// firstOfId returns a function that reports if the node at position i is
+// the first of its Id in a traversal. Like the segment tree merges
+// intervals with the same Id, the tree reports only the first. Ids are
+// unique unless given by PushWithId or moved by Remove, then no set is needed.
+func (t *itree) firstOfId() func(i int) bool {
+	if !t.sparse {
+		return func(int) bool { return true }
+	}
+	visited := t.newVisited()
+	return func(i int) bool { return visited.visit(t.nodes[i].Id) }
+}
+
+// Query interval
+func (t *itree) Query(from, to int) []Interval {
+	return t.QueryHint(from, to, 0)
+}
+
+// Query interval, the result is pre-sized to the expected number of
+// results. The tree prunes subtrees by the closed interval overlap, with a
+// custom OverlapFunc the interval stack is searched sequentially.
+func (t *itree) QueryHint(from, to, expected int) []Interval {
+	t.checkBuilt()
+	if t.overlaps != nil {
+		return t.serial.QueryHint(from, to, expected)
+	}
+	result := make([]Interval, 0, expected)
+	first := t.firstOfId()
+	t.query(0, len(t.nodes), from, to, func(i int) bool {
+		if first(i) {
+			result = append(result, t.nodes[i])
+		}
+		return true
+	})
+	return t.sorted(result)
+}
+
+// Count returns the number of overlapping intervals without collecting them
+func (t *itree) Count(from, to int) int {
+	t.checkBuilt()
+	if t.overlaps != nil {
+		return t.serial.Count(from, to)
+	}
+	count := 0
+	first := t.firstOfId()
+	t.query(0, len(t.nodes), from, to, func(i int) bool {
+		if first(i) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// QueryView returns a sequence that yields overlapping intervals in order
+// of From while the tree is traversed
+func (t *itree) QueryView(from, to int) IntervalSeq {
+	t.checkBuilt()
+	if t.overlaps != nil {
+		return t.serial.QueryView(from, to)
+	}
+	return func(yield func(Interval) bool) {
+		first := t.firstOfId()
+		t.query(0, len(t.nodes), from, to, func(i int) bool {
+			return !first(i) || yield(t.nodes[i])
+		})
+	}
+}
+
+// QueryFunc calls fn for every overlapping interval in order of From and
+// stops when fn returns false
+func (t *itree) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.QueryView(from, to)(fn)
+}
+
+// Query interval array, intervals that overlap any of the intervals of
+// the array are returned once, deduplicated by Id
+func (t *itree) QueryArray(from, to []int) []Interval {
+	t.checkBuilt()
+	if t.overlaps != nil {
+		return t.serial.QueryArray(from, to)
+	}
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	visited := t.newVisited()
+	result := make([]Interval, 0, 10)
+	for i, fromvalue := range from {
+		t.query(0, len(t.nodes), fromvalue, to[i], func(pos int) bool {
+			if visited.visit(t.nodes[pos].Id) {
+				result = append(result, t.nodes[pos])
+			}
+			return true
+		})
+	}
+	return t.sorted(result)
+}
+
+// QueryOrdered returns the overlapping intervals sorted by From, the
+// traversal of the tree finds them in this order
+func (t *itree) QueryOrdered(from, to int) []Interval {
+	result := t.Query(from, to)
+	if t.less != nil || t.overlaps != nil {
+		SortByFrom(result)
+	}
+	return result
+}
+
+// Depth returns the number of intervals that contain point
+func (t *itree) Depth(point int) int {
+	t.checkBuilt()
+	depth := 0
+	t.query(0, len(t.nodes), point, point, func(i int) bool {
+		depth++
+		return true
+	})
+	return depth
+}
+
+// Stab returns the intervals that contain point
+func (t *itree) Stab(point int) []Interval {
+	t.checkBuilt()
+	result := make([]Interval, 0, 10)
+	t.query(0, len(t.nodes), point, point, func(i int) bool {
+		result = append(result, t.nodes[i])
+		return true
+	})
+	return result
+}
+
+// Enclosing returns the intervals that contain (from, to), those of the
+// intervals overlapping from that reach to
+func (t *itree) Enclosing(from, to int) []Interval {
+	result := make([]Interval, 0, 10)
+	if from > to {
+		return result
+	}
+	t.checkBuilt()
+	t.query(0, len(t.nodes), from, from, func(i int) bool {
+		if t.nodes[i].To >= to {
+			result = append(result, t.nodes[i])
+		}
+		return true
+	})
+	return result
+}
+
+// QueryMinLength returns the overlapping intervals with To - From >= minLen
+func (t *itree) QueryMinLength(from, to, minLen int) []Interval {
+	return minLength(t.QueryView(from, to), minLen)
+}
+
+// QueryRecent returns the n overlapping intervals with the highest Ids
+func (t *itree) QueryRecent(from, to, n int) []Interval {
+	return mostRecent(t.Query(from, to), n)
+}
+
+// QueryInsertionOrder returns the overlapping intervals sorted by Id
+func (t *itree) QueryInsertionOrder(from, to int) []Interval {
+	return insertionOrder(t.Query(from, to))
+}
+
+// StabBest returns the interval containing point that is preferred by prefer
+func (t *itree) StabBest(point int, prefer Preference) (Interval, bool) {
+	return Best(t.QueryView(point, point), prefer)
+}
+
+// QueryTopK returns the k overlapping intervals that are greatest by less
+func (t *itree) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	return greatest(t.Query(from, to), k, less)
+}
+
+// QueryArrayAnnotated returns the intervals that overlap any interval of the
+// array with the indices of the queries they overlap
+func (t *itree) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	groups := make([][]Interval, len(from))
+	for i, fromvalue := range from {
+		groups[i] = t.Query(fromvalue, to[i])
+	}
+	return Annotate(groups)
+}
+
+// QueryArrayAll returns the intervals that overlap every interval of the array
+func (t *itree) QueryArrayAll(from, to []int) []Interval {
+	return QueryAll(t.Query, from, to)
+}
+
+// QueryWithGaps returns the overlapping intervals and uncovered segments clipped to the query
+func (t *itree) QueryWithGaps(from, to int) ([]Interval, []Segment) {
+	return ClipWithGaps(t.Query(from, to), from, to)
+}
+
+// RenderView returns the overlapping intervals clipped to the viewport
+// (from, to), sorted by From and mapped to pixels of a row of width, see Render
+func (t *itree) RenderView(from, to, width int) []RenderedInterval {
+	if width <= 0 || from > to {
+		panic(ErrInvalidViewport)
+	}
+	return Render(t.Query(from, to), from, to, width)
+}
+
+// QueryRelative returns the overlapping intervals clipped to the query and
+// shifted so that from becomes 0, see Relative
+func (t *itree) QueryRelative(from, to int) []Segment {
+	return Relative(t.Query(from, to), from, to)
+}
+
+// Query interval and assign result to non-overlapping layers
+func (t *itree) QueryLayered(from, to int) [][]Interval {
+	return Layers(t.Query(from, to))
+}
+
+// LayerCount returns the number of layers of QueryLayered without building them
+func (t *itree) LayerCount(from, to int) int {
+	return LayerCount(t.Query(from, to))
+}
+
+func (t *itree) Print() {
+	panic("Print() not supported for interval tree")
+}
+
+func (t *itree) Tree2Array() []SegmentOverlap {
+	panic("Tree2Array() not supported for interval tree")
+}
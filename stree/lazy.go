@@ -0,0 +1,319 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"iter"
+	"sync"
+)
+
+// lazy builds the underlying tree on the first query instead of panicking
+// with ErrEmptyTree. Every method that reads the nodes builds the tree first
+// if needed, methods that only read the stack don't. Concurrent first
+// queries build the tree exactly once.
+type lazy struct {
+	*stree
+	// reset by every push, so that the next query builds again
+	once *sync.Once
+	// error of the last build
+	err error
+}
+
+// NewLazyTree returns a SegmentTree interface with underlying segment tree
+// implementation that builds itself on the first query or other method
+// that reads the nodes, only Root returns nil until then. Pushing intervals
+// afterwards is allowed and makes the next query rebuild the tree. Pushes
+// must not run concurrently with queries, the same as for any tree. Queries
+// of an empty stack panic with ErrNoIntervals, the error of BuildTree.
+func NewLazyTree() SegmentTree {
+	t := &lazy{stree: new(stree), once: new(sync.Once)}
+	t.stree.Clear()
+	return t
+}
+
+// build builds the tree once after the last push, returns the error of
+// this build
+func (t *lazy) build() error {
+	t.once.Do(func() { t.err = t.stree.BuildTree() })
+	return t.err
+}
+
+// ready builds the tree if needed, panics with the error of the build
+func (t *lazy) ready() {
+	if err := t.build(); err != nil {
+		panic(err)
+	}
+}
+
+// Push new interval to stack, the next query rebuilds the tree
+func (t *lazy) Push(from, to int) {
+	t.stree.Push(from, to)
+	t.once = new(sync.Once)
+}
+
+// Push array of intervals to stack, the next query rebuilds the tree
+func (t *lazy) PushArray(from, to []int) {
+	t.stree.PushArray(from, to)
+	t.once = new(sync.Once)
+}
+
+// Push new interval with external key to stack, the next query rebuilds the tree
+func (t *lazy) PushKey(from, to int, key string) {
+	t.stree.PushKey(from, to, key)
+	t.once = new(sync.Once)
+}
+
+// Push new interval with given Id to stack, the next query rebuilds the tree
+func (t *lazy) PushWithId(id, from, to int) {
+	t.stree.PushWithId(id, from, to)
+	t.once = new(sync.Once)
+}
+
+// Push intervals with their Ids and keys to stack, the next query rebuilds the tree
+func (t *lazy) PushIntervals(intervals []Interval) {
+	t.stree.PushIntervals(intervals)
+	t.once = new(sync.Once)
+}
+
+// Clear the interval stack
+func (t *lazy) Clear() {
+	t.stree.Clear()
+	t.once = new(sync.Once)
+}
+
+// Build segment tree out of interval stack, unless it is built already
+func (t *lazy) BuildTree() error {
+	return t.build()
+}
+
+// Query interval
+func (t *lazy) Query(from, to int) []Interval {
+	t.ready()
+	return t.stree.Query(from, to)
+}
+
+// Query interval with expected number of results
+func (t *lazy) QueryHint(from, to, expected int) []Interval {
+	t.ready()
+	return t.stree.QueryHint(from, to, expected)
+}
+
+// Query interval array
+func (t *lazy) QueryArray(from, to []int) []Interval {
+	t.ready()
+	return t.stree.QueryArray(from, to)
+}
+
+// Query interval lazily as sequence
+func (t *lazy) QueryView(from, to int) IntervalSeq {
+	t.ready()
+	return t.stree.QueryView(from, to)
+}
+
+// Rebuild tree from the intervals not removed
+func (t *lazy) Compact() error {
+	if err := t.build(); err != nil {
+		return err
+	}
+	return t.stree.Compact()
+}
+
+// Would a rebuild at least halve the leaves
+func (t *lazy) IsSkewed() bool {
+	t.ready()
+	return t.stree.IsSkewed()
+}
+
+// Copy of the built tree without intervals
+func (t *lazy) CloneEmpty() SegmentTree {
+	t.ready()
+	return t.stree.CloneEmpty()
+}
+
+// Print tree recursively to stdout
+func (t *lazy) Print() {
+	t.ready()
+	t.stree.Print()
+}
+
+// Transform tree to array
+func (t *lazy) Tree2Array() []SegmentOverlap {
+	t.ready()
+	return t.stree.Tree2Array()
+}
+
+// Transform tree to sequence, nodes are visited lazily
+func (t *lazy) Tree2Seq() iter.Seq[SegmentOverlap] {
+	t.ready()
+	return t.stree.Tree2Seq()
+}
+
+// Pass every node of tree to visitors in a single traversal
+func (t *lazy) Aggregate(visitors ...NodeVisitor) {
+	t.ready()
+	t.stree.Aggregate(visitors...)
+}
+
+// Query interval array, intervals overlapping all intervals
+func (t *lazy) QueryArrayAll(from, to []int) []Interval {
+	t.ready()
+	return t.stree.QueryArrayAll(from, to)
+}
+
+// Query interval array, with indices of the queries each interval overlaps
+func (t *lazy) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	t.ready()
+	return t.stree.QueryArrayAnnotated(from, to)
+}
+
+// Number of intervals overlapping interval, no result is collected
+func (t *lazy) Count(from, to int) int {
+	t.ready()
+	return t.stree.Count(from, to)
+}
+
+// Call fn for every overlapping interval until it returns false
+func (t *lazy) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.ready()
+	t.stree.QueryFunc(from, to, fn)
+}
+
+// Query interval, result sorted by From
+func (t *lazy) QueryOrdered(from, to int) []Interval {
+	t.ready()
+	return t.stree.QueryOrdered(from, to)
+}
+
+// Query interval and assign result to non-overlapping layers
+func (t *lazy) QueryLayered(from, to int) [][]Interval {
+	t.ready()
+	return t.stree.QueryLayered(from, to)
+}
+
+// Number of layers of QueryLayered
+func (t *lazy) LayerCount(from, to int) int {
+	t.ready()
+	return t.stree.LayerCount(from, to)
+}
+
+// Query interval, only intervals with To - From >= minLen
+func (t *lazy) QueryMinLength(from, to, minLen int) []Interval {
+	t.ready()
+	return t.stree.QueryMinLength(from, to, minLen)
+}
+
+// Query interval, n intervals with highest Id first
+func (t *lazy) QueryRecent(from, to, n int) []Interval {
+	t.ready()
+	return t.stree.QueryRecent(from, to, n)
+}
+
+// Query interval, result sorted by Id
+func (t *lazy) QueryInsertionOrder(from, to int) []Interval {
+	t.ready()
+	return t.stree.QueryInsertionOrder(from, to)
+}
+
+// Query interval, k greatest intervals by less first
+func (t *lazy) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	t.ready()
+	return t.stree.QueryTopK(from, to, k, less)
+}
+
+// Interval containing point preferred by prefer
+func (t *lazy) StabBest(point int, prefer Preference) (Interval, bool) {
+	t.ready()
+	return t.stree.StabBest(point, prefer)
+}
+
+// Number of intervals that contain point
+func (t *lazy) Depth(point int) int {
+	t.ready()
+	return t.stree.Depth(point)
+}
+
+// Intervals that contain point
+func (t *lazy) Stab(point int) []Interval {
+	t.ready()
+	return t.stree.Stab(point)
+}
+
+// Nearest interval to point, its distance and whether one was found
+func (t *lazy) Nearest(point int) (Interval, int, bool) {
+	t.ready()
+	return t.stree.Nearest(point)
+}
+
+// Intervals that contain the interval (from, to)
+func (t *lazy) Enclosing(from, to int) []Interval {
+	t.ready()
+	return t.stree.Enclosing(from, to)
+}
+
+// Release spare capacity of overlapping intervals in all nodes
+func (t *lazy) ShrinkToFit() {
+	t.ready()
+	t.stree.ShrinkToFit()
+}
+
+// Snapshot interval stack and intervals of nodes
+func (t *lazy) SnapshotOverlaps() OverlapState {
+	t.ready()
+	return t.stree.SnapshotOverlaps()
+}
+
+// Query interval, result and uncovered segments clipped to query
+func (t *lazy) QueryWithGaps(from, to int) ([]Interval, []Segment) {
+	t.ready()
+	return t.stree.QueryWithGaps(from, to)
+}
+
+// Query interval, result clipped and mapped to pixels of a row of width
+func (t *lazy) RenderView(from, to, width int) []RenderedInterval {
+	t.ready()
+	return t.stree.RenderView(from, to, width)
+}
+
+// Query interval, result clipped and relative to from
+func (t *lazy) QueryRelative(from, to int) []Segment {
+	t.ready()
+	return t.stree.QueryRelative(from, to)
+}
+
+// Is every coordinate of range covered by an interval
+func (t *lazy) FullyCovered(from, to int) bool {
+	t.ready()
+	return t.stree.FullyCovered(from, to)
+}
+
+// Maximal nodes whose segments partition the query interval
+func (t *lazy) CanonicalNodes(from, to int) []Node {
+	t.ready()
+	return t.stree.CanonicalNodes(from, to)
+}
+
+// Query interval and group result by the nodes the intervals were found at
+func (t *lazy) QueryDetailed(from, to int) []SegmentOverlap {
+	t.ready()
+	return t.stree.QueryDetailed(from, to)
+}
+
+// Intervals with From in range, ordered by From
+func (t *lazy) QueryStartsIn(from, to int) []Interval {
+	t.ready()
+	return t.stree.QueryStartsIn(from, to)
+}
+
+// Intervals with To in range, ordered by To
+func (t *lazy) QueryEndsIn(from, to int) []Interval {
+	t.ready()
+	return t.stree.QueryEndsIn(from, to)
+}
+
+// Number of intervals that start and that end in range
+func (t *lazy) FlowCounts(from, to int) (starts, ends int) {
+	t.ready()
+	return t.stree.FlowCounts(from, to)
+}
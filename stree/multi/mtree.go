@@ -7,22 +7,47 @@
 package multi
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	. "github.com/toberndo/go-stree/stree"
-	"math"
+	"iter"
+	"math/bits"
+	"os"
 	"runtime"
+	"slices"
+	"sort"
 	"sync"
 )
 
 const (
 	// number of goroutines = 2 ** P_LEVEL
 	P_LEVEL = 6 // 64 goroutines
+	// default depth of tree up to which queries start goroutines
+	SPAWN_DEPTH = 16
 )
 
 // number of goroutines for tree walker
 var NUM_WORKER int = runtime.NumCPU() * 2
 
+// Interface to access parallel tree, extends SegmentTree with methods
+// specific to the parallel implementation
+type MTree interface {
+	SegmentTree
+	// Run throwaway query to warm up goroutines of tree walker
+	Warmup()
+	// Query interval array in parallel, one result per query
+	QueryArrayGrouped(from, to []int) [][]Interval
+	// Set depth of tree up to which queries start goroutines
+	SetSpawnDepth(depth int)
+	// Push array of intervals to stack using all CPUs
+	PushArrayParallel(from, to []int)
+	// Query interval, stops and returns the error of ctx when it is cancelled
+	QueryContext(ctx context.Context, from, to int) ([]Interval, error)
+}
+
 type mtree struct {
-	// Number of intervals
+	// Id of next interval pushed without Id
 	count int
 	root  *mnode
 	// Interval stack
@@ -37,8 +62,30 @@ type mtree struct {
 	sem chan int
 	// max number of goroutines used
 	numG int
+	// level of tree at which the build starts goroutines, numG = 2 ** pLevel
+	pLevel int
+	// number of goroutines of tree walker, 0 for NUM_WORKER
+	workers int
 	// fallback to single processing if low number of intervals
 	single bool
+	// Index of intervals in stack by external key
+	keys map[string]int
+	// Custom overlap function, nil for default closed interval overlap
+	overlaps OverlapFunc
+	// Intervals sorted by endpoints, built with tree
+	index EndpointIndex
+	// depth of tree up to which queries start goroutines
+	spawnDepth int
+	// Number of pushed intervals with From == To
+	points int
+	// Ids differ from positions in stack, see stree
+	sparse bool
+	// Intervals were pushed since the tree was built, see stree
+	dirty bool
+	// Number of intervals removed since the tree was built, see stree
+	removed int
+	// Order of query results, nil for undefined order
+	less func(a, b Interval) bool
 }
 
 type mnode struct {
@@ -75,17 +122,145 @@ func (n *mnode) Overlap() []Interval {
 	return interval
 }
 
-// NewMTree returns a Tree interface with underlying parallel segment tree implementation
-func NewMTree() Tree {
+// NewMTree returns a MTree interface with underlying parallel segment tree implementation
+func NewMTree() MTree {
+	t := new(mtree)
+	t.spawnDepth = SPAWN_DEPTH
+	t.pLevel = P_LEVEL
+	t.Clear()
+	return t
+}
+
+// NewMTreeConfig returns a parallel segment tree that builds with up to
+// buildGoroutines goroutines, rounded up to a power of two, and runs
+// queries with queryWorkers goroutines instead of the package defaults
+// P_LEVEL and NUM_WORKER. A value of 0 keeps the default, a tree with 1
+// build goroutine is built sequentially. The values survive Clear.
+func NewMTreeConfig(buildGoroutines, queryWorkers int) MTree {
+	t := new(mtree)
+	t.spawnDepth = SPAWN_DEPTH
+	t.pLevel = P_LEVEL
+	if buildGoroutines > 0 {
+		t.pLevel = bits.Len(uint(buildGoroutines - 1))
+	}
+	t.workers = max(queryWorkers, 0)
+	t.Clear()
+	return t
+}
+
+// NewMTreeOrdered returns a parallel segment tree that sorts query results
+// with less, see NewTreeOrdered
+func NewMTreeOrdered(less func(a, b Interval) bool) MTree {
 	t := new(mtree)
+	t.spawnDepth = SPAWN_DEPTH
+	t.pLevel = P_LEVEL
+	t.less = less
 	t.Clear()
 	return t
 }
 
+// sorted sorts result with the comparator of the tree, if any
+func (t *mtree) sorted(result []Interval) []Interval {
+	if t.less != nil {
+		sort.SliceStable(result, func(i, j int) bool { return t.less(result[i], result[j]) })
+	}
+	return result
+}
+
 // Push new interval to stack
 func (t *mtree) Push(from, to int) {
-	t.base = append(t.base, Interval{t.count, Segment{from, to}})
+	if t.count != len(t.base) {
+		t.sparse = true
+	}
+	if t.root != nil {
+		t.dirty = true
+	}
+	t.base = append(t.base, Interval{Id: t.count, Segment: Segment{From: from, To: to}})
 	t.count++
+	if from == to {
+		t.points++
+	}
+}
+
+// Push new interval with external key to stack, see stree.PushKey
+func (t *mtree) PushKey(from, to int, key string) {
+	t.Push(from, to)
+	t.base[len(t.base)-1].Key = key
+	t.keys[key] = len(t.base) - 1
+}
+
+// Push new interval with given Id to stack, see stree.PushWithId
+func (t *mtree) PushWithId(id, from, to int) {
+	if id != len(t.base) {
+		t.sparse = true
+	}
+	if t.root != nil {
+		t.dirty = true
+	}
+	t.base = append(t.base, Interval{Id: id, Segment: Segment{From: from, To: to}})
+	if id >= t.count {
+		t.count = id + 1
+	}
+	if from == to {
+		t.points++
+	}
+}
+
+// PushIntervals pushes intervals with their Ids and keys, see
+// stree.PushIntervals
+func (t *mtree) PushIntervals(intervals []Interval) {
+	for _, intrvl := range intervals {
+		t.PushWithId(intrvl.Id, intrvl.From, intrvl.To)
+		if intrvl.Key != "" {
+			t.base[len(t.base)-1].Key = intrvl.Key
+			t.keys[intrvl.Key] = len(t.base) - 1
+		}
+	}
+}
+
+// PointIntervalCount returns the number of pushed intervals with From == To
+func (t *mtree) PointIntervalCount() int {
+	return t.points
+}
+
+// Get interval by external key
+func (t *mtree) GetByKey(key string) (Interval, bool) {
+	if i, ok := t.keys[key]; ok {
+		return t.base[i], true
+	}
+	return Interval{}, false
+}
+
+// Intervals returns a copy of the interval stack, see stree.Intervals
+func (t *mtree) Intervals() []Interval {
+	return slices.Clone(t.base)
+}
+
+// Len returns the number of intervals in the stack, see stree.Len
+func (t *mtree) Len() int {
+	return len(t.base)
+}
+
+// Built returns true if the tree is built, see stree.Built
+func (t *mtree) Built() bool {
+	return t.root != nil
+}
+
+// Dirty returns true if intervals were pushed since the tree was built,
+// see stree.Dirty
+func (t *mtree) Dirty() bool {
+	return t.dirty
+}
+
+// checkBuilt panics with ErrEmptyTree if the tree is not built and with
+// ErrDirtyTree if intervals were pushed since it was built
+func (t *mtree) checkBuilt() {
+	if t.root == nil {
+		panic(ErrEmptyTree)
+	}
+	if t.dirty {
+		panic(ErrDirtyTree)
+	}
 }
 
 // Push array of intervals to stack
@@ -95,38 +270,123 @@ func (t *mtree) PushArray(from, to []int) {
 	}
 }
 
+// PushArrayParallel pushes the array of intervals like PushArray, but splits
+// the arrays into one part per CPU. Each goroutine fills its own region of the
+// stack, the Ids are assigned from the position in the arrays, so they are the
+// same as with PushArray.
+func (t *mtree) PushArrayParallel(from, to []int) {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	n := len(from)
+	start := len(t.base)
+	if t.count != start {
+		t.sparse = true
+	}
+	if t.root != nil && n > 0 {
+		t.dirty = true
+	}
+	if cap(t.base)-start < n {
+		base := make([]Interval, start, start+n)
+		copy(base, t.base)
+		t.base = base
+	}
+	t.base = t.base[:start+n]
+	workers := runtime.NumCPU()
+	chunk := (n + workers - 1) / workers
+	// number of point intervals of each part
+	points := make([]int, workers)
+	var wait sync.WaitGroup
+	for lo := 0; lo < n; lo += chunk {
+		hi := min(lo+chunk, n)
+		wait.Add(1)
+		go func() {
+			for i := lo; i < hi; i++ {
+				t.base[start+i] = Interval{Id: t.count + i, Segment: Segment{From: from[i], To: to[i]}}
+				if from[i] == to[i] {
+					points[lo/chunk]++
+				}
+			}
+			wait.Done()
+		}()
+	}
+	wait.Wait()
+	t.count += n
+	for _, p := range points {
+		t.points += p
+	}
+}
+
 // Clear the interval stack
 func (t *mtree) Clear() {
 	t.count = 0
 	t.root = nil
-	t.base = make([]Interval, 0, 100)
+	// reuse capacity of previous stack, a new tree allocates on first push
+	t.base = t.base[:0]
 	t.min = 0
 	t.max = 0
-	// max number of goroutines = 2 ** P_LEVEL
-	t.numG = int(math.Pow(2, P_LEVEL))
+	// max number of goroutines = 2 ** pLevel, configured by constructor
+	t.numG = 1 << t.pLevel
 	// buffered channels
 	t.done = make(chan bool, t.numG)
 	t.sem = make(chan int, t.numG)
 	// default: parallel processing
 	t.single = false
+	t.keys = make(map[string]int)
+	t.index = EndpointIndex{}
+	t.points = 0
+	t.sparse = false
+	t.dirty = false
+	t.removed = 0
 }
 
-// Build segment tree out of interval stack
-func (t *mtree) BuildTree() {
+// Build segment tree out of interval stack, returns ErrNoIntervals if the
+// stack is empty
+func (t *mtree) BuildTree() error {
 	if len(t.base) == 0 {
-		panic("No intervals in stack to build tree. Push intervals first")
+		return ErrNoIntervals
+	}
+	t.dirty = false
+	t.removed = 0
+	t.index = NewEndpointIndex(t.base)
+	if len(t.base) == 1 {
+		// a single interval covers the whole tree, the root is the only node
+		t.root = &mnode{segment: t.base[0].Segment, overlap: []*Interval{&t.base[0]}}
+		t.min, t.max = t.base[0].From, t.base[0].To
+		return nil
 	}
-	var endpoint []int
 	// attempts to parallelize the creation of endpoint array
 	// only showed decrease in performance
-	endpoint, t.min, t.max = Endpoints(t.base)
-	// number of endpoints must be at least 10 times higher than number of
-	// goroutines to justify effort and avoid locking situation
-	if len(endpoint) < t.numG*10 {
-		t.single = true
+	endpoint, min, max := Endpoints(t.base)
+	t.build(endpoint, min, max)
+	return nil
+}
+
+// BuildTreeWithEndpoints builds the segment tree with precomputed
+// endpoints, see stree.BuildTreeWithEndpoints
+func (t *mtree) BuildTreeWithEndpoints(endpoint []int, min, max int) {
+	if len(t.base) == 0 {
+		panic(ErrNoIntervals)
+	}
+	if !ValidEndpoints(t.base, endpoint, min, max) {
+		panic(ErrInvalidEndpoints)
 	}
-	// create tree nodes from interval endpoints, uses goroutines if t.single == false
-	t.root = t.insertNodes(endpoint, 0)
+	t.dirty = false
+	t.removed = 0
+	t.index = NewEndpointIndex(t.base)
+	t.build(endpoint, min, max)
+}
+
+// build creates tree nodes from elementary intervals between endpoints
+// and inserts the intervals of the stack
+func (t *mtree) build(endpoint []int, min, max int) {
+	t.min, t.max = min, max
+	// number of endpoints must be at least 10 times higher than number of
+	// goroutines to justify effort and avoid locking situation, a tree
+	// configured for one goroutine never reaches pLevel
+	t.single = t.pLevel == 0 || len(endpoint) < t.numG*10
+	// create tree nodes from elementary intervals, uses goroutines if t.single == false
+	t.root = t.insertNodes(ElementaryIntervals(endpoint), 0)
 	if !t.single {
 		// wait for goroutines to finish
 		t.wait()
@@ -140,55 +400,453 @@ func (t *mtree) BuildTree() {
 	}
 }
 
+// Insert pushes a new interval to the stack and inserts it into the built
+// tree, see stree.Insert
+func (t *mtree) Insert(from, to int) {
+	dirty := t.dirty
+	t.Push(from, to)
+	if t.root == nil {
+		return
+	}
+	if from >= t.min && to <= t.max && Aligned(t.root, from, to) {
+		t.insertInterval(t.root, &t.base[len(t.base)-1])
+		if t.index.Len() == len(t.base)-1 {
+			t.index.Insert(t.base, len(t.base)-1)
+		}
+		t.dirty = dirty
+	} else {
+		t.BuildTree()
+	}
+}
+
+// Remove deletes the interval with given Id from the stack and from the
+// nodes of the built tree, see stree.Remove. The nodes and the stack are
+// changed without locks, Remove must not run concurrently with queries.
+func (t *mtree) Remove(id int) bool {
+	i := t.position(id)
+	if i < 0 {
+		return false
+	}
+	removed := t.base[i]
+	if t.root != nil {
+		removeInterval(t.root, removed.Segment, id, nil)
+	}
+	if removed.Key != "" && t.keys[removed.Key] == i {
+		delete(t.keys, removed.Key)
+	}
+	last := len(t.base) - 1
+	if i != last {
+		t.base[i] = t.base[last]
+		if t.root != nil {
+			// nodes of the moved interval point to its previous position
+			removeInterval(t.root, t.base[i].Segment, t.base[i].Id, &t.base[i])
+		}
+		if t.base[i].Key != "" {
+			t.keys[t.base[i].Key] = i
+		}
+		t.sparse = true
+	}
+	t.base = t.base[:last]
+	if removed.From == removed.To {
+		t.points--
+	}
+	if t.index.Len() == last+1 {
+		t.index.Remove(t.base, i)
+	} else {
+		t.index = EndpointIndex{}
+	}
+	if t.root != nil {
+		t.removed++
+	}
+	return true
+}
+
+// RemovedRatio returns the fraction of intervals removed since the tree was
+// built, see stree.RemovedRatio
+func (t *mtree) RemovedRatio() float64 {
+	if t.removed == 0 {
+		return 0
+	}
+	return float64(t.removed) / float64(t.removed+len(t.base))
+}
+
+// IsSkewed returns true if a rebuild would at least halve the leaves of
+// the tree, see Skewed
+func (t *mtree) IsSkewed() bool {
+	return t.root != nil && Skewed(t.root, t.base)
+}
+
+// Compact rebuilds the tree from the intervals of the stack, see
+// stree.Compact
+func (t *mtree) Compact() error {
+	if t.root == nil {
+		return ErrEmptyTree
+	}
+	return t.BuildTree()
+}
+
+// position returns the position of the interval with given Id in the
+// stack, -1 if there is no such interval
+func (t *mtree) position(id int) int {
+	if !t.sparse {
+		if id >= 0 && id < len(t.base) {
+			return id
+		}
+		return -1
+	}
+	for i := range t.base {
+		if t.base[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeInterval removes the interval with given Id from the nodes of seg,
+// or replaces it by replace if it is not nil
+func removeInterval(node *mnode, seg Segment, id int, replace *Interval) {
+	switch node.segment.CompareTo(&seg) {
+	case SUBSET:
+		for j, pintrvl := range node.overlap {
+			if pintrvl.Id == id {
+				if replace != nil {
+					node.overlap[j] = replace
+				} else {
+					node.overlap = slices.Delete(node.overlap, j, j+1)
+				}
+				break
+			}
+		}
+	case INTERSECT_OR_SUPERSET:
+		if node.left != nil {
+			removeInterval(node.left, seg, id, replace)
+		}
+		if node.right != nil {
+			removeInterval(node.right, seg, id, replace)
+		}
+	}
+}
+
+// CloneEmpty returns a tree with a copy of the nodes of t but no intervals,
+// see stree.CloneEmpty
+func (t *mtree) CloneEmpty() SegmentTree {
+	if t.root == nil {
+		panic(ErrEmptyTree)
+	}
+	c := &mtree{spawnDepth: t.spawnDepth, pLevel: t.pLevel, workers: t.workers, overlaps: t.overlaps, less: t.less}
+	c.Clear()
+	c.min, c.max, c.single = t.min, t.max, t.single
+	c.root = cloneEmpty(t.root)
+	return c
+}
+
+// cloneEmpty copies the nodes of tree without overlapping intervals
+func cloneEmpty(n *mnode) *mnode {
+	if n == nil {
+		return nil
+	}
+	return &mnode{segment: n.segment, left: cloneEmpty(n.left), right: cloneEmpty(n.right)}
+}
+
 func (t *mtree) wait() {
 	for i := 0; i < t.numG; i++ {
 		<-t.done
 	}
 }
 
+// SetSpawnDepth limits the depth of nodes for whose children the tree walker
+// starts goroutines, below this depth the traversal continues inline. Wide
+// queries otherwise spend the query goroutines wherever a slot is free,
+// also deep in the bushy bottom of the tree where a subtree is too small to
+// pay for the scheduling. Depth 0 runs queries in a single goroutine.
+func (t *mtree) SetSpawnDepth(depth int) {
+	t.spawnDepth = depth
+}
+
+// Warmup runs a throwaway query over the full range of the tree, which starts
+// the maximum number of query goroutines of the tree walker. Goroutines are
+// not pooled, but this lets the runtime create the threads, processors and
+// goroutine stacks that later queries reuse and pulls the tree nodes into the
+// CPU caches, so the first real query does not pay the cold start latency.
+// Calling Warmup is optional and costs as much as a full range query.
+func (t *mtree) Warmup() {
+	t.Query(t.min, t.max)
+}
+
 func (t *mtree) Print() {
-	Print(t.root)
+	Fprint(os.Stdout, t.root)
+}
+
+// Root returns the root node, nil if the tree is not built
+func (t *mtree) Root() Node {
+	if t.root == nil {
+		return nil
+	}
+	return t.root
 }
 
 func (t *mtree) Tree2Array() []SegmentOverlap {
 	return Tree2Array(t.root)
 }
 
-// insertNodes builds tree structure from given endpoints
-// starts with single processing, at P_LEVEL level of tree the children
+// SnapshotOverlaps captures the interval stack and the intervals stored at each node
+func (t *mtree) SnapshotOverlaps() OverlapState {
+	t.checkBuilt()
+	return Snapshot(t.root, t.base)
+}
+
+// RestoreOverlaps replaces the interval stack and the intervals stored at
+// the nodes with the snapshot, see stree.RestoreOverlaps
+func (t *mtree) RestoreOverlaps(state OverlapState) {
+	if t.root == nil {
+		panic(ErrEmptyTree)
+	}
+	if !state.Matches(t.root) {
+		panic(ErrStateMismatch)
+	}
+	t.restoreBase(state.Base)
+	positions := state.Positions()
+	restoreOverlaps(t.root, t.base, &positions)
+}
+
+// restoreBase replaces the interval stack and the state derived from it
+func (t *mtree) restoreBase(base []Interval) {
+	t.base = slices.Clone(base)
+	t.keys = make(map[string]int)
+	t.count, t.points, t.removed, t.sparse, t.dirty = 0, 0, 0, false, false
+	for i, intrvl := range t.base {
+		if intrvl.Key != "" {
+			t.keys[intrvl.Key] = i
+		}
+		if intrvl.Id != i {
+			t.sparse = true
+		}
+		t.count = max(t.count, intrvl.Id+1)
+		if intrvl.From == intrvl.To {
+			t.points++
+		}
+	}
+	t.index = NewEndpointIndex(t.base)
+}
+
+// gobStack is the gob encoding of a tree, see stree.GobEncode
+type gobStack struct {
+	Base  []Interval
+	Count int
+}
+
+// GobEncode encodes the interval stack, see stree.GobEncode
+func (t *mtree) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(gobStack{Base: t.base, Count: t.count})
+	return buf.Bytes(), err
+}
+
+// GobDecode replaces the interval stack with an encoded one and clears the
+// tree, see stree.GobDecode
+func (t *mtree) GobDecode(data []byte) error {
+	var stack gobStack
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&stack); err != nil {
+		return err
+	}
+	t.root = nil
+	t.min, t.max = 0, 0
+	t.restoreBase(stack.Base)
+	t.count = max(t.count, stack.Count)
+	return nil
+}
+
+// restoreOverlaps assigns the intervals of base to the nodes in the preorder
+// of Tree2Array, consuming one entry of positions per node
+func restoreOverlaps(node *mnode, base []Interval, positions *[][]int) {
+	node.overlap = nil
+	for _, pos := range (*positions)[0] {
+		node.overlap = append(node.overlap, &base[pos])
+	}
+	*positions = (*positions)[1:]
+	if node.right != nil {
+		restoreOverlaps(node.right, base, positions)
+	}
+	if node.left != nil {
+		restoreOverlaps(node.left, base, positions)
+	}
+}
+
+// Tree2Seq transforms tree to sequence of SegmentOverlap
+func (t *mtree) Tree2Seq() iter.Seq[SegmentOverlap] {
+	return Tree2Seq(t.root)
+}
+
+func (t *mtree) Aggregate(visitors ...NodeVisitor) {
+	Aggregate(t.root, visitors...)
+}
+
+// ShrinkToFit reallocates the overlapping intervals of every node to their
+// exact length, see stree.ShrinkToFit. Call it once after BuildTree.
+func (t *mtree) ShrinkToFit() {
+	if t.root != nil {
+		shrink(t.root)
+	}
+}
+
+// OverlapDegrees maps the Id of each interval of the stack to the number
+// of other intervals it overlaps, see OverlapDegrees
+func (t *mtree) OverlapDegrees() map[int]int {
+	return OverlapDegrees(t.base)
+}
+
+// MaximalCliques returns the Ids of the maximal groups of mutually
+// overlapping intervals of the stack, see MaximalCliques
+func (t *mtree) MaximalCliques() [][]int {
+	return MaximalCliques(t.base)
+}
+
+// AllGaps returns the uncovered segments between min and max of all
+// intervals in the stack, the tree doesn't have to be built
+func (t *mtree) AllGaps() []Segment {
+	return AllGaps(t.base)
+}
+
+// QueryInsertionOrder returns the overlapping intervals sorted by Id, see
+// stree.QueryInsertionOrder
+func (t *mtree) QueryInsertionOrder(from, to int) []Interval {
+	result := t.Query(from, to)
+	sort.Sort(ById(result))
+	return result
+}
+
+// QueryWithGaps returns the overlapping intervals clipped to the query and
+// sorted by From, and the segments of the query they don't cover, see ClipWithGaps
+func (t *mtree) QueryWithGaps(from, to int) ([]Interval, []Segment) {
+	return ClipWithGaps(t.Query(from, to), from, to)
+}
+
+// RenderView returns the overlapping intervals clipped to the viewport
+// (from, to), sorted by From and mapped to pixels of a row of width, see Render
+func (t *mtree) RenderView(from, to, width int) []RenderedInterval {
+	if width <= 0 || from > to {
+		panic(ErrInvalidViewport)
+	}
+	return Render(t.Query(from, to), from, to, width)
+}
+
+// QueryRelative returns the overlapping intervals clipped to the query and
+// shifted so that from becomes 0, see Relative
+func (t *mtree) QueryRelative(from, to int) []Segment {
+	return Relative(t.Query(from, to), from, to)
+}
+
+// MergedSegments returns the union of all intervals in the stack
+func (t *mtree) MergedSegments() []Segment {
+	return MergedSegments(t.base)
+}
+
+// Canonical returns the distinct segments of the interval stack sorted by
+// From, then To, see Canonical
+func (t *mtree) Canonical() []Segment {
+	return Canonical(t.base)
+}
+
+// CanonicalCounted returns the distinct segments of the interval stack with
+// the number of intervals of each segment, see CanonicalCounted
+func (t *mtree) CanonicalCounted() []SegmentCount {
+	return CanonicalCounted(t.base)
+}
+
+// HasOverlaps returns true if any two intervals in the stack overlap
+func (t *mtree) HasOverlaps() bool {
+	if t.index.Len() != len(t.base) {
+		return HasOverlaps(t.base)
+	}
+	return t.index.HasOverlaps(t.base)
+}
+
+// IsPartition returns true if the intervals in the stack tile (from, to)
+// without gaps and overlaps, see stree.IsPartition
+func (t *mtree) IsPartition(from, to int) bool {
+	if t.index.Len() != len(t.base) {
+		return IsPartition(t.base, from, to)
+	}
+	return t.index.IsPartition(t.base, from, to)
+}
+
+// FullyCovered returns true if every coordinate of (from, to) is covered
+// by an interval, see stree.FullyCovered
+func (t *mtree) FullyCovered(from, to int) bool {
+	t.checkBuilt()
+	return t.index.Covers(t.base, from, to)
+}
+
+// SetOverlapFunc replaces the closed interval overlap used by queries,
+// see stree.SetOverlapFunc. f is called concurrently by the tree walker.
+func (t *mtree) SetOverlapFunc(f OverlapFunc) {
+	t.overlaps = f
+}
+
+// overlapFunc returns the custom overlap function or the default
+func (t *mtree) overlapFunc() OverlapFunc {
+	if t.overlaps == nil {
+		return Overlaps
+	}
+	return t.overlaps
+}
+
+// CanonicalNodes returns the maximal nodes whose segments are contained in
+// the query interval, see stree.CanonicalNodes
+func (t *mtree) CanonicalNodes(from, to int) []Node {
+	t.checkBuilt()
+	return CanonicalNodes(t.root, from, to)
+}
+
+// Join returns the pairs of Ids (this tree, other tree) of overlapping
+// intervals, see stree.Join
+func (t *mtree) Join(other Tree) [][2]int {
+	return JoinIntervals(t.base, other)
+}
+
+// shrink reallocates overlapping intervals of node and its children
+func shrink(node *mnode) {
+	if node.overlap != nil && len(node.overlap) != cap(node.overlap) {
+		overlap := make([]*Interval, len(node.overlap))
+		copy(overlap, node.overlap)
+		node.overlap = overlap
+	}
+	if node.left != nil {
+		shrink(node.left)
+	}
+	if node.right != nil {
+		shrink(node.right)
+	}
+}
+
+// insertNodes builds tree structure from given elementary intervals
+// starts with single processing, at pLevel level of tree the children
 // are created in seperate goroutines
-func (t *mtree) insertNodes(endpoint []int, level int) *mnode {
+func (t *mtree) insertNodes(leaves []Segment, level int) *mnode {
 	var n *mnode
-	//fmt.Printf("Level: %d\n", level)
-	if len(endpoint) == 1 {
-		n = &mnode{segment: Segment{endpoint[0], endpoint[0]}}
+	if len(leaves) == 1 {
+		n = &mnode{segment: leaves[0]}
 		n.left = nil
 		n.right = nil
-	} else if len(endpoint) == 2 {
-		n = &mnode{segment: Segment{endpoint[0], endpoint[1]}}
-		if endpoint[1] != t.max {
-			n.left = &mnode{segment: Segment{endpoint[0], endpoint[0]}}
-			n.right = &mnode{segment: Segment{endpoint[1], endpoint[1]}}
-		}
 	} else {
-		n = &mnode{segment: Segment{endpoint[0], endpoint[len(endpoint)-1]}}
-		center := len(endpoint) / 2
+		n = &mnode{segment: Segment{From: leaves[0].From, To: leaves[len(leaves)-1].To}}
+		center := len(leaves) / 2
 		level++
-		if level == P_LEVEL && !t.single {
-			t.insertNodesAsync(&n.left, endpoint[:center+1], level)
-			t.insertNodesAsync(&n.right, endpoint[center+1:], level)
+		if level == t.pLevel && !t.single {
+			t.insertNodesAsync(&n.left, leaves[:center], level)
+			t.insertNodesAsync(&n.right, leaves[center:], level)
 		} else {
-			n.left = t.insertNodes(endpoint[:center+1], level)
-			n.right = t.insertNodes(endpoint[center+1:], level)
+			n.left = t.insertNodes(leaves[:center], level)
+			n.right = t.insertNodes(leaves[center:], level)
 		}
 	}
 	return n
 }
 
 // insertNodesAsync starts new goroutine for creation of tree branch
-func (t *mtree) insertNodesAsync(ppNode **mnode, endpoint []int, level int) {
+func (t *mtree) insertNodesAsync(ppNode **mnode, leaves []Segment, level int) {
 	go func() {
-		*ppNode = t.insertNodes(endpoint, level)
+		*ppNode = t.insertNodes(leaves, level)
 		t.done <- true
 	}()
 }
@@ -208,19 +866,26 @@ func (t *mtree) insertIntervalM() {
 	for i := 0; i < t.numG; i++ {
 		t.sem <- 1
 	}
+	// release the buffer for the next build
+	for i := 0; i < t.numG; i++ {
+		<-t.sem
+	}
 }
 
-// Inserts interval into given tree structure, write access locked
+// Inserts interval into given tree structure, write access locked unless
+// the tree is built by a single goroutine
 func (t *mtree) insertInterval(node *mnode, intrvl *Interval) {
 	switch node.segment.CompareTo(&intrvl.Segment) {
 	case SUBSET:
-		node.lock.Lock()
+		if !t.single {
+			node.lock.Lock()
+			defer node.lock.Unlock()
+		}
 		// interval of node is a subset of the specified interval or equal
 		if node.overlap == nil {
 			node.overlap = make([]*Interval, 0, 10)
 		}
 		node.overlap = append(node.overlap, intrvl)
-		node.lock.Unlock()
 	case INTERSECT_OR_SUPERSET:
 		// interval of node is a superset, have to look in both children
 		if node.left != nil {
@@ -244,6 +909,12 @@ type twalker struct {
 	queue chan byte
 	// result map of intervals
 	result chan *map[int]Interval
+	// result maps of intervals per query, see QueryArrayGrouped
+	groups chan []map[int]Interval
+	// no goroutines are started for children of nodes at or below this depth
+	maxDepth int
+	// closed when the query is cancelled, nil if it can't be cancelled
+	done <-chan struct{}
 }
 
 // init with max number of goroutines
@@ -252,6 +923,70 @@ func (t *twalker) init(num int) {
 	t.wait = new(sync.WaitGroup)
 	t.queue = make(chan byte, num)
 	t.result = make(chan *map[int]Interval, num)
+	t.groups = nil
+}
+
+// pool of tree walkers, reused by queries to avoid allocating channels
+var walkers = sync.Pool{New: func() any { return new(twalker) }}
+
+// queryWorkers returns the number of goroutines of the tree walker of queries
+func (t *mtree) queryWorkers() int {
+	if t.workers > 0 {
+		return t.workers
+	}
+	return NUM_WORKER
+}
+
+// getWalker returns a tree walker for num goroutines from the pool
+// that spawns goroutines for nodes above maxDepth
+func getWalker(num, maxDepth int) *twalker {
+	tw := walkers.Get().(*twalker)
+	if tw.num != num {
+		tw.init(num)
+	}
+	tw.maxDepth = maxDepth
+	tw.done = nil
+	return tw
+}
+
+// cancelled returns true if the query of the tree walker is cancelled
+func (t *twalker) cancelled() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// spawn returns the queue of goroutines if a goroutine may be started for the
+// children of a node at depth, otherwise nil which blocks in a select
+func (t *twalker) spawn(depth int) chan byte {
+	if depth >= t.maxDepth {
+		return nil
+	}
+	return t.queue
+}
+
+// putWalker resets tree walker and returns it to the pool, must be called
+// after results are collected, i.e. when all goroutines are finished
+func putWalker(tw *twalker) {
+	tw.reset()
+	walkers.Put(tw)
+}
+
+// reset drains the channels, so no state is passed to the next query.
+// The wait group is reusable as all goroutines are finished.
+func (t *twalker) reset() {
+	for {
+		select {
+		case <-t.queue:
+		case <-t.result:
+		case <-t.groups:
+		default:
+			return
+		}
+	}
 }
 
 // collect results from goroutines
@@ -272,52 +1007,205 @@ func (t *twalker) collect(result *map[int]Interval) {
 	}
 }
 
+// collect grouped results from goroutines
+func (t *twalker) collectGroups(result []map[int]Interval) {
+	t.wait.Wait()
+	for i := 0; i < t.num; i++ {
+		select {
+		case groups := <-t.groups:
+			for index, rmap := range groups {
+				for key, value := range rmap {
+					result[index][key] = value
+				}
+			}
+		default:
+			break
+		}
+	}
+}
+
 // Query interval with parallel tree walker
 func (t *mtree) Query(from, to int) []Interval {
-	if t.root == nil {
-		panic("Can't run query on empty tree. Call BuildTree() first")
+	return t.QueryHint(from, to, 0)
+}
+
+// Query interval with parallel tree walker, the result map is pre-sized
+// to the expected number of results. The goroutines fill the map in any
+// order, so the result is sorted by Id, see byId.
+func (t *mtree) QueryHint(from, to, expected int) []Interval {
+	return t.sorted(t.queryHint(from, to, expected))
+}
+
+func (t *mtree) queryHint(from, to, expected int) []Interval {
+	// the background context is never cancelled
+	result, _ := t.queryContext(context.Background(), from, to, expected)
+	return result
+}
+
+// QueryContext queries interval like Query, when ctx is cancelled the tree
+// walker stops descending the tree, its goroutines finish and the error of
+// ctx is returned without result
+func (t *mtree) QueryContext(ctx context.Context, from, to int) ([]Interval, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	result := make(map[int]Interval)
-	tw := new(twalker)
-	tw.init(NUM_WORKER)
-	querySingle(t.root, from, to, &result, tw, false)
+	result, err := t.queryContext(ctx, from, to, 0)
+	if err != nil {
+		return nil, err
+	}
+	return t.sorted(result), nil
+}
+
+func (t *mtree) queryContext(ctx context.Context, from, to, expected int) ([]Interval, error) {
+	t.checkBuilt()
+	if t.all(from, to) {
+		// no need for tree walker
+		return slices.Clone(t.base), nil
+	}
+	if t.outside(from, to) {
+		// no need for tree walker
+		return []Interval{}, nil
+	}
+	if t.root.left == nil {
+		// tree of a single node, no need for tree walker
+		if !t.overlapFunc()(t.root.segment, from, to) {
+			return []Interval{}, nil
+		}
+		return t.root.Overlap(), nil
+	}
+	result := make(map[int]Interval, expected)
+	tw := getWalker(t.queryWorkers(), t.spawnDepth)
+	tw.done = ctx.Done()
+	querySingle(t.root, 0, from, to, t.overlapFunc(), &result, tw, false)
 	tw.collect(&result)
+	putWalker(tw)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return byId(result), nil
+}
+
+// byId transforms the result map to a slice sorted by Id, the iteration
+// order of a map is random and would change between runs
+func byId(result map[int]Interval) []Interval {
 	sl := make([]Interval, 0, len(result))
 	for _, intrvl := range result {
 		sl = append(sl, intrvl)
 	}
+	sort.Sort(ById(sl))
 	return sl
 }
 
+// Count returns the number of intervals that overlap (from, to) without
+// collecting them, see stree.Count. Counting is cheap compared to starting
+// goroutines, so the tree is traversed by a single goroutine.
+func (t *mtree) Count(from, to int) int {
+	t.checkBuilt()
+	if t.all(from, to) {
+		return len(t.base)
+	}
+	if t.outside(from, to) {
+		return 0
+	}
+	if t.overlaps == nil && !t.sparse {
+		return countUnique(t.root, from, to)
+	}
+	seen := make(map[int]struct{})
+	countSingle(t.root, from, to, t.overlapFunc(), seen)
+	return len(seen)
+}
+
+// countUnique counts the overlapping intervals without deduplication, an
+// interval is counted at the node containing p = max(From, from) only,
+// see stree.Count
+func countUnique(node *mnode, from, to int) int {
+	if !Overlaps(node.segment, from, to) {
+		return 0
+	}
+	count := 0
+	for _, pintrvl := range node.overlap {
+		if p := max(pintrvl.From, from); p >= node.segment.From && p <= node.segment.To {
+			count++
+		}
+	}
+	if node.right != nil {
+		count += countUnique(node.right, from, to)
+	}
+	if node.left != nil {
+		count += countUnique(node.left, from, to)
+	}
+	return count
+}
+
+// countSingle traverses tree by a single goroutine and marks the Ids of
+// the intervals found in seen
+func countSingle(node *mnode, from, to int, overlaps OverlapFunc, seen map[int]struct{}) {
+	if overlaps(node.segment, from, to) {
+		for _, pintrvl := range node.overlap {
+			seen[pintrvl.Id] = struct{}{}
+		}
+		if node.right != nil {
+			countSingle(node.right, from, to, overlaps, seen)
+		}
+		if node.left != nil {
+			countSingle(node.left, from, to, overlaps, seen)
+		}
+	}
+}
+
+// outside returns true if the query is entirely below min or above max of
+// the tree, see stree.outside
+func (t *mtree) outside(from, to int) bool {
+	return t.overlaps == nil && (to < t.min || from > t.max)
+}
+
+// all returns true if the query covers the tree, see stree.all
+func (t *mtree) all(from, to int) bool {
+	return t.overlaps == nil && !t.sparse && from <= t.min && to >= t.max
+}
+
+// outsideAll returns true if all queries are outside of the tree
+func (t *mtree) outsideAll(from, to []int) bool {
+	for i, fromvalue := range from {
+		if !t.outside(fromvalue, to[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // querySingle traverses tree in parallel to search for overlaps
-func querySingle(node *mnode, from, to int, result *map[int]Interval, tw *twalker, back bool) {
-	if !node.segment.Disjoint(from, to) {
+func querySingle(node *mnode, depth int, from, to int, overlaps OverlapFunc, result *map[int]Interval, tw *twalker, back bool) {
+	// a cancelled query skips the subtree, spawned goroutines still report
+	// back, so collect doesn't wait for results that are never sent
+	if !tw.cancelled() && overlaps(node.segment, from, to) {
 		for _, pintrvl := range node.overlap {
 			(*result)[pintrvl.Id] = *pintrvl
 		}
 		if node.right != nil {
-			// buffered channel tw.queue is a safe counter to limit number of started goroutines
+			// buffered channel tw.queue is a safe counter to limit number of started goroutines,
+			// below the spawn depth tw.spawn returns nil and the query continues inline
 			select {
-			case tw.queue <- 1:
+			case tw.spawn(depth) <- 1:
 				// create new map for result
 				newMap := make(map[int]Interval)
 				// increment counter of wait group
 				tw.wait.Add(1)
 				// start new query in goroutine
-				go querySingle(node.right, from, to, &newMap, tw, true)
+				go querySingle(node.right, depth+1, from, to, overlaps, &newMap, tw, true)
 			default:
 				// pass-through result map of parent
-				querySingle(node.right, from, to, result, tw, false)
+				querySingle(node.right, depth+1, from, to, overlaps, result, tw, false)
 			}
 		}
 		if node.left != nil {
 			select {
-			case tw.queue <- 1:
+			case tw.spawn(depth) <- 1:
 				newMap := make(map[int]Interval)
 				tw.wait.Add(1)
-				go querySingle(node.left, from, to, &newMap, tw, true)
+				go querySingle(node.left, depth+1, from, to, overlaps, &newMap, tw, true)
 			default:
-				querySingle(node.left, from, to, result, tw, false)
+				querySingle(node.left, depth+1, from, to, overlaps, result, tw, false)
 			}
 		}
 	}
@@ -330,29 +1218,248 @@ func querySingle(node *mnode, from, to int, result *map[int]Interval, tw *twalke
 	}
 }
 
-// Query interval array in parallel
+// QueryArrayAnnotated returns the intervals that overlap any interval of
+// the array with the indices of the queries they overlap, built from the
+// result of QueryArrayGrouped
+func (t *mtree) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	return Annotate(t.QueryArrayGrouped(from, to))
+}
+
+// QueryArrayAll returns the intervals that overlap every interval of the
+// array, see stree.QueryArrayAll
+func (t *mtree) QueryArrayAll(from, to []int) []Interval {
+	return QueryAll(t.Query, from, to)
+}
+
+// Query interval array in parallel, intervals that overlap any of the
+// intervals of the array are returned once sorted by Id
 func (t *mtree) QueryArray(from, to []int) []Interval {
-	if t.root == nil {
-		panic("Can't run query on empty tree. Call BuildTree() first")
+	return t.sorted(t.queryArray(from, to))
+}
+
+func (t *mtree) queryArray(from, to []int) []Interval {
+	// check before any goroutine of the tree walker is started
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	t.checkBuilt()
+	for i, fromvalue := range from {
+		if t.all(fromvalue, to[i]) {
+			return slices.Clone(t.base)
+		}
+	}
+	if t.outsideAll(from, to) {
+		return []Interval{}
 	}
 	result := make(map[int]Interval)
-	tw := new(twalker)
-	tw.init(NUM_WORKER)
-	queryMulti(t.root, from, to, &result, tw, false)
+	tw := getWalker(t.queryWorkers(), t.spawnDepth)
+	queryMulti(t.root, 0, from, to, t.overlapFunc(), &result, tw, false)
 	tw.collect(&result)
-	sl := make([]Interval, 0, len(result))
+	putWalker(tw)
+	return byId(result)
+}
+
+// QueryOrdered returns the overlapping intervals of the parallel query
+// sorted by From, ties are ordered as in ByFrom
+func (t *mtree) QueryOrdered(from, to int) []Interval {
+	result := t.Query(from, to)
+	SortByFrom(result)
+	return result
+}
+
+// QueryView returns a sequence that yields overlapping intervals while the
+// tree is traversed, see stree.QueryView. The traversal is sequential as
+// yield must not be called concurrently.
+func (t *mtree) QueryView(from, to int) IntervalSeq {
+	t.checkBuilt()
+	return func(yield func(Interval) bool) {
+		seen := make(map[int]struct{})
+		viewSingle(t.root, from, to, t.overlapFunc(), seen, yield)
+	}
+}
+
+// QueryFunc calls fn for every overlapping interval and stops when fn
+// returns false, see stree.QueryFunc
+func (t *mtree) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.QueryView(from, to)(fn)
+}
+
+// viewSingle traverses tree and yields overlaps, returns false if iteration stopped
+func viewSingle(node *mnode, from, to int, overlaps OverlapFunc, seen map[int]struct{}, yield func(Interval) bool) bool {
+	if !overlaps(node.segment, from, to) {
+		return true
+	}
+	for _, pintrvl := range node.overlap {
+		if _, ok := seen[pintrvl.Id]; !ok {
+			seen[pintrvl.Id] = struct{}{}
+			if !yield(*pintrvl) {
+				return false
+			}
+		}
+	}
+	if node.right != nil && !viewSingle(node.right, from, to, overlaps, seen, yield) {
+		return false
+	}
+	if node.left != nil && !viewSingle(node.left, from, to, overlaps, seen, yield) {
+		return false
+	}
+	return true
+}
+
+// QueryDetailed returns the segment and the stored intervals of every node
+// the query found intervals at, see stree.QueryDetailed. The traversal is
+// sequential to keep the order of nodes.
+func (t *mtree) QueryDetailed(from, to int) []SegmentOverlap {
+	t.checkBuilt()
+	result := make([]SegmentOverlap, 0, 10)
+	queryDetailed(t.root, from, to, t.overlapFunc(), &result)
+	return result
+}
+
+// queryDetailed traverses tree and appends nodes with overlaps to result
+func queryDetailed(node *mnode, from, to int, overlaps OverlapFunc, result *[]SegmentOverlap) {
+	if !overlaps(node.segment, from, to) {
+		return
+	}
+	if len(node.overlap) != 0 {
+		*result = append(*result, SegmentOverlap{Segment: node.segment, Interval: node.Overlap()})
+	}
+	if node.left != nil {
+		queryDetailed(node.left, from, to, overlaps, result)
+	}
+	if node.right != nil {
+		queryDetailed(node.right, from, to, overlaps, result)
+	}
+}
+
+// Depth returns the number of intervals that contain point, see stree.Depth
+func (t *mtree) Depth(point int) int {
+	t.checkBuilt()
+	depth := 0
+	for node := t.root; node != nil && !node.segment.Disjoint(point, point); {
+		depth += len(node.overlap)
+		if node.left != nil && point <= node.left.segment.To {
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+	return depth
+}
+
+// Stab returns the intervals that contain point, see stree.Stab. The path
+// to the leaf of point is short, it is searched by a single goroutine.
+func (t *mtree) Stab(point int) []Interval {
+	t.checkBuilt()
+	result := make([]Interval, 0, 10)
+	for node := t.root; node != nil && !node.segment.Disjoint(point, point); {
+		for _, pintrvl := range node.overlap {
+			result = append(result, *pintrvl)
+		}
+		if node.left != nil && point <= node.left.segment.To {
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+	return result
+}
+
+// Nearest returns the interval nearest to point, see stree.Nearest. The
+// search is pruned by the best interval found so far, it runs in a single
+// goroutine.
+func (t *mtree) Nearest(point int) (Interval, int, bool) {
+	t.checkBuilt()
+	return Nearest(t.root, point)
+}
+
+// Enclosing returns the intervals that contain (from, to), see stree.Enclosing
+func (t *mtree) Enclosing(from, to int) []Interval {
+	t.checkBuilt()
+	return Enclosing(t.root, from, to)
+}
+
+// QueryStartsIn returns the intervals that start in the range (from, to)
+// in ascending order of From, see stree.QueryStartsIn
+func (t *mtree) QueryStartsIn(from, to int) []Interval {
+	t.checkBuilt()
+	return t.index.StartsIn(t.base, from, to)
+}
+
+// QueryEndsIn returns the intervals that end in the range (from, to)
+// in ascending order of To, see stree.QueryEndsIn
+func (t *mtree) QueryEndsIn(from, to int) []Interval {
+	t.checkBuilt()
+	return t.index.EndsIn(t.base, from, to)
+}
+
+// FlowCounts returns the number of intervals that start in the range (from,
+// to) and the number that end in it, see stree.FlowCounts
+func (t *mtree) FlowCounts(from, to int) (starts, ends int) {
+	t.checkBuilt()
+	return t.index.FlowCounts(t.base, from, to)
+}
+
+// QueryMinLength returns the overlapping intervals with To - From >= minLen,
+// see stree.QueryMinLength. The result of the parallel query is filtered.
+func (t *mtree) QueryMinLength(from, to, minLen int) []Interval {
+	result := t.Query(from, to)
+	n := 0
 	for _, intrvl := range result {
-		sl = append(sl, intrvl)
+		if int64(intrvl.To)-int64(intrvl.From) >= int64(minLen) {
+			result[n] = intrvl
+			n++
+		}
 	}
-	return sl
+	return result[:n]
+}
+
+// QueryRecent returns the n overlapping intervals with the highest Ids,
+// highest Id first, see stree.QueryRecent. Intervals are inserted into
+// nodes concurrently, so the result of the parallel query is sorted.
+func (t *mtree) QueryRecent(from, to, n int) []Interval {
+	return t.QueryTopK(from, to, n, func(a, b Interval) bool { return a.Id < b.Id })
+}
+
+// StabBest returns the interval containing point that is preferred by
+// prefer, see stree.StabBest
+func (t *mtree) StabBest(point int, prefer Preference) (Interval, bool) {
+	return Best(t.QueryView(point, point), prefer)
+}
+
+// QueryTopK returns the k overlapping intervals that are greatest by less,
+// greatest first, see stree.QueryTopK. The result of the parallel query is sorted.
+func (t *mtree) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	result := t.Query(from, to)
+	sort.SliceStable(result, func(i, j int) bool { return less(result[j], result[i]) })
+	if k < 0 {
+		k = 0
+	}
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
+
+// Query interval and assign result to non-overlapping layers
+func (t *mtree) QueryLayered(from, to int) [][]Interval {
+	return Layers(t.Query(from, to))
+}
+
+// LayerCount returns the number of layers of QueryLayered without building
+// them. The intervals found all overlap the query, so two of them overlap
+// inside the query if they overlap at all and clipping them to the query
+// doesn't change the count.
+func (t *mtree) LayerCount(from, to int) int {
+	return LayerCount(t.Query(from, to))
 }
 
 // queryMulti traverses tree parallel in search of overlaps with multiple intervals
-func queryMulti(node *mnode, from, to []int, result *map[int]Interval, tw *twalker, back bool) {
+func queryMulti(node *mnode, depth int, from, to []int, overlaps OverlapFunc, result *map[int]Interval, tw *twalker, back bool) {
 	hitsFrom := make([]int, 0, 2)
 	hitsTo := make([]int, 0, 2)
 	for i, fromvalue := range from {
-		if !node.segment.Disjoint(fromvalue, to[i]) {
+		if overlaps(node.segment, fromvalue, to[i]) {
 			for _, pintrvl := range node.overlap {
 				(*result)[pintrvl.Id] = *pintrvl
 			}
@@ -363,28 +1470,29 @@ func queryMulti(node *mnode, from, to []int, result *map[int]Interval, tw *twalk
 	// search in children only with overlapping intervals of parent
 	if len(hitsFrom) != 0 {
 		if node.right != nil {
-			// buffered channel tw.queue is a safe counter to limit number of started goroutines
+			// buffered channel tw.queue is a safe counter to limit number of started goroutines,
+			// below the spawn depth tw.spawn returns nil and the query continues inline
 			select {
-			case tw.queue <- 1:
+			case tw.spawn(depth) <- 1:
 				// create new map for result
 				newMap := make(map[int]Interval)
 				// increment counter of wait group
 				tw.wait.Add(1)
 				// start new query in goroutine
-				go queryMulti(node.right, from, to, &newMap, tw, true)
+				go queryMulti(node.right, depth+1, hitsFrom, hitsTo, overlaps, &newMap, tw, true)
 			default:
 				// pass-through result map of parent
-				queryMulti(node.right, from, to, result, tw, false)
+				queryMulti(node.right, depth+1, hitsFrom, hitsTo, overlaps, result, tw, false)
 			}
 		}
 		if node.left != nil {
 			select {
-			case tw.queue <- 1:
+			case tw.spawn(depth) <- 1:
 				newMap := make(map[int]Interval)
 				tw.wait.Add(1)
-				go queryMulti(node.left, from, to, &newMap, tw, true)
+				go queryMulti(node.left, depth+1, hitsFrom, hitsTo, overlaps, &newMap, tw, true)
 			default:
-				queryMulti(node.left, from, to, result, tw, false)
+				queryMulti(node.left, depth+1, hitsFrom, hitsTo, overlaps, result, tw, false)
 			}
 		}
 	}
@@ -396,3 +1504,78 @@ func queryMulti(node *mnode, from, to []int, result *map[int]Interval, tw *twalk
 		tw.wait.Done()
 	}
 }
+
+// QueryArrayGrouped queries the interval array in parallel like QueryArray,
+// but returns the result of every query separately: result[i] holds the
+// intervals overlapping (from[i], to[i]). Each branch of the tree walker
+// only descends with the indices of the queries that still overlap.
+func (t *mtree) QueryArrayGrouped(from, to []int) [][]Interval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	t.checkBuilt()
+	result := newGroups(len(from))
+	live := make([]int, len(from))
+	for i := range live {
+		live[i] = i
+	}
+	tw := getWalker(t.queryWorkers(), t.spawnDepth)
+	if tw.groups == nil {
+		tw.groups = make(chan []map[int]Interval, tw.num)
+	}
+	queryGrouped(t.root, 0, from, to, live, t.overlapFunc(), result, tw, false)
+	tw.collectGroups(result)
+	putWalker(tw)
+	grouped := make([][]Interval, len(result))
+	for i, rmap := range result {
+		grouped[i] = byId(rmap)
+	}
+	return grouped
+}
+
+// newGroups returns a result map for each of n queries
+func newGroups(n int) []map[int]Interval {
+	groups := make([]map[int]Interval, n)
+	for i := range groups {
+		groups[i] = make(map[int]Interval)
+	}
+	return groups
+}
+
+// queryGrouped traverses tree parallel in search of overlaps with the live queries
+func queryGrouped(node *mnode, depth int, from, to, live []int, overlaps OverlapFunc, result []map[int]Interval, tw *twalker, back bool) {
+	hits := make([]int, 0, 2)
+	for _, index := range live {
+		if overlaps(node.segment, from[index], to[index]) {
+			for _, pintrvl := range node.overlap {
+				result[index][pintrvl.Id] = *pintrvl
+			}
+			hits = append(hits, index)
+		}
+	}
+	// search in children only with overlapping queries of parent
+	if len(hits) != 0 {
+		if node.right != nil {
+			select {
+			case tw.spawn(depth) <- 1:
+				tw.wait.Add(1)
+				go queryGrouped(node.right, depth+1, from, to, hits, overlaps, newGroups(len(from)), tw, true)
+			default:
+				queryGrouped(node.right, depth+1, from, to, hits, overlaps, result, tw, false)
+			}
+		}
+		if node.left != nil {
+			select {
+			case tw.spawn(depth) <- 1:
+				tw.wait.Add(1)
+				go queryGrouped(node.left, depth+1, from, to, hits, overlaps, newGroups(len(from)), tw, true)
+			default:
+				queryGrouped(node.left, depth+1, from, to, hits, overlaps, result, tw, false)
+			}
+		}
+	}
+	if back {
+		tw.groups <- result
+		tw.wait.Done()
+	}
+}
@@ -5,70 +5,32 @@
 package multi
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"fmt"
 	. "github.com/toberndo/go-stree/stree"
 	"math"
 	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestTreeEqualMTree(t *testing.T) {
 	tree := NewTree()
 	mtree := NewMTree()
-	for i := 0; i < 1000; i++ {
-		min := rand.Int()
-		max := rand.Int()
-		if min > max {
-			min, max = max, min
-		}
-		tree.Push(min, max)
-		mtree.Push(min, max)
-	}
+	from, to := GenerateIntervals(1000, math.MaxInt, 1, UNIFORM)
+	tree.PushArray(from, to)
+	mtree.PushArray(from, to)
 	tree.BuildTree()
 	mtree.BuildTree()
-	tSegArray := tree.Tree2Array()
-	mSegArray := mtree.Tree2Array()
-	fail := false
-	if len(tSegArray) != len(mSegArray) {
-		fail = true
-		fmt.Printf("unequal array length")
-		goto Fail
-	}
-outer:
-	for i, seg := range tSegArray {
-		mseg := mSegArray[i]
-		if seg.Segment != mseg.Segment {
-			fail = true
-			fmt.Printf("unequal segment")
-			break
-		}
-
-		if len(seg.Interval) != len(mseg.Interval) {
-			fail = true
-			fmt.Printf("unequal interval len\n")
-			fmt.Println(len(seg.Interval))
-			fmt.Println(len(mseg.Interval))
-			break
-		}
-		for _, intrvl := range seg.Interval {
-			count := 0
-			for _, mintrvl := range mseg.Interval {
-				if intrvl == mintrvl {
-					count++
-				}
-			}
-			if count != 1 {
-				fail = true
-				fmt.Printf("unequal count\n")
-				break outer
-			}
-		}
-	}
-Fail:
-	if fail {
+	if !Equal(tree, mtree) {
 		t.Errorf("Trees not equal")
 	}
-
 }
 
 func TestMinimalTree(t *testing.T) {
@@ -169,15 +131,12 @@ func buildTree(b *testing.B, tree Tree, count int) {
 	}
 }
 
+// seed of next intervals pushed by pushRandom
+var seed int64
+
 func pushRandom(tree Tree, count int) {
-	for j := 0; j < count; j++ {
-		min := rand.Int()
-		max := rand.Int()
-		if min > max {
-			min, max = max, min
-		}
-		tree.Push(min, max)
-	}
+	seed++
+	tree.PushArray(GenerateIntervals(count, math.MaxInt, seed, UNIFORM))
 }
 
 func BenchmarkInsertNodesMulti100000(b *testing.B) {
@@ -188,7 +147,7 @@ func BenchmarkInsertNodesMulti100000(b *testing.B) {
 		var endpoint []int
 		endpoint, tree.min, tree.max = Endpoints(tree.base)
 		b.StartTimer()
-		tree.root = tree.insertNodes(endpoint, 0)
+		tree.root = tree.insertNodes(ElementaryIntervals(endpoint), 0)
 		for i := 0; i < tree.numG; i++ {
 			<-tree.done
 		}
@@ -202,7 +161,7 @@ func BenchmarkInsertIntervalsMulti100000(b *testing.B) {
 		pushRandom(tree, 100000)
 		var endpoint []int
 		endpoint, tree.min, tree.max = Endpoints(tree.base)
-		tree.root = tree.insertNodes(endpoint, 0)
+		tree.root = tree.insertNodes(ElementaryIntervals(endpoint), 0)
 		for i := 0; i < tree.numG; i++ {
 			<-tree.done
 		}
@@ -217,15 +176,9 @@ var multi Tree
 func init() {
 	tree = NewTree()
 	multi = NewMTree()
-	for j := 0; j < 100000; j++ {
-		min := rand.Int()
-		max := rand.Int()
-		if min > max {
-			min, max = max, min
-		}
-		tree.Push(min, max)
-		multi.Push(min, max)
-	}
+	from, to := GenerateIntervals(100000, math.MaxInt, 1, UNIFORM)
+	tree.PushArray(from, to)
+	multi.PushArray(from, to)
 	tree.BuildTree()
 	multi.BuildTree()
 }
@@ -254,6 +207,30 @@ func BenchmarkQueryMulitMax(b *testing.B) {
 	}
 }
 
+func BenchmarkCountMulti(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		multi.Count(0, math.MaxInt/2)
+	}
+}
+
+func BenchmarkCountMultiLenQuery(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = len(multi.Query(0, math.MaxInt/2))
+	}
+}
+
+func BenchmarkStabMulti(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		multi.Stab(math.MaxInt / 4)
+	}
+}
+
+func BenchmarkStabMultiQuery(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		multi.Query(math.MaxInt/4, math.MaxInt/4)
+	}
+}
+
 func BenchmarkQueryArray(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		tree.QueryArray([]int{0, 100000000, 200000000, 300000000, 400000000, 500000000, 600000000, 700000000, 800000000, 900000000},
@@ -267,3 +244,717 @@ func BenchmarkQueryArrayMulti(b *testing.B) {
 			[]int{50000000, 150000000, 250000000, 350000000, 450000000, 550000000, 650000000, 750000000, 850000000, 950000000})
 	}
 }
+
+func TestWarmup(t *testing.T) {
+	tree := NewMTree()
+	pushRandom(tree, 1000)
+	tree.BuildTree()
+	tree.Warmup()
+	if result := tree.Query(0, math.MaxInt); len(result) != 1000 {
+		t.Errorf("fail query after warmup: %d", len(result))
+	}
+}
+
+func BenchmarkQueryMultiCold(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		b.StartTimer()
+		multi.Query(0, 100000)
+	}
+}
+
+func BenchmarkQueryMultiWarm(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		multi.(MTree).Warmup()
+		b.StartTimer()
+		multi.Query(0, 100000)
+	}
+}
+
+func TestQueryEqualSerial(t *testing.T) {
+	mtree := NewMTree()
+	serial := NewSerial()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(10000)
+		to := from + rand.Intn(100)
+		mtree.Push(from, to)
+		serial.Push(from, to)
+	}
+	mtree.BuildTree()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(10100)
+		to := from + rand.Intn(10)
+		if len(mtree.Query(from, to)) != len(serial.Query(from, to)) {
+			t.Errorf("fail query (%d, %d) between endpoints", from, to)
+		}
+	}
+}
+
+func TestPushKey(t *testing.T) {
+	tree := NewMTree()
+	tree.PushKey(1, 3, "a")
+	tree.Push(2, 5)
+	tree.BuildTree()
+	if result := tree.Query(1, 1); len(result) != 1 || result[0].Key != "a" {
+		t.Errorf("fail key of query result: %v", result)
+	}
+	if intrvl, ok := tree.GetByKey("a"); !ok || intrvl.Id != 0 {
+		t.Errorf("fail get by key: %v", intrvl)
+	}
+}
+
+func TestSingleIntervalTree(t *testing.T) {
+	tree := NewTree()
+	mtree := NewMTree()
+	tree.Push(3, 7)
+	mtree.Push(3, 7)
+	tree.BuildTree()
+	mtree.BuildTree()
+	if !Equal(tree, mtree) {
+		t.Errorf("Trees not equal")
+	}
+	for _, query := range [][2]int{{1, 2}, {2, 3}, {5, 5}, {7, 9}, {8, 9}} {
+		if len(mtree.Query(query[0], query[1])) != len(tree.Query(query[0], query[1])) {
+			t.Errorf("fail query (%d, %d)", query[0], query[1])
+		}
+	}
+}
+
+func TestSinglePointTree(t *testing.T) {
+	serial := NewSerial()
+	mtree := NewMTree()
+	serial.Push(5, 5)
+	mtree.Push(5, 5)
+	mtree.BuildTree()
+	for _, query := range [][2]int{{1, 4}, {1, 5}, {5, 5}, {5, 9}, {6, 9}, {9, 1}} {
+		expected := serial.Query(query[0], query[1])
+		if result := mtree.Query(query[0], query[1]); !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail query (%d, %d): %v, expected %v", query[0], query[1], result, expected)
+		}
+		if result := mtree.QueryArray([]int{query[0]}, []int{query[1]}); !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail query array (%d, %d): %v, expected %v", query[0], query[1], result, expected)
+		}
+		count := 0
+		for range mtree.QueryView(query[0], query[1]) {
+			count++
+		}
+		if count != len(expected) {
+			t.Errorf("fail query view (%d, %d): %d, expected %v", query[0], query[1], count, expected)
+		}
+	}
+	// a second interval on the point is inserted into the root leaf
+	serial.(Updater).Insert(5, 5)
+	mtree.Insert(5, 5)
+	if result := mtree.Query(5, 5); len(result) != 2 || len(serial.Query(5, 5)) != 2 {
+		t.Errorf("fail query after insert: %v", result)
+	}
+}
+
+func TestQueryView(t *testing.T) {
+	count := 0
+	for range multi.QueryView(0, math.MaxInt) {
+		count++
+	}
+	if count != 100000 {
+		t.Errorf("fail query view: %d", count)
+	}
+}
+
+func TestQueryArrayLength(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrArrayLength {
+			t.Errorf("fail panic on unequal length: %v", r)
+		}
+	}()
+	multi.QueryArray([]int{1, 2}, []int{3})
+}
+
+func TestSetOverlapFunc(t *testing.T) {
+	mtree := NewMTree()
+	serial := NewSerial()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(10000)
+		to := from + rand.Intn(100)
+		mtree.Push(from, to)
+		serial.Push(from, to)
+	}
+	mtree.BuildTree()
+	mtree.SetOverlapFunc(WithinDistance(50))
+	serial.SetOverlapFunc(WithinDistance(50))
+	queries := make([]Segment, 100)
+	for i := range queries {
+		queries[i].From = rand.Intn(10100)
+		queries[i].To = queries[i].From + rand.Intn(10)
+	}
+	if !EqualResults(mtree, serial, queries) {
+		t.Errorf("fail query within distance")
+	}
+}
+
+func TestQueryArrayGrouped(t *testing.T) {
+	mtree := NewMTree()
+	serial := NewSerial()
+	for i := 0; i < 10000; i++ {
+		from := rand.Intn(100000)
+		to := from + rand.Intn(1000)
+		mtree.Push(from, to)
+		serial.Push(from, to)
+	}
+	mtree.BuildTree()
+	from := make([]int, 50)
+	to := make([]int, 50)
+	for i := range from {
+		from[i] = rand.Intn(101000)
+		to[i] = from[i] + rand.Intn(100)
+	}
+	grouped := mtree.QueryArrayGrouped(from, to)
+	if len(grouped) != len(from) {
+		t.Fatalf("fail number of groups: %d", len(grouped))
+	}
+	for i, result := range grouped {
+		expected := serial.Query(from[i], to[i])
+		sort.Sort(ById(result))
+		sort.Sort(ById(expected))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail group %d: %d != %d intervals", i, len(result), len(expected))
+		}
+	}
+	// QueryArray returns the union of the groups
+	union := make(map[int]bool)
+	for _, group := range grouped {
+		for _, intrvl := range group {
+			union[intrvl.Id] = true
+		}
+	}
+	if result := mtree.QueryArray(from, to); len(result) != len(union) {
+		t.Errorf("fail query array: %d != %d", len(result), len(union))
+	}
+}
+
+func TestWalkerPool(t *testing.T) {
+	tree := NewMTree()
+	pushRandom(tree, 1000)
+	tree.BuildTree()
+	expected := len(tree.Query(0, math.MaxInt))
+	// a pooled walker must start every query with an empty queue
+	for i := 0; i < 10; i++ {
+		if result := tree.Query(0, math.MaxInt); len(result) != expected {
+			t.Errorf("fail query with pooled walker: %d != %d", len(result), expected)
+		}
+	}
+	tw := getWalker(NUM_WORKER, SPAWN_DEPTH)
+	if len(tw.queue) != 0 || len(tw.result) != 0 {
+		t.Errorf("fail reset of pooled walker")
+	}
+	putWalker(tw)
+}
+
+func TestQueryMinLength(t *testing.T) {
+	tree := NewMTree()
+	tree.PushArray([]int{1, 3, 4, 2}, []int{10, 3, 6, 5})
+	tree.BuildTree()
+	if result := tree.QueryMinLength(3, 4, 3); len(result) != 2 {
+		t.Errorf("fail query min length: %v", result)
+	}
+}
+
+func BenchmarkQueryMultiSpawnDepth(b *testing.B) {
+	tree := NewMTree()
+	for i := 0; i < 10000; i++ {
+		from := rand.Intn(1000000)
+		tree.Push(from, from+rand.Intn(1000))
+	}
+	tree.BuildTree()
+	for _, depth := range []int{0, 4, 8, 12, 16, math.MaxInt} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			tree.SetSpawnDepth(depth)
+			for i := 0; i < b.N; i++ {
+				tree.Query(0, 500000)
+			}
+		})
+	}
+}
+
+func TestSetSpawnDepth(t *testing.T) {
+	tree := NewMTree()
+	pushRandom(tree, 10000)
+	tree.BuildTree()
+	expected := len(tree.Query(0, math.MaxInt))
+	for _, depth := range []int{0, 1, 4, math.MaxInt} {
+		tree.SetSpawnDepth(depth)
+		if result := tree.Query(0, math.MaxInt); len(result) != expected {
+			t.Errorf("fail query with spawn depth %d: %d != %d", depth, len(result), expected)
+		}
+	}
+}
+
+func TestPushArrayParallel(t *testing.T) {
+	from, to := GenerateIntervals(10000, 1000000, 7, UNIFORM)
+	tree := NewMTree()
+	tree.Push(1, 2)
+	tree.PushArrayParallel(from, to)
+	tree.Push(3, 4)
+	other := NewMTree()
+	other.Push(1, 2)
+	other.PushArray(from, to)
+	other.Push(3, 4)
+	tree.BuildTree()
+	other.BuildTree()
+	if !Equal(tree, other) {
+		t.Errorf("fail push array parallel")
+	}
+	tree.Clear()
+	tree.PushArrayParallel([]int{1, 2, 3}, []int{1, 5, 3})
+	if n := tree.PointIntervalCount(); n != 2 {
+		t.Errorf("fail point interval count: %d", n)
+	}
+}
+
+func BenchmarkPushArray(b *testing.B) {
+	from, to := GenerateIntervals(1000000, math.MaxInt, 1, UNIFORM)
+	tree := NewMTree()
+	for i := 0; i < b.N; i++ {
+		tree.Clear()
+		tree.PushArray(from, to)
+	}
+}
+
+func BenchmarkPushArrayParallel(b *testing.B) {
+	from, to := GenerateIntervals(1000000, math.MaxInt, 1, UNIFORM)
+	tree := NewMTree()
+	for i := 0; i < b.N; i++ {
+		tree.Clear()
+		tree.PushArrayParallel(from, to)
+	}
+}
+
+func TestRestoreOverlaps(t *testing.T) {
+	from, to := GenerateIntervals(1000, 10000, 9, UNIFORM)
+	tree := NewMTree()
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	state := tree.SnapshotOverlaps()
+	expected := tree.Tree2Array()
+	restored := NewMTree()
+	restored.PushArray(to, to)
+	restored.BuildTreeWithEndpoints(Dedup(append(from, to...)), state.Segments[0].From, state.Segments[0].To)
+	restored.Remove(0)
+	restored.RestoreOverlaps(state)
+	if !reflect.DeepEqual(restored.Tree2Array(), expected) {
+		t.Errorf("fail restore overlaps")
+	}
+	if ratio := restored.RemovedRatio(); ratio != 0 {
+		t.Errorf("fail restore overlaps, removed ratio %v", ratio)
+	}
+}
+
+func TestNewMTreeOrdered(t *testing.T) {
+	from, to := GenerateIntervals(500, 1000, 12, UNIFORM)
+	tree := NewMTreeOrdered(func(a, b Interval) bool { return a.Id < b.Id })
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	if result := tree.Query(0, 500); !sort.IsSorted(ById(result)) || len(result) == 0 {
+		t.Errorf("fail ordered query: %v", result)
+	}
+	if result := tree.QueryArray([]int{0, 600}, []int{10, 700}); !sort.IsSorted(ById(result)) || len(result) == 0 {
+		t.Errorf("fail ordered query array: %v", result)
+	}
+}
+
+func TestInsertCloneEmpty(t *testing.T) {
+	tree := NewMTree()
+	tree.PushArray([]int{0, 10, 20}, []int{20, 30, 40})
+	tree.BuildTree()
+	clone := tree.CloneEmpty()
+	clone.Insert(20, 30)
+	clone.Insert(0, 10)
+	tree.Insert(10, 20)
+	other := NewMTree()
+	other.PushArray([]int{20, 0}, []int{30, 10})
+	other.BuildTreeWithEndpoints([]int{0, 10, 20, 30, 40}, 0, 40)
+	if !Equal(clone, other) {
+		t.Errorf("fail insert into clone")
+	}
+	if result := tree.Query(15, 15); len(result) != 3 {
+		t.Errorf("fail insert: %v", result)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	mtree := NewMTree()
+	serial := NewSerial()
+	from, to := GenerateIntervals(1000, 10000, 1, UNIFORM)
+	mtree.PushArray(from, to)
+	serial.PushArray(from, to)
+	mtree.BuildTree()
+	for point := 0; point <= 10000; point += 7 {
+		if depth := mtree.Depth(point); depth != serial.Depth(point) {
+			t.Errorf("fail depth %d: %d, expected %d", point, depth, serial.Depth(point))
+		}
+	}
+}
+
+func TestBuildTreeError(t *testing.T) {
+	mtree := NewMTree()
+	if err := mtree.BuildTree(); err != ErrNoIntervals {
+		t.Errorf("fail error of build without intervals: %v", err)
+	}
+	mtree.Push(1, 5)
+	if err := mtree.BuildTree(); err != nil {
+		t.Errorf("fail build: %v", err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	mtree := NewMTree()
+	sparse := NewMTree()
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	mtree.PushArray(from, to)
+	for i := range from {
+		sparse.PushWithId(2*i, from[i], to[i])
+	}
+	mtree.BuildTree()
+	sparse.BuildTree()
+	for _, query := range [][2]int{{0, 100000}, {-5, -1}, {500, 500}, {20000, 40000}} {
+		expected := len(mtree.Query(query[0], query[1]))
+		if count := mtree.Count(query[0], query[1]); count != expected {
+			t.Errorf("fail count (%d, %d): %d, expected %d", query[0], query[1], count, expected)
+		}
+		if count := sparse.Count(query[0], query[1]); count != expected {
+			t.Errorf("fail count (%d, %d) with sparse Ids: %d, expected %d", query[0], query[1], count, expected)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tree := NewTree()
+	mtree := NewMTree()
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	mtree.PushArray(from, to)
+	tree.BuildTree()
+	mtree.BuildTree()
+	for _, id := range []int{3, 999, 0} {
+		if tree.Remove(id) != mtree.Remove(id) {
+			t.Errorf("fail remove %d", id)
+		}
+	}
+	if mtree.Remove(3) {
+		t.Errorf("fail remove of missing interval")
+	}
+	if !Equal(tree, mtree) {
+		t.Errorf("Trees not equal after remove")
+	}
+	if ratio := mtree.RemovedRatio(); ratio != tree.RemovedRatio() || ratio != 0.003 {
+		t.Errorf("fail removed ratio: %f", ratio)
+	}
+	if mtree.IsSkewed() {
+		t.Errorf("fail skew after few removals")
+	}
+	tree.Compact()
+	if err := mtree.Compact(); err != nil || mtree.RemovedRatio() != 0 || !Equal(tree, mtree) {
+		t.Errorf("fail compact: %v", err)
+	}
+}
+
+func TestQueryFunc(t *testing.T) {
+	mtree := NewMTree()
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	mtree.PushArray(from, to)
+	mtree.BuildTree()
+	count := 0
+	mtree.QueryFunc(0, 100000, func(intrvl Interval) bool {
+		count++
+		return true
+	})
+	if count != 1000 {
+		t.Errorf("fail query func: %d intervals", count)
+	}
+	count = 0
+	mtree.QueryFunc(0, 100000, func(intrvl Interval) bool {
+		count++
+		return count < 5
+	})
+	if count != 5 {
+		t.Errorf("fail early stop of query func: %d", count)
+	}
+}
+
+func TestGobMTree(t *testing.T) {
+	tree := NewTree()
+	from, to := GenerateIntervals(10000, 1000000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	tree.Remove(10)
+	tree.BuildTree()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(tree); err != nil {
+		t.Fatalf("fail encode: %v", err)
+	}
+	mtree := NewMTree()
+	if err := gob.NewDecoder(&buf).Decode(mtree); err != nil {
+		t.Fatalf("fail decode: %v", err)
+	}
+	mtree.BuildTree()
+	if !Equal(tree, mtree) {
+		t.Errorf("Trees not equal after gob round-trip")
+	}
+	for _, query := range [][2]int{{0, 1000000}, {200000, 400000}, {-10, -1}} {
+		expected := tree.Query(query[0], query[1])
+		result := mtree.Query(query[0], query[1])
+		sort.Sort(ById(expected))
+		sort.Sort(ById(result))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail query (%d, %d) of decoded tree", query[0], query[1])
+		}
+	}
+}
+
+func TestMTreeConfig(t *testing.T) {
+	tree := NewTree()
+	from, to := GenerateIntervals(10000, 1000000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	for _, config := range [][2]int{{4, 2}, {1, 1}, {5, 3}, {0, 0}} {
+		mtree := NewMTreeConfig(config[0], config[1])
+		for i := 0; i < 2; i++ {
+			// configuration survives Clear
+			mtree.Clear()
+			mtree.PushArray(from, to)
+			mtree.BuildTree()
+			if !Equal(tree, mtree) {
+				t.Errorf("Trees not equal with config %v", config)
+			}
+			expected := tree.Query(200000, 400000)
+			result := mtree.Query(200000, 400000)
+			sort.Sort(ById(expected))
+			sort.Sort(ById(result))
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("fail query with config %v", config)
+			}
+		}
+	}
+	if numG := NewMTreeConfig(5, 3).(*mtree).numG; numG != 8 {
+		t.Errorf("fail build goroutines: %d", numG)
+	}
+	if workers := NewMTreeConfig(5, 3).(*mtree).queryWorkers(); workers != 3 {
+		t.Errorf("fail query workers: %d", workers)
+	}
+	if numG := NewMTreeConfig(0, 0).(*mtree).numG; numG != 1<<P_LEVEL {
+		t.Errorf("fail default build goroutines: %d", numG)
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	tree := NewTree()
+	mtree := NewMTree()
+	from, to := GenerateIntervals(10000, 1000000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	mtree.PushArray(from, to)
+	tree.BuildTree()
+	mtree.BuildTree()
+	result, err := mtree.QueryContext(context.Background(), 200000, 400000)
+	if err != nil {
+		t.Fatalf("fail query context: %v", err)
+	}
+	expected := tree.Query(200000, 400000)
+	sort.Sort(ById(expected))
+	sort.Sort(ById(result))
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("fail query context: result differs from Query")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if result, err := mtree.QueryContext(ctx, 200000, 400000); err != context.Canceled || result != nil {
+		t.Errorf("fail query with cancelled context: %v", err)
+	}
+	// cancel mid-query from the overlap function of the visited nodes
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel = context.WithCancel(context.Background())
+	var visited atomic.Int32
+	mtree.SetOverlapFunc(func(segment Segment, from, to int) bool {
+		if visited.Add(1) == 100 {
+			cancel()
+		}
+		return Overlaps(segment, from, to)
+	})
+	if result, err := mtree.QueryContext(ctx, 200000, 400000); err != context.Canceled || result != nil {
+		t.Errorf("fail query cancelled mid-query: %v", err)
+	}
+	if n := visited.Load(); n < 100 {
+		t.Errorf("fail query cancelled mid-query: %d nodes visited", n)
+	}
+	// goroutines of the tree walker have reported back, let them exit
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("fail query cancelled mid-query: %d goroutines left, expected %d", n, goroutines)
+	}
+	mtree.SetOverlapFunc(nil)
+	if len(mtree.Query(200000, 400000)) != len(expected) {
+		t.Errorf("fail query after cancelled query")
+	}
+}
+
+func TestStab(t *testing.T) {
+	tree := NewTree()
+	mtree := NewMTree()
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	mtree.PushArray(from, to)
+	tree.BuildTree()
+	mtree.BuildTree()
+	for _, point := range []int{-1, 0, 500, 50000, 99999, 100001} {
+		expected := tree.Query(point, point)
+		result := mtree.Stab(point)
+		sort.Sort(ById(expected))
+		sort.Sort(ById(result))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail stab %d: %d intervals, expected %d", point, len(result), len(expected))
+		}
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	from, to := GenerateIntervals(1000, 100000, 14, UNIFORM)
+	ids := rand.New(rand.NewSource(14)).Perm(len(from))
+	tree := NewTree()
+	mtree := NewMTree()
+	ordered := NewMTreeOrdered(func(a, b Interval) bool { return a.Id < b.Id })
+	for _, tree := range []Tree{tree, mtree, ordered} {
+		for i := range from {
+			tree.PushWithId(ids[i], from[i], to[i])
+		}
+		tree.BuildTree()
+	}
+	expected := tree.QueryInsertionOrder(20000, 40000)
+	array := tree.QueryArray([]int{20000, 60000}, []int{40000, 70000})
+	sort.Sort(ById(array))
+	for run := 0; run < 2; run++ {
+		// the parallel query is sorted by Id
+		if result := mtree.Query(20000, 40000); !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail query order in run %d", run)
+		}
+		if result := mtree.QueryArray([]int{20000, 60000}, []int{40000, 70000}); !reflect.DeepEqual(result, array) {
+			t.Errorf("fail query array order in run %d", run)
+		}
+		if result := mtree.QueryInsertionOrder(20000, 40000); !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail insertion order in run %d", run)
+		}
+		if result := ordered.Query(20000, 40000); !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail ordered query in run %d", run)
+		}
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	tree := NewTree()
+	mtree := NewMTree()
+	from, to := GenerateIntervals(1000, 100000, 15, UNIFORM)
+	tree.PushArray(from, to)
+	mtree.PushArray(from, to)
+	mtree.BuildTree()
+	intervals := mtree.Intervals()
+	if !reflect.DeepEqual(intervals, tree.Intervals()) {
+		t.Errorf("fail intervals")
+	}
+	intervals[0].From = -1
+	if mtree.Intervals()[0].From != from[0] {
+		t.Errorf("fail copy of intervals")
+	}
+}
+
+func TestLenBuilt(t *testing.T) {
+	mtree := NewMTree()
+	if mtree.Len() != 0 || mtree.Built() {
+		t.Errorf("fail len and built of new tree")
+	}
+	pushRandom(mtree, 1000)
+	mtree.BuildTree()
+	if mtree.Len() != 1000 || !mtree.Built() {
+		t.Errorf("fail len and built of tree: %d, %v", mtree.Len(), mtree.Built())
+	}
+	mtree.Remove(5)
+	if mtree.Len() != 999 {
+		t.Errorf("fail len after remove: %d", mtree.Len())
+	}
+}
+
+func TestPushIntervals(t *testing.T) {
+	tree := NewTree()
+	mtree := NewMTree()
+	from, to := GenerateIntervals(1000, 100000, 16, UNIFORM)
+	intervals := make([]Interval, len(from))
+	for i := range from {
+		intervals[i] = Interval{Id: 3*i + 5, Segment: Segment{From: from[i], To: to[i]}}
+	}
+	tree.PushIntervals(intervals)
+	mtree.PushIntervals(intervals)
+	tree.BuildTree()
+	mtree.BuildTree()
+	if !Equal(tree, mtree) {
+		t.Errorf("Trees not equal after push intervals")
+	}
+	if !reflect.DeepEqual(mtree.Intervals(), intervals) {
+		t.Errorf("fail intervals after push intervals")
+	}
+	result := mtree.QueryInsertionOrder(0, 100000)
+	if !reflect.DeepEqual(result, intervals) {
+		t.Errorf("fail query after push intervals")
+	}
+}
+
+func TestNearest(t *testing.T) {
+	serial := NewSerial()
+	mtree := NewMTree()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(100000)
+		to := from + rand.Intn(50)
+		serial.Push(from, to)
+		mtree.Push(from, to)
+	}
+	mtree.BuildTree()
+	for _, point := range []int{-100, 0, 50000, 99999, 100100} {
+		a, da, _ := mtree.Nearest(point)
+		b, db, _ := serial.(LinearQueries).Nearest(point)
+		if a != b || da != db {
+			t.Errorf("fail nearest %d: %v %d != %v %d", point, a, da, b, db)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		point := rand.Intn(100000)
+		if _, distance, _ := mtree.Nearest(point); (distance == 0) != (mtree.Depth(point) > 0) {
+			t.Errorf("fail nearest distance %d at %d", distance, point)
+		}
+	}
+}
+
+func TestDirtyTree(t *testing.T) {
+	mtree := NewMTree()
+	from, to := GenerateIntervals(1000, 100000, 22, UNIFORM)
+	mtree.PushArray(from, to)
+	mtree.BuildTree()
+	mtree.PushArrayParallel([]int{200000}, []int{200010})
+	if !mtree.Dirty() {
+		t.Errorf("fail dirty after parallel push")
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrDirtyTree {
+				t.Errorf("fail panic on query of dirty tree: %v", r)
+			}
+		}()
+		mtree.Query(200000, 200005)
+	}()
+	mtree.BuildTree()
+	if mtree.Dirty() || len(mtree.Query(200000, 200005)) != 1 {
+		t.Errorf("fail query of rebuilt tree")
+	}
+	mtree.Insert(from[0], to[0])
+	if mtree.Dirty() {
+		t.Errorf("fail dirty after insert")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+// Dictionary maps string labels to int coordinates and back. The caller
+// supplies the total order of the labels, the coordinate of a label is its
+// position in that order.
+type Dictionary struct {
+	labels []string
+	coords map[string]int
+}
+
+// NewDictionary returns a Dictionary for labels in ascending order, a label
+// given more than once keeps its first coordinate
+func NewDictionary(labels ...string) *Dictionary {
+	d := &Dictionary{coords: make(map[string]int, len(labels))}
+	for _, label := range labels {
+		if _, ok := d.coords[label]; !ok {
+			d.coords[label] = len(d.labels)
+			d.labels = append(d.labels, label)
+		}
+	}
+	return d
+}
+
+// Coordinate returns the coordinate of label, false if label is unknown
+func (d *Dictionary) Coordinate(label string) (int, bool) {
+	coord, ok := d.coords[label]
+	return coord, ok
+}
+
+// Label returns the label at coordinate, false if there is none
+func (d *Dictionary) Label(coord int) (string, bool) {
+	if coord < 0 || coord >= len(d.labels) {
+		return "", false
+	}
+	return d.labels[coord], true
+}
+
+// NamedTree indexes intervals between labels of a Dictionary, query
+// results carry the labels in Lo and Hi
+type NamedTree struct {
+	tree *ProjectedTree[string]
+	dict *Dictionary
+}
+
+// NewNamedTree returns a NamedTree with underlying segment tree implementation
+func NewNamedTree(dict *Dictionary) *NamedTree {
+	return &NamedTree{tree: NewProjectedTree(func(label string) int {
+		coord, _ := dict.Coordinate(label)
+		return coord
+	}), dict: dict}
+}
+
+// PushNamed pushes the interval between two labels to stack, panics with
+// ErrUnknownLabel if a label is not in the dictionary
+func (t *NamedTree) PushNamed(fromLabel, toLabel string) {
+	if !t.known(fromLabel, toLabel) {
+		panic(ErrUnknownLabel)
+	}
+	t.tree.Push(fromLabel, toLabel)
+}
+
+// Clear the interval stack
+func (t *NamedTree) Clear() {
+	t.tree.Clear()
+}
+
+// Build segment tree out of interval stack, ErrNoIntervals if stack is empty
+func (t *NamedTree) BuildTree() error {
+	return t.tree.BuildTree()
+}
+
+// QueryNamed returns the intervals overlapping the range between two labels.
+// A range with an unknown label matches nothing, the result is empty.
+func (t *NamedTree) QueryNamed(fromLabel, toLabel string) []ProjectedInterval[string] {
+	if !t.known(fromLabel, toLabel) {
+		return []ProjectedInterval[string]{}
+	}
+	return t.tree.Query(fromLabel, toLabel)
+}
+
+// known returns true if all labels are in the dictionary
+func (t *NamedTree) known(labels ...string) bool {
+	for _, label := range labels {
+		if _, ok := t.dict.Coordinate(label); !ok {
+			return false
+		}
+	}
+	return true
+}
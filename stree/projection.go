@@ -0,0 +1,58 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+// ProjectedInterval is an interval of a ProjectedTree with its original bounds
+type ProjectedInterval[T any] struct {
+	Interval
+	Lo, Hi T
+}
+
+// ProjectedTree indexes intervals with bounds of any type T that maps to a
+// total order, e.g. version strings or IP addresses. The projection maps
+// bounds to int coordinates of an underlying segment tree, the original
+// bounds are kept and returned with query results. The projection must
+// preserve the order of T. If it isn't injective, keys with the same
+// projection are indistinguishable: a query for one of them also returns
+// intervals that only contain another one.
+type ProjectedTree[T any] struct {
+	tree    Tree
+	project func(T) int
+	// Original bounds of intervals, indexed by Id
+	bounds [][2]T
+}
+
+// NewProjectedTree returns a ProjectedTree with underlying segment tree implementation
+func NewProjectedTree[T any](project func(T) int) *ProjectedTree[T] {
+	return &ProjectedTree[T]{tree: NewTree(), project: project}
+}
+
+// Push new interval to stack
+func (t *ProjectedTree[T]) Push(lo, hi T) {
+	t.tree.Push(t.project(lo), t.project(hi))
+	t.bounds = append(t.bounds, [2]T{lo, hi})
+}
+
+// Clear the interval stack
+func (t *ProjectedTree[T]) Clear() {
+	t.tree.Clear()
+	t.bounds = t.bounds[:0]
+}
+
+// Build segment tree out of interval stack, ErrNoIntervals if stack is empty
+func (t *ProjectedTree[T]) BuildTree() error {
+	return t.tree.BuildTree()
+}
+
+// Query interval, the bounds are projected to query the underlying tree
+func (t *ProjectedTree[T]) Query(lo, hi T) []ProjectedInterval[T] {
+	result := t.tree.Query(t.project(lo), t.project(hi))
+	projected := make([]ProjectedInterval[T], len(result))
+	for i, intrvl := range result {
+		bounds := t.bounds[intrvl.Id]
+		projected[i] = ProjectedInterval[T]{Interval: intrvl, Lo: bounds[0], Hi: bounds[1]}
+	}
+	return projected
+}
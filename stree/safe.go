@@ -0,0 +1,332 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+// Error is the type of the values the package panics with when a tree is
+// used in the wrong state, e.g. queried before it is built. BuildTree
+// returns ErrNoIntervals instead of panicking.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+const (
+	// BuildTree was called without intervals in stack
+	ErrNoIntervals = Error("No intervals in stack to build tree. Push intervals first")
+	// Query was called before BuildTree
+	ErrEmptyTree = Error("Can't run query on empty tree. Call BuildTree() first")
+	// Query was called after intervals were pushed to a built tree
+	ErrDirtyTree = Error("Intervals were pushed after the tree was built. Call BuildTree() to rebuild it")
+	// QueryArray was called with from and to of different length
+	ErrArrayLength = Error("Query arrays from and to must have equal length")
+	// BuildTreeWithEndpoints was called with endpoints that don't fit the intervals
+	ErrInvalidEndpoints = Error("Endpoints must be sorted, unique and contain all endpoints of intervals")
+	// NewFlatTree was called with data not written by WriteFlat
+	ErrInvalidFlat = Error("Data is not a tree in flat format")
+	// PushNamed was called with a label that is not in the dictionary
+	ErrUnknownLabel = Error("Label is not in dictionary")
+	// RenderView was called with a viewport without pixels or from > to
+	ErrInvalidViewport = Error("Viewport must have positive width and from <= to")
+	// NewCircularTree was called with a period that is not positive
+	ErrInvalidPeriod = Error("Period of circular tree must be positive")
+	// An interval or query of a circular tree is outside of [0, period)
+	ErrOutsidePeriod = Error("Coordinates of circular tree must be in [0, period)")
+	// An interval [from, to) with from >= to was pushed to a half-open tree
+	ErrEmptyHalfOpen = Error("Half-open interval [from, to) must have from < to")
+	// NewWindow was called with a negative width
+	ErrNegativeWidth = Error("Width of window must not be negative")
+	// RestoreOverlaps was called with a snapshot of a tree of different structure
+	ErrStateMismatch = Error("Snapshot doesn't match the structure of the tree. Build tree from the same endpoints")
+	// NewTreeAutoCompact was called with a threshold outside of (0, 1)
+	ErrInvalidThreshold = Error("Threshold of auto-compaction must be in (0, 1)")
+	// GenerateIntervals was called with a Distribution that isn't defined
+	ErrUnknownDistribution = Error("Distribution must be UNIFORM or CLUSTERED")
+)
+
+// SafeTree wraps a Tree and recovers the panics of type Error, the error is
+// stored and returned by LastError. Every method of Tree is wrapped except
+// the accessors GetByKey, PointIntervalCount, Intervals, Len, Built, Dirty
+// and Clear, which don't panic and leave LastError unchanged. The methods of
+// the optional interfaces like Updater are not wrapped, SafeTree doesn't
+// implement them even if the wrapped tree does, call them with Try.
+// The error is shared by all calls, a SafeTree must not be used
+// concurrently, not even for queries, or LastError may report the error of
+// a call of another goroutine.
+// The sequence of QueryView is checked when it is returned, not while it
+// is iterated. Methods that failed return zero values, i.e. nil results,
+// while a query without matches returns an empty slice. All other panics,
+// e.g. nil pointer dereferences or unsupported methods, are passed through.
+type SafeTree struct {
+	Tree
+	err error
+}
+
+// NewSafeTree returns a SafeTree that wraps t
+func NewSafeTree(t Tree) *SafeTree {
+	return &SafeTree{Tree: t}
+}
+
+// LastError returns the error of the last call of a wrapped method or nil
+func (t *SafeTree) LastError() error {
+	return t.err
+}
+
+// Try calls fn and recovers the panics of type Error like the wrapped
+// methods, e.g. to call a method of an optional interface of the wrapped
+// tree. Returns the error, which is returned by LastError too.
+func (t *SafeTree) Try(fn func()) error {
+	t.err = nil
+	func() {
+		defer t.catch()
+		fn()
+	}()
+	return t.err
+}
+
+// catch recovers a panic of type Error, must be deferred
+func (t *SafeTree) catch() {
+	if r := recover(); r != nil {
+		err, ok := r.(Error)
+		if !ok {
+			panic(r)
+		}
+		t.err = err
+	}
+}
+
+// Build segment tree out of interval stack, the error is also returned
+func (t *SafeTree) BuildTree() error {
+	t.err = nil
+	func() {
+		defer t.catch()
+		t.err = t.Tree.BuildTree()
+	}()
+	return t.err
+}
+
+// Query interval
+func (t *SafeTree) Query(from, to int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.Query(from, to)
+}
+
+// Query interval array
+func (t *SafeTree) QueryArray(from, to []int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryArray(from, to)
+}
+
+// Number of intervals overlapping interval
+func (t *SafeTree) Count(from, to int) int {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.Count(from, to)
+}
+
+// Intervals that contain point
+func (t *SafeTree) Stab(point int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.Stab(point)
+}
+
+// Query interval with expected number of results
+func (t *SafeTree) QueryHint(from, to, expected int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryHint(from, to, expected)
+}
+
+// Query interval lazily as sequence
+func (t *SafeTree) QueryView(from, to int) IntervalSeq {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryView(from, to)
+}
+
+// Push new interval to stack
+func (t *SafeTree) Push(from, to int) {
+	t.err = nil
+	defer t.catch()
+	t.Tree.Push(from, to)
+}
+
+// Push array of intervals to stack
+func (t *SafeTree) PushArray(from, to []int) {
+	t.err = nil
+	defer t.catch()
+	t.Tree.PushArray(from, to)
+}
+
+// Push new interval with external key to stack
+func (t *SafeTree) PushKey(from, to int, key string) {
+	t.err = nil
+	defer t.catch()
+	t.Tree.PushKey(from, to, key)
+}
+
+// Push new interval with given Id to stack
+func (t *SafeTree) PushWithId(id, from, to int) {
+	t.err = nil
+	defer t.catch()
+	t.Tree.PushWithId(id, from, to)
+}
+
+// Push intervals to stack with their Ids and keys
+func (t *SafeTree) PushIntervals(intervals []Interval) {
+	t.err = nil
+	defer t.catch()
+	t.Tree.PushIntervals(intervals)
+}
+
+// Transform tree to array
+func (t *SafeTree) Tree2Array() []SegmentOverlap {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.Tree2Array()
+}
+
+// Query interval array, intervals overlapping all intervals
+func (t *SafeTree) QueryArrayAll(from, to []int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryArrayAll(from, to)
+}
+
+// Query interval array, with indices of the queries each interval overlaps
+func (t *SafeTree) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryArrayAnnotated(from, to)
+}
+
+// Call fn for every overlapping interval until it returns false
+func (t *SafeTree) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.err = nil
+	defer t.catch()
+	t.Tree.QueryFunc(from, to, fn)
+}
+
+// Query interval, result sorted by From
+func (t *SafeTree) QueryOrdered(from, to int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryOrdered(from, to)
+}
+
+// Query interval, only intervals with To - From >= minLen
+func (t *SafeTree) QueryMinLength(from, to, minLen int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryMinLength(from, to, minLen)
+}
+
+// Query interval, n intervals with highest Id first
+func (t *SafeTree) QueryRecent(from, to, n int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryRecent(from, to, n)
+}
+
+// Query interval, result sorted by Id
+func (t *SafeTree) QueryInsertionOrder(from, to int) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryInsertionOrder(from, to)
+}
+
+// Query interval, k greatest intervals by less first
+func (t *SafeTree) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.QueryTopK(from, to, k, less)
+}
+
+// Interval containing point preferred by prefer
+func (t *SafeTree) StabBest(point int, prefer Preference) (Interval, bool) {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.StabBest(point, prefer)
+}
+
+// Number of intervals that contain point
+func (t *SafeTree) Depth(point int) int {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.Depth(point)
+}
+
+// Print tree recursively to stdout
+func (t *SafeTree) Print() {
+	t.err = nil
+	defer t.catch()
+	t.Tree.Print()
+}
+
+// Uncovered segments between min and max of all intervals
+func (t *SafeTree) AllGaps() []Segment {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.AllGaps()
+}
+
+// Union of all intervals as sorted, disjoint segments
+func (t *SafeTree) MergedSegments() []Segment {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.MergedSegments()
+}
+
+// Distinct segments of all intervals, sorted
+func (t *SafeTree) Canonical() []Segment {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.Canonical()
+}
+
+// Distinct segments of all intervals with number of intervals, sorted
+func (t *SafeTree) CanonicalCounted() []SegmentCount {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.CanonicalCounted()
+}
+
+// Do any two intervals overlap
+func (t *SafeTree) HasOverlaps() bool {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.HasOverlaps()
+}
+
+// Is every coordinate of range covered by an interval
+func (t *SafeTree) FullyCovered(from, to int) bool {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.FullyCovered(from, to)
+}
+
+// Do the intervals tile range without gaps and overlaps
+func (t *SafeTree) IsPartition(from, to int) bool {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.IsPartition(from, to)
+}
+
+// Set function that decides if a segment matches a query, nil restores default
+func (t *SafeTree) SetOverlapFunc(f OverlapFunc) {
+	t.err = nil
+	defer t.catch()
+	t.Tree.SetOverlapFunc(f)
+}
+
+// Pairs of Ids of overlapping intervals of this and other tree
+func (t *SafeTree) Join(other Tree) [][2]int {
+	t.err = nil
+	defer t.catch()
+	return t.Tree.Join(other)
+}
@@ -4,10 +4,13 @@
 
 package stree
 
+// Number of intervals sampled to estimate the result size of a query
+const SERIAL_SAMPLES = 64
+
 // serial is a structure that allows to query intervals
 // with a sequential algorithm
 type serial struct {
-	stree
+	stack
 }
 
 // NewSerial returns a Tree interface with underlying serial algorithm
@@ -17,7 +20,60 @@ func NewSerial() Tree {
 	return t
 }
 
-func (t *serial) BuildTree() {
+// NewSerialOrdered returns a serial structure that sorts query results
+// with less, see NewTreeOrdered
+func NewSerialOrdered(less func(a, b Interval) bool) Tree {
+	t := new(serial)
+	t.less = less
+	t.Clear()
+	return t
+}
+
+// Built returns always true, the serial data structure is not built and
+// can be queried at any time
+func (t *serial) Built() bool {
+	return true
+}
+
+// Dirty returns always false, queries loop through the current stack
+func (t *serial) Dirty() bool {
+	return false
+}
+
+// Insert pushes a new interval to the stack, queries find it right away
+func (t *serial) Insert(from, to int) {
+	t.Push(from, to)
+}
+
+// Remove deletes the interval with given Id from the stack, returns false
+// if there is no such interval. The last interval of the stack takes the
+// place of the removed one, see stree.Remove.
+func (t *serial) Remove(id int) bool {
+	i := t.position(id)
+	if i < 0 {
+		return false
+	}
+	t.removeAt(i)
+	return true
+}
+
+// RemovedRatio returns always 0, the serial data structure has no nodes
+// that keep removed intervals
+func (t *serial) RemovedRatio() float64 {
+	return 0
+}
+
+// Compact returns nil, the serial data structure has no nodes to compact
+func (t *serial) Compact() error {
+	return nil
+}
+
+// IsSkewed returns always false, the serial data structure has no leaves
+func (t *serial) IsSkewed() bool {
+	return false
+}
+
+func (t *serial) BuildTree() error {
 	panic("BuildTree() not supported for serial data structure")
 }
 
@@ -29,22 +85,275 @@ func (t *serial) Tree2Array() []SegmentOverlap {
 	panic("Tree2Array() not supported for serial data structure")
 }
 
-// Query interval by looping through the interval stack
+// FullyCovered returns true if every coordinate of (from, to) is covered
+// by an interval of the stack
+func (t *serial) FullyCovered(from, to int) bool {
+	return FullyCovered(t.base, from, to)
+}
+
+// Query interval by looping through the interval stack, the result
+// slice is pre-sized to an estimate of the number of results
 func (t *serial) Query(from, to int) []Interval {
+	return t.QueryHint(from, to, t.estimate(from, to))
+}
+
+// Count returns the number of overlapping intervals by looping through
+// the interval stack
+func (t *serial) Count(from, to int) int {
+	count := 0
+	overlaps := t.overlapFunc()
+	for _, intrvl := range t.base {
+		if overlaps(intrvl.Segment, from, to) {
+			count++
+		}
+	}
+	return count
+}
+
+// estimate returns the expected number of results of a query, extrapolated
+// from the overlaps of SERIAL_SAMPLES evenly spaced intervals of the stack
+func (t *serial) estimate(from, to int) int {
+	stride := max(len(t.base)/SERIAL_SAMPLES, 1)
+	overlaps := t.overlapFunc()
+	hits := 0
+	for i := 0; i < len(t.base); i += stride {
+		if overlaps(t.base[i].Segment, from, to) {
+			hits++
+		}
+	}
+	// some slack, so that a slightly higher number of results doesn't regrow the slice
+	return min(hits*stride+hits*stride/8+10, len(t.base))
+}
+
+// Query interval by looping through the interval stack, the result
+// slice is pre-sized to the expected number of results
+func (t *serial) QueryHint(from, to, expected int) []Interval {
+	result := make([]Interval, 0, expected)
+	overlaps := t.overlapFunc()
+	for _, intrvl := range t.base {
+		if overlaps(intrvl.Segment, from, to) {
+			result = append(result, intrvl)
+		}
+	}
+	return t.sorted(result)
+}
+
+// QueryOrdered returns the overlapping intervals sorted by From
+func (t *serial) QueryOrdered(from, to int) []Interval {
+	result := t.Query(from, to)
+	SortByFrom(result)
+	return result
+}
+
+// QueryView returns a sequence that yields overlapping intervals
+// while looping through the interval stack
+func (t *serial) QueryView(from, to int) IntervalSeq {
+	overlaps := t.overlapFunc()
+	return func(yield func(Interval) bool) {
+		for _, intrvl := range t.base {
+			if overlaps(intrvl.Segment, from, to) && !yield(intrvl) {
+				return
+			}
+		}
+	}
+}
+
+// QueryFunc calls fn for every overlapping interval while looping through
+// the interval stack and stops when fn returns false
+func (t *serial) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.QueryView(from, to)(fn)
+}
+
+// Depth returns the number of intervals that contain point by looping
+// through the interval stack
+func (t *serial) Depth(point int) int {
+	depth := 0
+	for _, intrvl := range t.base {
+		if intrvl.From <= point && intrvl.To >= point {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Stab returns the intervals that contain point by looping through the
+// interval stack
+func (t *serial) Stab(point int) []Interval {
 	result := make([]Interval, 0, 10)
 	for _, intrvl := range t.base {
-		if !intrvl.Segment.Disjoint(from, to) {
+		if intrvl.From <= point && intrvl.To >= point {
 			result = append(result, intrvl)
 		}
 	}
 	return result
 }
 
-// Query interval array by looping through the interval stack
-func (t *serial) QueryArray(from, to []int) []Interval {
+// Nearest returns the interval nearest to point by looping through the
+// interval stack, see stree.Nearest
+func (t *serial) Nearest(point int) (Interval, int, bool) {
+	var best Interval
+	distance, found := 0, false
+	for _, intrvl := range t.base {
+		d := intrvl.Distance(point)
+		if !found || d < distance || d == distance && intrvl.Id < best.Id {
+			best, distance, found = intrvl, d, true
+		}
+	}
+	return best, distance, found
+}
+
+// Enclosing returns the intervals that contain (from, to) by looping
+// through the interval stack
+func (t *serial) Enclosing(from, to int) []Interval {
 	result := make([]Interval, 0, 10)
-	for i, fromvalue := range from {
-		result = append(result, t.Query(fromvalue, to[i])...)
+	if from > to {
+		return result
+	}
+	for _, intrvl := range t.base {
+		if intrvl.From <= from && intrvl.To >= to {
+			result = append(result, intrvl)
+		}
+	}
+	return result
+}
+
+// QueryStartsIn returns the intervals that start in the range (from, to)
+// in ascending order of From by looping through the interval stack
+func (t *serial) QueryStartsIn(from, to int) []Interval {
+	result := make([]Interval, 0, 10)
+	for _, intrvl := range t.base {
+		if intrvl.From >= from && intrvl.From <= to {
+			result = append(result, intrvl)
+		}
 	}
+	SortByFrom(result)
 	return result
 }
+
+// QueryEndsIn returns the intervals that end in the range (from, to)
+// in ascending order of To by looping through the interval stack
+func (t *serial) QueryEndsIn(from, to int) []Interval {
+	result := make([]Interval, 0, 10)
+	for _, intrvl := range t.base {
+		if intrvl.To >= from && intrvl.To <= to {
+			result = append(result, intrvl)
+		}
+	}
+	SortByTo(result)
+	return result
+}
+
+// FlowCounts returns the number of intervals that start in the range (from,
+// to) and the number that end in it by looping through the interval stack
+func (t *serial) FlowCounts(from, to int) (starts, ends int) {
+	for _, intrvl := range t.base {
+		if intrvl.From >= from && intrvl.From <= to {
+			starts++
+		}
+		if intrvl.To >= from && intrvl.To <= to {
+			ends++
+		}
+	}
+	return
+}
+
+// QueryMinLength returns the overlapping intervals with To - From >= minLen
+// by looping through the interval stack
+func (t *serial) QueryMinLength(from, to, minLen int) []Interval {
+	return minLength(t.QueryView(from, to), minLen)
+}
+
+// QueryRecent returns the n overlapping intervals with the highest Ids
+func (t *serial) QueryRecent(from, to, n int) []Interval {
+	return mostRecent(t.Query(from, to), n)
+}
+
+// StabBest returns the interval containing point that is preferred by prefer
+func (t *serial) StabBest(point int, prefer Preference) (Interval, bool) {
+	return Best(t.QueryView(point, point), prefer)
+}
+
+// QueryTopK returns the k overlapping intervals that are greatest by less
+func (t *serial) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	return greatest(t.Query(from, to), k, less)
+}
+
+// QueryArrayAnnotated returns the intervals that overlap any interval of the
+// array with the indices of the queries they overlap
+func (t *serial) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	groups := make([][]Interval, len(from))
+	for i, fromvalue := range from {
+		groups[i] = t.Query(fromvalue, to[i])
+	}
+	return Annotate(groups)
+}
+
+// QueryArrayAll returns the intervals that overlap every interval of the array
+func (t *serial) QueryArrayAll(from, to []int) []Interval {
+	return QueryAll(t.Query, from, to)
+}
+
+// QueryInsertionOrder returns the overlapping intervals sorted by Id
+func (t *serial) QueryInsertionOrder(from, to int) []Interval {
+	return insertionOrder(t.Query(from, to))
+}
+
+// QueryWithGaps returns the overlapping intervals and uncovered segments clipped to the query
+func (t *serial) QueryWithGaps(from, to int) ([]Interval, []Segment) {
+	return ClipWithGaps(t.Query(from, to), from, to)
+}
+
+// RenderView returns the overlapping intervals clipped to the viewport
+// (from, to), sorted by From and mapped to pixels of a row of width, see Render
+func (t *serial) RenderView(from, to, width int) []RenderedInterval {
+	if width <= 0 || from > to {
+		panic(ErrInvalidViewport)
+	}
+	return Render(t.Query(from, to), from, to, width)
+}
+
+// QueryRelative returns the overlapping intervals clipped to the query and
+// shifted so that from becomes 0, see Relative
+func (t *serial) QueryRelative(from, to int) []Segment {
+	return Relative(t.Query(from, to), from, to)
+}
+
+// Query interval and assign result to non-overlapping layers
+func (t *serial) QueryLayered(from, to int) [][]Interval {
+	return Layers(t.Query(from, to))
+}
+
+// LayerCount returns the number of layers of QueryLayered without building
+// them. The intervals found all overlap the query, so two of them overlap
+// inside the query if they overlap at all and clipping them to the query
+// doesn't change the count.
+func (t *serial) LayerCount(from, to int) int {
+	return LayerCount(t.Query(from, to))
+}
+
+// Query interval array by looping through the interval stack, intervals
+// that overlap any of the intervals of the array are returned once
+func (t *serial) QueryArray(from, to []int) []Interval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	expected := 0
+	for i, fromvalue := range from {
+		expected += t.estimate(fromvalue, to[i])
+	}
+	result := make([]Interval, 0, min(expected, len(t.base)))
+	overlaps := t.overlapFunc()
+	// each interval is appended once, even if it overlaps several queries
+	for _, intrvl := range t.base {
+		for i, fromvalue := range from {
+			if overlaps(intrvl.Segment, fromvalue, to[i]) {
+				result = append(result, intrvl)
+				break
+			}
+		}
+	}
+	return t.sorted(result)
+}
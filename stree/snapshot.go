@@ -0,0 +1,241 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"slices"
+)
+
+// OverlapState is a snapshot of the interval stack of a tree and the
+// assignment of the intervals to the nodes. The structure of a tree depends
+// only on the endpoints, so a snapshot can be restored into any tree built
+// from the same endpoints, e.g. to switch between interval sets without
+// inserting the intervals again. All fields are exported, so a snapshot can
+// be stored as JSON or gob and loaded with NewTreeFromState.
+type OverlapState struct {
+	// Segments of the nodes in preorder, the structure of the tree
+	Segments []Segment
+	// Ids of the intervals stored at each node, in preorder
+	Overlap [][]int
+	// Interval stack
+	Base []Interval
+}
+
+// Snapshot captures the intervals stored at the nodes of tree and the interval stack
+func Snapshot(root Node, base []Interval) OverlapState {
+	state := OverlapState{Base: slices.Clone(base)}
+	traverse(root, func(node Node) {
+		state.Segments = append(state.Segments, node.Segment())
+		var ids []int
+		for _, intrvl := range node.Overlap() {
+			ids = append(ids, intrvl.Id)
+		}
+		state.Overlap = append(state.Overlap, ids)
+	}, nil)
+	return state
+}
+
+// Valid returns true if Overlap has an Id list for every segment and every
+// Id of Overlap is in Base
+func (s OverlapState) Valid() bool {
+	if len(s.Overlap) != len(s.Segments) {
+		return false
+	}
+	ids := make(map[int]bool, len(s.Base))
+	for _, intrvl := range s.Base {
+		ids[intrvl.Id] = true
+	}
+	for _, overlap := range s.Overlap {
+		for _, id := range overlap {
+			if !ids[id] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Matches returns true if the snapshot is valid and the nodes of tree have
+// the segments of the snapshot
+func (s OverlapState) Matches(root Node) bool {
+	if !s.Valid() {
+		return false
+	}
+	i := 0
+	match := true
+	traverse(root, func(node Node) {
+		if i >= len(s.Segments) || node.Segment() != s.Segments[i] {
+			match = false
+		}
+		i++
+	}, nil)
+	return match && i == len(s.Segments)
+}
+
+// Positions maps the Ids of Overlap to positions in Base, an Id pushed
+// several times maps to the first interval with that Id
+func (s OverlapState) Positions() [][]int {
+	position := make(map[int]int, len(s.Base))
+	for i := len(s.Base) - 1; i >= 0; i-- {
+		position[s.Base[i].Id] = i
+	}
+	positions := make([][]int, len(s.Overlap))
+	for i, ids := range s.Overlap {
+		if ids == nil {
+			continue
+		}
+		positions[i] = make([]int, len(ids))
+		for j, id := range ids {
+			positions[i][j] = position[id]
+		}
+	}
+	return positions
+}
+
+// SnapshotOverlaps captures the interval stack and the intervals stored at each node
+func (t *stree) SnapshotOverlaps() OverlapState {
+	t.checkBuilt()
+	return Snapshot(t.root, t.base)
+}
+
+// RestoreOverlaps replaces the interval stack and the intervals stored at
+// the nodes with the snapshot, without building the tree. Panics with
+// ErrStateMismatch if the tree wasn't built from the endpoints of the
+// snapshot or the snapshot is not Valid, before anything is changed. Keys,
+// Ids and the endpoint index are taken from the restored stack.
+func (t *stree) RestoreOverlaps(state OverlapState) {
+	if t.root == nil {
+		panic(ErrEmptyTree)
+	}
+	if !state.Matches(t.root) {
+		panic(ErrStateMismatch)
+	}
+	t.restoreBase(state.Base)
+	t.dirty, t.removed = false, 0
+	positions := state.Positions()
+	i := 0
+	traverse(t.root, func(n Node) {
+		node := n.(*node)
+		node.overlap = nil
+		for _, pos := range positions[i] {
+			node.overlap = append(node.overlap, &t.base[pos])
+		}
+		i++
+	}, nil)
+}
+
+// NewTreeFromState returns a segment tree with the nodes, intervals and
+// stack of a snapshot without building it, e.g. to load a tree stored as
+// JSON. The structure of the tree is recovered from the preorder of the
+// segments: a node has children if the next segment is its right child,
+// which ends at To of the node and starts after its From. Returns
+// ErrStateMismatch if the segments don't form a tree or an Id of Overlap
+// is not in Base.
+func NewTreeFromState(state OverlapState) (SegmentTree, error) {
+	if len(state.Segments) == 0 || !state.Valid() {
+		return nil, ErrStateMismatch
+	}
+	i := 0
+	root, ok := restoreNodes(state.Segments, &i)
+	if !ok || i != len(state.Segments) {
+		return nil, ErrStateMismatch
+	}
+	t := new(stree)
+	t.Clear()
+	t.root = root
+	t.min, t.max = root.segment.From, root.segment.To
+	t.RestoreOverlaps(state)
+	return t, nil
+}
+
+// restoreNodes creates the subtree of the node at position i of segments
+// in preorder and advances i past it, returns false if the segments of
+// the children don't partition the segment of the node
+func restoreNodes(segments []Segment, i *int) (*node, bool) {
+	n := &node{segment: segments[*i]}
+	*i++
+	if *i == len(segments) {
+		return n, true
+	}
+	if next := segments[*i]; next.To != n.segment.To || next.From <= n.segment.From {
+		// a leaf, the next segment belongs to an ancestor
+		return n, true
+	}
+	var ok bool
+	if n.right, ok = restoreNodes(segments, i); !ok || *i == len(segments) {
+		return nil, false
+	}
+	if n.left, ok = restoreNodes(segments, i); !ok {
+		return nil, false
+	}
+	if n.left.segment.From != n.segment.From || n.left.segment.To != n.right.segment.From-1 {
+		return nil, false
+	}
+	return n, true
+}
+
+// gobStack is the gob encoding of a tree: the interval stack and the Id of
+// the next interval pushed without Id. Other trees use the same field names,
+// so a stack encoded by one tree can be decoded by another.
+type gobStack struct {
+	Base  []Interval
+	Count int
+}
+
+// GobEncode encodes the interval stack, the nodes are not encoded. A decoded
+// tree is built with BuildTree.
+func (t *stack) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(gobStack{Base: t.base, Count: t.count})
+	return buf.Bytes(), err
+}
+
+// GobDecode replaces the interval stack with an encoded one
+func (t *stack) GobDecode(data []byte) error {
+	var stack gobStack
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&stack); err != nil {
+		return err
+	}
+	t.restoreBase(stack.Base)
+	t.count = max(t.count, stack.Count)
+	return nil
+}
+
+// GobDecode replaces the interval stack with an encoded one and clears the
+// tree, which has to be built again
+func (t *stree) GobDecode(data []byte) error {
+	if err := t.stack.GobDecode(data); err != nil {
+		return err
+	}
+	t.root = nil
+	t.min, t.max = 0, 0
+	t.dirty, t.removed = false, 0
+	return nil
+}
+
+// restoreBase replaces the interval stack and the state derived from it
+func (t *stack) restoreBase(base []Interval) {
+	t.base = slices.Clone(base)
+	t.keys = make(map[string]int)
+	t.count, t.points, t.sparse, t.negative = 0, 0, false, false
+	for i, intrvl := range t.base {
+		if intrvl.Key != "" {
+			t.keys[intrvl.Key] = i
+		}
+		if intrvl.Id != i {
+			t.sparse = true
+		}
+		if intrvl.Id < 0 {
+			t.negative = true
+		}
+		t.count = max(t.count, intrvl.Id+1)
+		if intrvl.From == intrvl.To {
+			t.points++
+		}
+	}
+	t.index = NewEndpointIndex(t.base)
+}
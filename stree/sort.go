@@ -0,0 +1,80 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"sort"
+)
+
+// ByFrom sorts intervals by From, ties are ordered by To and Id
+type ByFrom []Interval
+
+func (s ByFrom) Len() int      { return len(s) }
+func (s ByFrom) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s ByFrom) Less(i, j int) bool {
+	if s[i].From != s[j].From {
+		return s[i].From < s[j].From
+	}
+	if s[i].To != s[j].To {
+		return s[i].To < s[j].To
+	}
+	return s[i].Id < s[j].Id
+}
+
+// ByTo sorts intervals by To, ties are ordered by From and Id
+type ByTo []Interval
+
+func (s ByTo) Len() int      { return len(s) }
+func (s ByTo) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s ByTo) Less(i, j int) bool {
+	if s[i].To != s[j].To {
+		return s[i].To < s[j].To
+	}
+	if s[i].From != s[j].From {
+		return s[i].From < s[j].From
+	}
+	return s[i].Id < s[j].Id
+}
+
+// ByLength sorts intervals by length (To - From), ties are ordered by From and Id.
+// Lengths are compared as uint64, which holds To - From of any interval with
+// From <= To, e.g. {math.MinInt, math.MaxInt}, without overflow.
+type ByLength []Interval
+
+func (s ByLength) Len() int      { return len(s) }
+func (s ByLength) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s ByLength) Less(i, j int) bool {
+	li := uint64(int64(s[i].To)) - uint64(int64(s[i].From))
+	lj := uint64(int64(s[j].To)) - uint64(int64(s[j].From))
+	if li != lj {
+		return li < lj
+	}
+	if s[i].From != s[j].From {
+		return s[i].From < s[j].From
+	}
+	return s[i].Id < s[j].Id
+}
+
+// ById sorts intervals by Id
+type ById []Interval
+
+func (s ById) Len() int           { return len(s) }
+func (s ById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s ById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+
+// SortByFrom sorts intervals in ascending order of From
+func SortByFrom(intervals []Interval) {
+	sort.Sort(ByFrom(intervals))
+}
+
+// SortByTo sorts intervals in ascending order of To
+func SortByTo(intervals []Interval) {
+	sort.Sort(ByTo(intervals))
+}
+
+// SortByLength sorts intervals in ascending order of their length
+func SortByLength(intervals []Interval) {
+	sort.Sort(ByLength(intervals))
+}
@@ -7,40 +7,220 @@ package stree
 
 import (
 	"fmt"
+	"io"
+	"iter"
+	"os"
 	"reflect"
+	"runtime"
+	"slices"
 	"sort"
+	"sync"
 )
 
-// Main interface to access tree
+// Main interface to access tree, implemented by all trees. Methods that
+// only some trees support are in the optional interfaces Updater,
+// LinearQueries, ClippedQueries and SegmentTree, check for them with a type
+// assertion.
 type Tree interface {
 	// Push new interval to stack
 	Push(from, to int)
 	// Push array of intervals to stack
 	PushArray(from, to []int)
+	// Push new interval with external key to stack
+	PushKey(from, to int, key string)
+	// Push new interval with given Id to stack
+	PushWithId(id, from, to int)
+	// Push intervals to stack with their Ids and keys
+	PushIntervals(intervals []Interval)
+	// Get interval by external key
+	GetByKey(key string) (Interval, bool)
+	// Number of pushed intervals with From == To
+	PointIntervalCount() int
+	// Copy of the pushed intervals
+	Intervals() []Interval
+	// Number of pushed intervals
+	Len() int
+	// Can the tree be queried, true after BuildTree
+	Built() bool
+	// Intervals were pushed since the tree was built, see ErrDirtyTree
+	Dirty() bool
 	// Clear the interval stack
 	Clear()
-	// Build segment tree out of interval stack
-	BuildTree()
+	// Build segment tree out of interval stack, ErrNoIntervals if stack is empty
+	BuildTree() error
 	// Print tree recursively to stdout
 	Print()
 	// Transform tree to array
 	Tree2Array() []SegmentOverlap
 	// Query interval
 	Query(from, to int) []Interval
-	// Query interval array
+	// Query interval array, intervals overlapping any interval (union)
 	QueryArray(from, to []int) []Interval
+	// Query interval array, intervals overlapping all intervals
+	QueryArrayAll(from, to []int) []Interval
+	// Query interval array, with indices of the queries each interval overlaps
+	QueryArrayAnnotated(from, to []int) []AnnotatedInterval
+	// Number of intervals overlapping interval, no result is collected
+	Count(from, to int) int
+	// Query interval with expected number of results
+	QueryHint(from, to, expected int) []Interval
+	// Query interval lazily as sequence
+	QueryView(from, to int) IntervalSeq
+	// Call fn for every overlapping interval until it returns false
+	QueryFunc(from, to int, fn func(Interval) bool)
+	// Query interval, result sorted by From
+	QueryOrdered(from, to int) []Interval
+	// Query interval, only intervals with To - From >= minLen
+	QueryMinLength(from, to, minLen int) []Interval
+	// Query interval, n intervals with highest Id first
+	QueryRecent(from, to, n int) []Interval
+	// Query interval, result sorted by Id
+	QueryInsertionOrder(from, to int) []Interval
+	// Query interval, k greatest intervals by less first
+	QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval
+	// Interval containing point preferred by prefer
+	StabBest(point int, prefer Preference) (Interval, bool)
+	// Number of intervals that contain point
+	Depth(point int) int
+	// Intervals that contain point
+	Stab(point int) []Interval
+	// Uncovered segments between min and max of all intervals
+	AllGaps() []Segment
+	// Union of all intervals as sorted, disjoint segments
+	MergedSegments() []Segment
+	// Distinct segments of all intervals, sorted
+	Canonical() []Segment
+	// Distinct segments of all intervals with number of intervals, sorted
+	CanonicalCounted() []SegmentCount
+	// Do any two intervals overlap
+	HasOverlaps() bool
+	// Is every coordinate of range covered by an interval
+	FullyCovered(from, to int) bool
+	// Do the intervals tile range without gaps and overlaps
+	IsPartition(from, to int) bool
+	// Set function that decides if a segment matches a query, nil restores default
+	SetOverlapFunc(f OverlapFunc)
+	// Pairs of Ids of overlapping intervals of this and other tree
+	Join(other Tree) [][2]int
 }
 
-type stree struct {
-	// Number of intervals
+// Updater is implemented by trees that update a built tree in place, all
+// trees but the circular tree
+type Updater interface {
+	// Push interval and insert it into the built tree
+	Insert(from, to int)
+	// Remove interval by Id from stack and built tree
+	Remove(id int) bool
+	// Fraction of intervals removed since the tree was built
+	RemovedRatio() float64
+	// Rebuild tree from the intervals not removed
+	Compact() error
+	// Would a rebuild at least halve the leaves of the tree
+	IsSkewed() bool
+}
+
+// LinearQueries is implemented by all trees but the circular tree, whose
+// intervals wrap around the end of the period
+type LinearQueries interface {
+	// Nearest interval to point, its distance and whether one was found
+	Nearest(point int) (Interval, int, bool)
+	// Intervals that contain the interval (from, to)
+	Enclosing(from, to int) []Interval
+	// Query interval and assign result to non-overlapping layers
+	QueryLayered(from, to int) [][]Interval
+	// Number of layers of QueryLayered
+	LayerCount(from, to int) int
+	// Number of other intervals each interval overlaps, by Id
+	OverlapDegrees() map[int]int
+	// Ids of maximal groups of mutually overlapping intervals
+	MaximalCliques() [][]int
+	// Intervals with From in range, ordered by From
+	QueryStartsIn(from, to int) []Interval
+	// Intervals with To in range, ordered by To
+	QueryEndsIn(from, to int) []Interval
+	// Number of intervals that start and that end in range
+	FlowCounts(from, to int) (starts, ends int)
+}
+
+// ClippedQueries is implemented by all trees but the circular tree, results
+// are clipped to the query
+type ClippedQueries interface {
+	// Query interval, result and uncovered segments clipped to query
+	QueryWithGaps(from, to int) ([]Interval, []Segment)
+	// Query interval, result clipped and mapped to pixels of a row of width
+	RenderView(from, to, width int) []RenderedInterval
+	// Query interval, result clipped and relative to from
+	QueryRelative(from, to int) []Segment
+}
+
+// SegmentTree is implemented by the segment trees of NewTree and the other
+// constructors that return it, it adds the methods that depend on the
+// nodes of a segment tree to the optional interfaces
+type SegmentTree interface {
+	Tree
+	Updater
+	LinearQueries
+	ClippedQueries
+	// Build segment tree with precomputed endpoints
+	BuildTreeWithEndpoints(endpoint []int, min, max int)
+	// Root node of tree, nil before tree is built
+	Root() Node
+	// Transform tree to sequence, nodes are visited lazily
+	Tree2Seq() iter.Seq[SegmentOverlap]
+	// Pass every node of tree to visitors in a single traversal
+	Aggregate(visitors ...NodeVisitor)
+	// Maximal nodes whose segments partition the query interval
+	CanonicalNodes(from, to int) []Node
+	// Query interval and group result by the nodes the intervals were found at
+	QueryDetailed(from, to int) []SegmentOverlap
+	// Release spare capacity of overlapping intervals in all nodes
+	ShrinkToFit()
+	// Copy of the built tree without intervals
+	CloneEmpty() SegmentTree
+	// Snapshot interval stack and intervals of nodes
+	SnapshotOverlaps() OverlapState
+	// Restore snapshot into tree built from the same endpoints
+	RestoreOverlaps(state OverlapState)
+}
+
+// stack holds the pushed intervals and implements the methods that only
+// read them, shared by the segment tree and the serial structure
+type stack struct {
+	// Id of next interval pushed without Id
 	count int
-	root  *node
 	// Interval stack
 	base []Interval
+	// Index of intervals in stack by external key
+	keys map[string]int
+	// Custom overlap function, nil for default closed interval overlap
+	overlaps OverlapFunc
+	// Intervals sorted by endpoints, built with tree
+	index EndpointIndex
+	// Ids differ from positions in stack, see PushWithId
+	sparse bool
+	// An Id given by PushWithId is negative, Ids don't fit a bitset
+	negative bool
+	// Number of pushed intervals with From == To
+	points int
+	// Order of query results, nil for undefined order
+	less func(a, b Interval) bool
+}
+
+type stree struct {
+	stack
+	root *node
 	// Min value of all intervals
 	min int
 	// Max value of all intervals
 	max int
+	// Intervals were pushed since the tree was built, queries panic until
+	// the tree is built again
+	dirty bool
+	// Number of intervals removed since the tree was built, see RemovedRatio
+	removed int
+	// RemovedRatio at which Remove compacts the tree, 0 disables it, see
+	// NewTreeAutoCompact
+	autoCompact float64
 }
 
 // Interface to provide unified access to nodes
@@ -86,6 +266,8 @@ func (n *node) Overlap() []Interval {
 type Interval struct {
 	Id int // unique
 	Segment
+	// Optional external identifier, see PushKey
+	Key string
 }
 
 type Segment struct {
@@ -93,15 +275,28 @@ type Segment struct {
 	To   int
 }
 
-// Represents overlapping intervals of a segment
+// Sequence of intervals, consumed with range-over-func
+type IntervalSeq = iter.Seq[Interval]
+
+// Represents overlapping intervals of a segment. Interval is nil if the
+// node holds no intervals, never an empty slice, gob decodes empty slices
+// as nil and nil round-trips through both JSON (null) and gob unchanged.
+// Query results are the opposite: no matches are an empty, non-nil slice
+// that marshals to [] in JSON.
 type SegmentOverlap struct {
 	Segment  Segment
 	Interval []Interval
 }
 
+// OverlapFunc decides if a segment matches the query interval (from, to)
+type OverlapFunc func(seg Segment, from, to int) bool
+
 // Node receiver for tree traversal
 type NodeReceive func(Node)
 
+// Min number of endpoints sorted in parallel by Dedup
+const PARALLEL_SORT = 1 << 20
+
 const (
 	// Relations of two intervals
 	SUBSET = iota
@@ -109,74 +304,571 @@ const (
 	INTERSECT_OR_SUPERSET
 )
 
-// NewTree returns a Tree interface with underlying segment tree implementation
-func NewTree() Tree {
+// NewTree returns a SegmentTree interface with underlying segment tree implementation
+func NewTree() SegmentTree {
+	t := new(stree)
+	t.Clear()
+	return t
+}
+
+// NewTreeOrdered returns a segment tree that sorts the results of Query,
+// QueryHint and QueryArray with less, which makes their order deterministic.
+// A nil less keeps the order of the results undefined like NewTree.
+func NewTreeOrdered(less func(a, b Interval) bool) SegmentTree {
 	t := new(stree)
+	t.less = less
 	t.Clear()
 	return t
 }
 
+// sorted sorts result with the comparator of the tree, if any
+func (t *stack) sorted(result []Interval) []Interval {
+	if t.less != nil {
+		sort.SliceStable(result, func(i, j int) bool { return t.less(result[i], result[j]) })
+	}
+	return result
+}
+
 // Push new interval to stack
-func (t *stree) Push(from, to int) {
-	t.base = append(t.base, Interval{t.count, Segment{from, to}})
+func (t *stack) Push(from, to int) {
+	if t.count != len(t.base) {
+		t.sparse = true
+	}
+	t.base = append(t.base, Interval{Id: t.count, Segment: Segment{from, to}})
 	t.count++
+	if from == to {
+		t.points++
+	}
+}
+
+// Push new interval with external key to stack, the key is returned with
+// the interval in query results. Keys should be unique, GetByKey returns
+// the interval pushed last with a key.
+func (t *stack) PushKey(from, to int, key string) {
+	t.Push(from, to)
+	t.base[len(t.base)-1].Key = key
+	t.keys[key] = len(t.base) - 1
+}
+
+// Push new interval with given Id to stack, e.g. to keep Ids stable when
+// the tree is cleared and reloaded. Ids need to be unique, intervals with
+// the same Id are merged in query results. Intervals pushed afterwards
+// without Id continue after the highest Id.
+func (t *stack) PushWithId(id, from, to int) {
+	if id != len(t.base) {
+		t.sparse = true
+	}
+	if id < 0 {
+		t.negative = true
+	}
+	t.base = append(t.base, Interval{Id: id, Segment: Segment{from, to}})
+	if id >= t.count {
+		t.count = id + 1
+	}
+	if from == to {
+		t.points++
+	}
+}
+
+// PushIntervals pushes intervals with their Ids and keys, e.g. to carry
+// stable identifiers of an external database or to push the result of
+// Intervals to another tree. Ids need to be unique as for PushWithId,
+// queries merge intervals with the same Id. Intervals pushed afterwards
+// without Id continue after the highest Id.
+func (t *stack) PushIntervals(intervals []Interval) {
+	for _, intrvl := range intervals {
+		t.PushWithId(intrvl.Id, intrvl.From, intrvl.To)
+		if intrvl.Key != "" {
+			t.base[len(t.base)-1].Key = intrvl.Key
+			t.keys[intrvl.Key] = len(t.base) - 1
+		}
+	}
+}
+
+// PointIntervalCount returns the number of pushed intervals with From == To.
+// Point intervals are stored at the single leaf containing the point.
+func (t *stack) PointIntervalCount() int {
+	return t.points
+}
+
+// Get interval by external key
+func (t *stack) GetByKey(key string) (Interval, bool) {
+	if i, ok := t.keys[key]; ok {
+		return t.base[i], true
+	}
+	return Interval{}, false
+}
+
+// Intervals returns a copy of the interval stack in the order of the
+// stack, e.g. to rebuild a tree with modified intervals
+func (t *stack) Intervals() []Interval {
+	return slices.Clone(t.base)
+}
+
+// Len returns the number of intervals in the stack. This is not the Id of
+// the next interval if Ids were given by PushWithId or intervals removed.
+func (t *stack) Len() int {
+	return len(t.base)
+}
+
+// Built returns true if the tree is built. Intervals pushed since are not
+// in the tree until it is built again, see Dirty.
+func (t *stree) Built() bool {
+	return t.root != nil
+}
+
+// Dirty returns true if intervals were pushed since the tree was built.
+// Queries of a dirty tree panic with ErrDirtyTree instead of silently
+// leaving out these intervals, BuildTree rebuilds the tree from the stack
+// without Clear. Insert and Remove keep the tree up to date.
+func (t *stree) Dirty() bool {
+	return t.dirty
+}
+
+// checkBuilt panics with ErrEmptyTree if the tree is not built and with
+// ErrDirtyTree if intervals were pushed since it was built
+func (t *stree) checkBuilt() {
+	if t.root == nil {
+		panic(ErrEmptyTree)
+	}
+	if t.dirty {
+		panic(ErrDirtyTree)
+	}
 }
 
 // Push array of intervals to stack
-func (t *stree) PushArray(from, to []int) {
+func (t *stack) PushArray(from, to []int) {
 	for i := 0; i < len(from); i++ {
 		t.Push(from[i], to[i])
 	}
 }
 
 // Clear the interval stack
-func (t *stree) Clear() {
+func (t *stack) Clear() {
 	t.count = 0
+	// reuse capacity of previous stack, a new tree allocates on first push
+	t.base = t.base[:0]
+	t.keys = make(map[string]int)
+	t.index = EndpointIndex{}
+	t.sparse = false
+	t.negative = false
+	t.points = 0
+}
+
+// Clear the interval stack and the tree
+func (t *stree) Clear() {
+	t.stack.Clear()
 	t.root = nil
-	t.base = make([]Interval, 0, 100)
 	t.min = 0
 	t.max = 0
+	t.dirty = false
+	t.removed = 0
+}
+
+// Push new interval to stack, a built tree becomes dirty
+func (t *stree) Push(from, to int) {
+	t.stack.Push(from, to)
+	t.pushed()
+}
+
+// Push array of intervals to stack
+func (t *stree) PushArray(from, to []int) {
+	t.stack.PushArray(from, to)
+	if len(from) > 0 {
+		t.pushed()
+	}
 }
 
-// Build segment tree out of interval stack
-func (t *stree) BuildTree() {
+// Push new interval with external key to stack
+func (t *stree) PushKey(from, to int, key string) {
+	t.stack.PushKey(from, to, key)
+	t.pushed()
+}
+
+// Push new interval with given Id to stack
+func (t *stree) PushWithId(id, from, to int) {
+	t.stack.PushWithId(id, from, to)
+	t.pushed()
+}
+
+// Push intervals with their Ids and keys to stack
+func (t *stree) PushIntervals(intervals []Interval) {
+	t.stack.PushIntervals(intervals)
+	if len(intervals) > 0 {
+		t.pushed()
+	}
+}
+
+// pushed marks a built tree dirty, the pushed intervals are not in its nodes
+func (t *stree) pushed() {
+	if t.root != nil {
+		t.dirty = true
+	}
+}
+
+// Build segment tree out of interval stack, returns ErrNoIntervals and
+// leaves the tree unchanged if the stack is empty
+func (t *stree) BuildTree() error {
 	if len(t.base) == 0 {
-		panic("No intervals in stack to build tree. Push intervals first")
+		return ErrNoIntervals
+	}
+	t.dirty = false
+	t.removed = 0
+	t.index = NewEndpointIndex(t.base)
+	if len(t.base) == 1 {
+		// a single interval covers the whole tree, the root is the only node
+		t.root = &node{segment: t.base[0].Segment, overlap: []*Interval{&t.base[0]}}
+		t.min, t.max = t.base[0].From, t.base[0].To
+		return nil
 	}
-	var endpoint []int
-	endpoint, t.min, t.max = Endpoints(t.base)
-	// Create tree nodes from interval endpoints
-	t.root = t.insertNodes(endpoint)
+	endpoint, min, max := Endpoints(t.base)
+	t.build(endpoint, min, max)
+	return nil
+}
+
+// BuildTreeWithEndpoints builds the segment tree like BuildTree, but uses
+// given endpoints instead of computing them from the interval stack, e.g.
+// to build several trees of subsets of intervals over the same coordinates.
+// endpoint must be sorted, unique and contain From and To of every interval,
+// min and max are its first and last value. This is validated in O(n log m)
+// which is still cheaper than sorting the endpoints.
+func (t *stree) BuildTreeWithEndpoints(endpoint []int, min, max int) {
+	if len(t.base) == 0 {
+		panic(ErrNoIntervals)
+	}
+	if !ValidEndpoints(t.base, endpoint, min, max) {
+		panic(ErrInvalidEndpoints)
+	}
+	t.dirty = false
+	t.removed = 0
+	t.index = NewEndpointIndex(t.base)
+	t.build(endpoint, min, max)
+}
+
+// build creates tree nodes from elementary intervals between endpoints
+// and inserts the intervals of the stack
+func (t *stree) build(endpoint []int, min, max int) {
+	t.min, t.max = min, max
+	t.root = t.insertNodes(ElementaryIntervals(endpoint))
 	for i := range t.base {
 		insertInterval(t.root, &t.base[i])
 	}
 }
 
 func (t *stree) Print() {
-	Print(t.root)
+	Fprint(os.Stdout, t.root)
+}
+
+// Root returns the root node to use with functions like Print or
+// CanonicalNodes, nil if the tree is not built
+func (t *stree) Root() Node {
+	if t.root == nil {
+		// a nil *node would be a non-nil Node
+		return nil
+	}
+	return t.root
 }
 
 func (t *stree) Tree2Array() []SegmentOverlap {
 	return Tree2Array(t.root)
 }
 
+// Tree2Seq transforms tree to sequence of SegmentOverlap
+func (t *stree) Tree2Seq() iter.Seq[SegmentOverlap] {
+	return Tree2Seq(t.root)
+}
+
+func (t *stree) Aggregate(visitors ...NodeVisitor) {
+	Aggregate(t.root, visitors...)
+}
+
+// ShrinkToFit reallocates the overlapping intervals of every node to their
+// exact length. Slices grow by append while the tree is built and keep spare
+// capacity afterwards. Shrinking costs one allocation and copy per node, in
+// return a long-lived tree holds no unused capacity. Call it once after
+// BuildTree, the tree is not modified after that anyway.
+func (t *stree) ShrinkToFit() {
+	if t.root != nil {
+		shrink(t.root)
+	}
+}
+
+// OverlapDegrees maps the Id of each interval of the stack to the number
+// of other intervals it overlaps, see OverlapDegrees
+func (t *stack) OverlapDegrees() map[int]int {
+	return OverlapDegrees(t.base)
+}
+
+// MaximalCliques returns the Ids of the maximal groups of mutually
+// overlapping intervals of the stack, see MaximalCliques
+func (t *stack) MaximalCliques() [][]int {
+	return MaximalCliques(t.base)
+}
+
+// AllGaps returns the uncovered segments between min and max of all
+// intervals in the stack, the tree doesn't have to be built
+func (t *stack) AllGaps() []Segment {
+	return AllGaps(t.base)
+}
+
+// QueryInsertionOrder returns the overlapping intervals sorted by Id, which
+// is the order of the stack for Push. With PushWithId the Ids are up to the
+// caller and don't have to reflect the order the intervals were pushed.
+func (t *stree) QueryInsertionOrder(from, to int) []Interval {
+	return insertionOrder(t.Query(from, to))
+}
+
+// insertionOrder sorts result by Id
+func insertionOrder(result []Interval) []Interval {
+	sort.Sort(ById(result))
+	return result
+}
+
+// QueryWithGaps returns the overlapping intervals clipped to the query and
+// sorted by From, and the segments of the query they don't cover, see ClipWithGaps
+func (t *stree) QueryWithGaps(from, to int) ([]Interval, []Segment) {
+	return ClipWithGaps(t.Query(from, to), from, to)
+}
+
+// RenderView returns the overlapping intervals clipped to the viewport
+// (from, to), sorted by From and mapped to pixels of a row of width, see Render
+func (t *stree) RenderView(from, to, width int) []RenderedInterval {
+	if width <= 0 || from > to {
+		panic(ErrInvalidViewport)
+	}
+	return Render(t.Query(from, to), from, to, width)
+}
+
+// QueryRelative returns the overlapping intervals clipped to the query and
+// shifted so that from becomes 0, see Relative
+func (t *stree) QueryRelative(from, to int) []Segment {
+	return Relative(t.Query(from, to), from, to)
+}
+
+// MergedSegments returns the union of all intervals in the stack, the
+// tree doesn't have to be built
+func (t *stack) MergedSegments() []Segment {
+	return MergedSegments(t.base)
+}
+
+// Canonical returns the distinct segments of the interval stack sorted by
+// From, then To, see Canonical
+func (t *stack) Canonical() []Segment {
+	return Canonical(t.base)
+}
+
+// CanonicalCounted returns the distinct segments of the interval stack with
+// the number of intervals of each segment, see CanonicalCounted
+func (t *stack) CanonicalCounted() []SegmentCount {
+	return CanonicalCounted(t.base)
+}
+
+// HasOverlaps returns true if any two intervals in the stack overlap, the
+// endpoint index of a built tree saves sorting the intervals unless
+// intervals were pushed since
+func (t *stack) HasOverlaps() bool {
+	if t.index.Len() != len(t.base) {
+		return HasOverlaps(t.base)
+	}
+	return t.index.HasOverlaps(t.base)
+}
+
+// IsPartition returns true if the intervals in the stack tile (from, to)
+// without gaps and overlaps, see EndpointIndex.IsPartition
+func (t *stack) IsPartition(from, to int) bool {
+	if t.index.Len() != len(t.base) {
+		return IsPartition(t.base, from, to)
+	}
+	return t.index.IsPartition(t.base, from, to)
+}
+
+// FullyCovered returns true if every coordinate of (from, to) is covered by
+// an interval, false if from > to or the range exceeds min or max of the tree
+func (t *stree) FullyCovered(from, to int) bool {
+	t.checkBuilt()
+	return t.index.Covers(t.base, from, to)
+}
+
+// SetOverlapFunc replaces the closed interval overlap used by queries, e.g.
+// WithinDistance to find intervals near the query. The tree applies f to
+// the segments of its nodes to decide which subtrees to descend into and
+// returns the intervals stored at matching nodes. Therefore f must match a
+// segment if it matches any part of it, and match a part of a segment if
+// it matches the segment. A wider match can't prune subtrees as tightly as
+// the default, queries visit more nodes and calling f prevents inlining.
+// Pass nil to restore the default.
+func (t *stack) SetOverlapFunc(f OverlapFunc) {
+	t.overlaps = f
+}
+
+// overlapFunc returns the custom overlap function or the default
+func (t *stack) overlapFunc() OverlapFunc {
+	if t.overlaps == nil {
+		return Overlaps
+	}
+	return t.overlaps
+}
+
+// CanonicalNodes returns the maximal nodes whose segments are contained in
+// the query interval, see CanonicalNodes
+func (t *stree) CanonicalNodes(from, to int) []Node {
+	t.checkBuilt()
+	return CanonicalNodes(t.root, from, to)
+}
+
+// Join returns the pairs of Ids (this tree, other tree) of overlapping
+// intervals, see JoinIntervals. Every interval of the stack is queried in
+// other, which has to be built. A self join, t.Join(t), pairs every
+// interval with itself and contains both (a, b) and (b, a).
+func (t *stack) Join(other Tree) [][2]int {
+	return JoinIntervals(t.base, other)
+}
+
+// shrink reallocates overlapping intervals of node and its children
+func shrink(node *node) {
+	if node.overlap != nil && len(node.overlap) != cap(node.overlap) {
+		overlap := make([]*Interval, len(node.overlap))
+		copy(overlap, node.overlap)
+		node.overlap = overlap
+	}
+	if node.left != nil {
+		shrink(node.left)
+	}
+	if node.right != nil {
+		shrink(node.right)
+	}
+}
+
 // Endpoints returns a slice with all endpoints (sorted, unique)
 func Endpoints(base []Interval) (result []int, min, max int) {
 	baseLen := len(base)
 	endpoints := make([]int, baseLen*2)
-	for i, interval := range base {
-		endpoints[i] = interval.From
-		endpoints[i+baseLen] = interval.To
+	if ordered(base) {
+		// intervals were pushed in order (e.g. time series), merging the
+		// sorted From and To values is cheaper than sorting all endpoints
+		mergeEndpoints(base, endpoints)
+		result = DedupSorted(endpoints)
+	} else {
+		for i, interval := range base {
+			endpoints[i] = interval.From
+			endpoints[i+baseLen] = interval.To
+		}
+		result = Dedup(endpoints)
 	}
-	result = Dedup(endpoints)
 	min = result[0]
 	max = result[len(result)-1]
 	return
 }
 
-// Dedup removes duplicates from a given slice
+// ValidEndpoints returns true if endpoint is sorted, unique, contains From
+// and To of all intervals of base and starts with min and ends with max
+func ValidEndpoints(base []Interval, endpoint []int, min, max int) bool {
+	if len(endpoint) == 0 || endpoint[0] != min || endpoint[len(endpoint)-1] != max {
+		return false
+	}
+	for i := 1; i < len(endpoint); i++ {
+		if endpoint[i] <= endpoint[i-1] {
+			return false
+		}
+	}
+	contains := func(value int) bool {
+		i := sort.SearchInts(endpoint, value)
+		return i < len(endpoint) && endpoint[i] == value
+	}
+	for _, intrvl := range base {
+		if !contains(intrvl.From) || !contains(intrvl.To) {
+			return false
+		}
+	}
+	return true
+}
+
+// ordered returns true if From and To values of base are both in ascending order
+func ordered(base []Interval) bool {
+	for i := 1; i < len(base); i++ {
+		if base[i].From < base[i-1].From || base[i].To < base[i-1].To {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeEndpoints merges From and To values of ordered intervals into sorted endpoints
+func mergeEndpoints(base []Interval, endpoints []int) {
+	i, j := 0, 0
+	for k := range endpoints {
+		if j == len(base) || (i < len(base) && base[i].From <= base[j].To) {
+			endpoints[k] = base[i].From
+			i++
+		} else {
+			endpoints[k] = base[j].To
+			j++
+		}
+	}
+}
+
+// Dedup removes duplicates from a given slice, slices of at least
+// PARALLEL_SORT values are sorted using all CPUs
 func Dedup(sl []int) []int {
-	sort.Sort(sort.IntSlice(sl))
+	// single pass check is cheaper than sorting already sorted input
+	if !sort.IntsAreSorted(sl) {
+		if cpus := runtime.NumCPU(); cpus > 1 && len(sl) >= PARALLEL_SORT {
+			sortParallel(sl, cpus)
+		} else {
+			slices.Sort(sl)
+		}
+	}
+	return DedupSorted(sl)
+}
+
+// sortParallel sorts parts of sl concurrently and merges the sorted runs
+// pairwise, the merges of a round run concurrently as well
+func sortParallel(sl []int, parts int) {
+	n := len(sl)
+	width := (n + parts - 1) / parts
+	var wait sync.WaitGroup
+	for lo := 0; lo < n; lo += width {
+		wait.Add(1)
+		go func(run []int) {
+			slices.Sort(run)
+			wait.Done()
+		}(sl[lo:min(lo+width, n)])
+	}
+	wait.Wait()
+	src, dst := sl, make([]int, n)
+	for ; width < n; width *= 2 {
+		for lo := 0; lo < n; lo += 2 * width {
+			mid, hi := min(lo+width, n), min(lo+2*width, n)
+			wait.Add(1)
+			go func() {
+				mergeSorted(dst[lo:hi], src[lo:mid], src[mid:hi])
+				wait.Done()
+			}()
+		}
+		wait.Wait()
+		src, dst = dst, src
+	}
+	if &src[0] != &sl[0] {
+		copy(sl, src)
+	}
+}
+
+// mergeSorted merges sorted a and b into dst, len(dst) == len(a) + len(b)
+func mergeSorted(dst, a, b []int) {
+	i, j := 0, 0
+	for k := range dst {
+		if j == len(b) || (i < len(a) && a[i] <= b[j]) {
+			dst[k] = a[i]
+			i++
+		} else {
+			dst[k] = b[j]
+			j++
+		}
+	}
+}
+
+// DedupSorted removes duplicates from a given slice that is sorted in ascending order
+func DedupSorted(sl []int) []int {
 	unique := make([]int, 0, len(sl))
 	prev := sl[0] + 1
 	for _, val := range sl {
@@ -188,20 +880,46 @@ func Dedup(sl []int) []int {
 	return unique
 }
 
-// insertNodes builds tree structure from given endpoints
-func (t *stree) insertNodes(endpoint []int) *node {
-	var n *node
-	if len(endpoint) == 1 {
-		n = &node{segment: Segment{endpoint[0], endpoint[0]}}
-		n.left = nil
-		n.right = nil
-	} else {
-		n = &node{segment: Segment{endpoint[0], endpoint[len(endpoint)-1]}}
-		center := len(endpoint) / 2
-		n.left = t.insertNodes(endpoint[:center])
-		n.right = t.insertNodes(endpoint[center:])
+// ElementaryIntervals returns the leaves of the tree for given endpoints (sorted, unique):
+// a segment for each endpoint and a segment for the coordinates between two
+// consecutive endpoints, if there are any. The segments are disjoint and
+// cover every coordinate from the first to the last endpoint.
+// Panics with ErrInvalidEndpoints if endpoints are not strictly increasing,
+// duplicates would result in overlapping leaves.
+func ElementaryIntervals(endpoint []int) []Segment {
+	leaves := make([]Segment, 0, len(endpoint)*2-1)
+	for i, value := range endpoint {
+		if i > 0 && value <= endpoint[i-1] {
+			panic(ErrInvalidEndpoints)
+		}
+		// value-1 can't overflow unlike value-endpoint[i-1]
+		if i > 0 && value-1 > endpoint[i-1] {
+			leaves = append(leaves, Segment{endpoint[i-1] + 1, value - 1})
+		}
+		leaves = append(leaves, Segment{value, value})
+	}
+	return leaves
+}
+
+// insertNodes builds tree structure from given elementary intervals
+func (t *stree) insertNodes(leaves []Segment) *node {
+	return buildNodes(leaves, func(seg Segment, left, right *node) *node {
+		return &node{segment: seg, left: left, right: right}
+	})
+}
+
+// buildNodes builds a balanced tree over given elementary intervals,
+// newNode creates the node of a segment with its children, which are the
+// zero value of N for leaves
+func buildNodes[N any](leaves []Segment, newNode func(seg Segment, left, right N) N) N {
+	if len(leaves) == 1 {
+		var none N
+		return newNode(leaves[0], none, none)
 	}
-	return n
+	center := len(leaves) / 2
+	left := buildNodes(leaves[:center], newNode)
+	right := buildNodes(leaves[center:], newNode)
+	return newNode(Segment{leaves[0].From, leaves[len(leaves)-1].To}, left, right)
 }
 
 // CompareTo compares two Segments and returns: DISJOINT, SUBSET or INTERSECT_OR_SUPERSET
@@ -215,6 +933,20 @@ func (s *Segment) CompareTo(other *Segment) int {
 	return INTERSECT_OR_SUPERSET
 }
 
+// Overlaps is the default OverlapFunc, closed intervals overlap if they
+// share at least one coordinate
+func Overlaps(seg Segment, from, to int) bool {
+	return !seg.Disjoint(from, to)
+}
+
+// WithinDistance returns an OverlapFunc that matches segments with a gap
+// of at most d coordinates to the query interval, d = 0 equals Overlaps
+func WithinDistance(d int) OverlapFunc {
+	return func(seg Segment, from, to int) bool {
+		return !seg.Disjoint(from-d, to+d)
+	}
+}
+
 // Disjoint returns true if Segment does not overlap with interval
 func (s *Segment) Disjoint(from, to int) bool {
 	if from > s.To || to < s.From {
@@ -223,6 +955,17 @@ func (s *Segment) Disjoint(from, to int) bool {
 	return false
 }
 
+// Distance returns the gap between Segment and point, 0 if it contains point
+func (s *Segment) Distance(point int) int {
+	if point < s.From {
+		return s.From - point
+	}
+	if point > s.To {
+		return point - s.To
+	}
+	return 0
+}
+
 // Inserts interval into given tree structure
 func insertInterval(node *node, intrvl *Interval) {
 	switch node.segment.CompareTo(&intrvl.Segment) {
@@ -247,56 +990,439 @@ func insertInterval(node *node, intrvl *Interval) {
 
 // Query interval
 func (t *stree) Query(from, to int) []Interval {
-	if t.root == nil {
-		panic("Can't run query on empty tree. Call BuildTree() first")
+	return t.QueryHint(from, to, 0)
+}
+
+// Query interval, the result is pre-sized to the expected number of
+// results to avoid growing it during traversal. Unless Ids were given by
+// PushWithId or a custom overlap function is set, each interval is collected
+// at a single node and appended to the result directly, see queryUnique.
+// Otherwise the result is deduplicated with a visited set, see newVisited.
+// Both traversals are deterministic, so the same query on the same tree
+// returns the intervals in the same order.
+func (t *stree) QueryHint(from, to, expected int) []Interval {
+	return t.sorted(t.queryHint(from, to, expected))
+}
+
+func (t *stree) queryHint(from, to, expected int) []Interval {
+	t.checkBuilt()
+	if t.all(from, to) {
+		return slices.Clone(t.base)
 	}
-	result := make(map[int]Interval)
-	querySingle(t.root, from, to, &result)
-	// transform map to slice
-	sl := make([]Interval, 0, len(result))
-	for _, intrvl := range result {
-		sl = append(sl, intrvl)
+	if t.outside(from, to) {
+		return []Interval{}
 	}
+	if t.root.left == nil {
+		// tree of a single node, no need to deduplicate
+		return leafQuery(t.root, from, to, t.overlapFunc())
+	}
+	if t.unique() {
+		sl := make([]Interval, 0, expected)
+		queryUnique(t.root, from, to, &sl)
+		return sl
+	}
+	sl := make([]Interval, 0, expected)
+	querySingle(t.root, from, to, t.overlapFunc(), t.newVisited(), &sl)
 	return sl
 }
 
-// querySingle traverse tree in search of overlaps
-func querySingle(node *node, from, to int, result *map[int]Interval) {
-	if !node.segment.Disjoint(from, to) {
-		for _, pintrvl := range node.overlap {
-			(*result)[pintrvl.Id] = *pintrvl
+// Count returns the number of intervals that overlap (from, to) without
+// collecting them. The traversal is that of QueryHint: if every interval is
+// found at a single node, see queryUnique, no deduplication is needed.
+// Otherwise the Ids found are marked in a visited set, see newVisited.
+func (t *stree) Count(from, to int) int {
+	t.checkBuilt()
+	if t.all(from, to) {
+		return len(t.base)
+	}
+	if t.outside(from, to) {
+		return 0
+	}
+	if t.unique() {
+		return countUnique(t.root, from, to)
+	}
+	return countSingle(t.root, from, to, t.overlapFunc(), t.newVisited())
+}
+
+// countUnique counts the intervals queryUnique would collect
+func countUnique(node *node, from, to int) int {
+	if !Overlaps(node.segment, from, to) {
+		return 0
+	}
+	count := 0
+	for _, pintrvl := range node.overlap {
+		if p := max(pintrvl.From, from); p >= node.segment.From && p <= node.segment.To {
+			count++
 		}
-		if node.right != nil {
-			querySingle(node.right, from, to, result)
+	}
+	if node.right != nil {
+		count += countUnique(node.right, from, to)
+	}
+	if node.left != nil {
+		count += countUnique(node.left, from, to)
+	}
+	return count
+}
+
+// countSingle traverses tree like querySingle and counts the intervals
+// not visited before
+func countSingle(node *node, from, to int, overlaps OverlapFunc, visited visitedSet) int {
+	if !overlaps(node.segment, from, to) {
+		return 0
+	}
+	count := 0
+	for _, pintrvl := range node.overlap {
+		if visited.visit(pintrvl.Id) {
+			count++
 		}
-		if node.left != nil {
-			querySingle(node.left, from, to, result)
+	}
+	if node.right != nil {
+		count += countSingle(node.right, from, to, overlaps, visited)
+	}
+	if node.left != nil {
+		count += countSingle(node.left, from, to, overlaps, visited)
+	}
+	return count
+}
+
+// QueryOrdered returns the overlapping intervals sorted by From, ties are
+// ordered as in ByFrom. Intervals are deduplicated with a visited set,
+// which is a bitset while Ids are dense, and collected into a slice
+// directly instead of a map, see newVisited.
+func (t *stree) QueryOrdered(from, to int) []Interval {
+	t.checkBuilt()
+	result := make([]Interval, 0, 10)
+	queryOrdered(t.root, from, to, t.overlapFunc(), t.newVisited(), &result)
+	SortByFrom(result)
+	return result
+}
+
+// queryOrdered traverses tree and appends overlaps not visited yet to result
+func queryOrdered(node *node, from, to int, overlaps OverlapFunc, visited visitedSet, result *[]Interval) {
+	if !overlaps(node.segment, from, to) {
+		return
+	}
+	for _, pintrvl := range node.overlap {
+		if visited.visit(pintrvl.Id) {
+			*result = append(*result, *pintrvl)
 		}
 	}
+	if node.left != nil {
+		queryOrdered(node.left, from, to, overlaps, visited, result)
+	}
+	if node.right != nil {
+		queryOrdered(node.right, from, to, overlaps, visited, result)
+	}
+}
+
+// outside returns true if the query is entirely below min or above max of
+// the tree, which rejects it without traversal. A custom overlap function
+// may match beyond min and max, then queries are never rejected.
+func (t *stree) outside(from, to int) bool {
+	return t.overlaps == nil && (to < t.min || from > t.max)
+}
+
+// outsideAll returns true if all queries are outside of the tree, see outside
+func (t *stree) outsideAll(from, to []int) bool {
+	for i, fromvalue := range from {
+		if !t.outside(fromvalue, to[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// leafQuery returns overlapping intervals of a node without children
+func leafQuery(node *node, from, to int, overlaps OverlapFunc) []Interval {
+	if !overlaps(node.segment, from, to) {
+		return []Interval{}
+	}
+	return node.Overlap()
 }
 
-// Query interval array
+// all returns true if the query covers the tree, so that the result is the
+// whole interval stack and neither traversal nor deduplication is needed
+func (t *stree) all(from, to int) bool {
+	return t.unique() && from <= t.min && to >= t.max
+}
+
+// unique returns true if a query can find every interval at a single node
+// with queryUnique: the default overlap function is used and Ids are the
+// positions in the stack, so no two intervals share an Id
+func (t *stree) unique() bool {
+	return t.overlaps == nil && !t.sparse
+}
+
+// queryUnique traverses tree in search of overlaps without deduplication.
+// An interval is stored at disjoint canonical nodes, several of them may
+// overlap the query. The first coordinate p = max(From, from) that the
+// interval shares with the query lies in exactly one of them, and this node
+// is visited as its segment contains p. So an interval is collected only at
+// the node whose segment contains p.
+func queryUnique(node *node, from, to int, result *[]Interval) {
+	if !Overlaps(node.segment, from, to) {
+		return
+	}
+	for _, pintrvl := range node.overlap {
+		if p := max(pintrvl.From, from); p >= node.segment.From && p <= node.segment.To {
+			*result = append(*result, *pintrvl)
+		}
+	}
+	// the children partition the segment, a narrow query often overlaps
+	// one of them only and the call for the other one is saved
+	if node.right != nil && to >= node.right.segment.From {
+		queryUnique(node.right, from, to, result)
+	}
+	if node.left != nil && from <= node.left.segment.To {
+		queryUnique(node.left, from, to, result)
+	}
+}
+
+// querySingle traverse tree in search of overlaps
+func querySingle(node *node, from, to int, overlaps OverlapFunc, visited visitedSet, result *[]Interval) {
+	if overlaps(node.segment, from, to) {
+		queryOverlapping(node, from, to, overlaps, visited, result)
+	}
+}
+
+// queryOverlapping appends the intervals of a node that overlaps the
+// query and were not visited before, and descends only into the children
+// that overlap it too, so no call is spent on a disjoint child
+func queryOverlapping(node *node, from, to int, overlaps OverlapFunc, visited visitedSet, result *[]Interval) {
+	for _, pintrvl := range node.overlap {
+		if visited.visit(pintrvl.Id) {
+			*result = append(*result, *pintrvl)
+		}
+	}
+	if node.right != nil && overlaps(node.right.segment, from, to) {
+		queryOverlapping(node.right, from, to, overlaps, visited, result)
+	}
+	if node.left != nil && overlaps(node.left.segment, from, to) {
+		queryOverlapping(node.left, from, to, overlaps, visited, result)
+	}
+}
+
+// Query interval array, the result holds every interval that overlaps
+// any of the intervals (from[i], to[i]) once (OR semantics)
 func (t *stree) QueryArray(from, to []int) []Interval {
-	if t.root == nil {
-		panic("Can't run query on empty tree. Call BuildTree() first")
+	return t.sorted(t.queryArray(from, to))
+}
+
+func (t *stree) queryArray(from, to []int) []Interval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	t.checkBuilt()
+	for i, fromvalue := range from {
+		if t.all(fromvalue, to[i]) {
+			return slices.Clone(t.base)
+		}
 	}
-	result := make(map[int]Interval)
-	queryMulti(t.root, from, to, &result)
-	sl := make([]Interval, 0, len(result))
-	for _, intrvl := range result {
-		sl = append(sl, intrvl)
+	if t.outsideAll(from, to) {
+		return []Interval{}
 	}
+	sl := make([]Interval, 0, 10)
+	queryMulti(t.root, from, to, t.overlapFunc(), t.newVisited(), &sl)
 	return sl
 }
 
+// QueryArrayAll returns the intervals that overlap every interval (from[i],
+// to[i]) of the array (AND semantics), see QueryAll
+func (t *stree) QueryArrayAll(from, to []int) []Interval {
+	return QueryAll(t.Query, from, to)
+}
+
+// QueryView returns a sequence that yields overlapping intervals while the
+// tree is traversed, without collecting them in a map or slice first. If
+// Query deduplicates in a map, the visited set of already yielded Ids is the
+// only allocation, otherwise nothing is allocated. Iteration stops early when the
+// loop breaks. The tree must not be modified during iteration.
+func (t *stree) QueryView(from, to int) IntervalSeq {
+	t.checkBuilt()
+	return func(yield func(Interval) bool) {
+		var visited visitedSet
+		if !t.unique() {
+			visited = t.newVisited()
+		}
+		viewSingle(t.root, from, to, t.overlapFunc(), visited, yield)
+	}
+}
+
+// QueryFunc calls fn for every interval overlapping (from, to) once, while
+// the tree is traversed, and stops when fn returns false, see QueryView
+func (t *stree) QueryFunc(from, to int, fn func(Interval) bool) {
+	t.QueryView(from, to)(fn)
+}
+
+// viewSingle traverses tree and yields overlaps, returns false if iteration
+// stopped. Without visited set intervals are yielded as in queryUnique.
+func viewSingle(node *node, from, to int, overlaps OverlapFunc, visited visitedSet, yield func(Interval) bool) bool {
+	if !overlaps(node.segment, from, to) {
+		return true
+	}
+	for _, pintrvl := range node.overlap {
+		if visited == nil {
+			// see queryUnique
+			if p := max(pintrvl.From, from); p < node.segment.From || p > node.segment.To {
+				continue
+			}
+		} else if !visited.visit(pintrvl.Id) {
+			continue
+		}
+		if !yield(*pintrvl) {
+			return false
+		}
+	}
+	if node.right != nil && !viewSingle(node.right, from, to, overlaps, visited, yield) {
+		return false
+	}
+	if node.left != nil && !viewSingle(node.left, from, to, overlaps, visited, yield) {
+		return false
+	}
+	return true
+}
+
+// QueryDetailed returns the segment and the stored intervals of every node
+// the query found intervals at, parents before children and left to right. The
+// intervals of a node contain its segment, an interval is never stored at
+// two nodes on the same path from root to leaf. Still an interval is found
+// at several nodes if it is split into several canonical segments, that's
+// why Query has to deduplicate the result.
+func (t *stree) QueryDetailed(from, to int) []SegmentOverlap {
+	t.checkBuilt()
+	result := make([]SegmentOverlap, 0, 10)
+	queryDetailed(t.root, from, to, t.overlapFunc(), &result)
+	return result
+}
+
+// queryDetailed traverses tree and appends nodes with overlaps to result
+func queryDetailed(node *node, from, to int, overlaps OverlapFunc, result *[]SegmentOverlap) {
+	if !overlaps(node.segment, from, to) {
+		return
+	}
+	if len(node.overlap) != 0 {
+		*result = append(*result, SegmentOverlap{Segment: node.segment, Interval: node.Overlap()})
+	}
+	if node.left != nil {
+		queryDetailed(node.left, from, to, overlaps, result)
+	}
+	if node.right != nil {
+		queryDetailed(node.right, from, to, overlaps, result)
+	}
+}
+
+// Depth returns the number of intervals that contain point without
+// collecting them. Only the path from root to the leaf of point is
+// searched, an interval is stored at most once on a path.
+func (t *stree) Depth(point int) int {
+	t.checkBuilt()
+	depth := 0
+	for node := t.root; node != nil && !node.segment.Disjoint(point, point); {
+		depth += len(node.overlap)
+		if node.left != nil && point <= node.left.segment.To {
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+	return depth
+}
+
+// Stab returns the intervals that contain point. Unlike Query(point, point)
+// only the path from root to the leaf of point is searched, one child per
+// node, and no deduplication is needed as for Depth. A custom overlap
+// function is not applied.
+func (t *stree) Stab(point int) []Interval {
+	t.checkBuilt()
+	result := make([]Interval, 0, 10)
+	for node := t.root; node != nil && !node.segment.Disjoint(point, point); {
+		for _, pintrvl := range node.overlap {
+			result = append(result, *pintrvl)
+		}
+		if node.left != nil && point <= node.left.segment.To {
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+	return result
+}
+
+// Nearest returns the interval nearest to point, see Nearest
+func (t *stree) Nearest(point int) (Interval, int, bool) {
+	t.checkBuilt()
+	return Nearest(t.root, point)
+}
+
+// Enclosing returns the intervals that contain (from, to), see Enclosing
+func (t *stree) Enclosing(from, to int) []Interval {
+	t.checkBuilt()
+	return Enclosing(t.root, from, to)
+}
+
+// QueryStartsIn returns the intervals that start in the range (from, to)
+// in ascending order of From, found by binary search in the endpoint index
+func (t *stree) QueryStartsIn(from, to int) []Interval {
+	t.checkBuilt()
+	return t.index.StartsIn(t.base, from, to)
+}
+
+// QueryEndsIn returns the intervals that end in the range (from, to)
+// in ascending order of To, found by binary search in the endpoint index
+func (t *stree) QueryEndsIn(from, to int) []Interval {
+	t.checkBuilt()
+	return t.index.EndsIn(t.base, from, to)
+}
+
+// FlowCounts returns the number of intervals that start in the range (from,
+// to) and the number that end in it, counted in the endpoint index in O(log n)
+func (t *stree) FlowCounts(from, to int) (starts, ends int) {
+	t.checkBuilt()
+	return t.index.FlowCounts(t.base, from, to)
+}
+
+// QueryMinLength returns the overlapping intervals with a length To - From
+// of at least minLen, the comparison is inclusive. A point interval has
+// length 0. Shorter intervals are skipped while the tree is traversed.
+func (t *stree) QueryMinLength(from, to, minLen int) []Interval {
+	return minLength(t.QueryView(from, to), minLen)
+}
+
+// minLength collects intervals of seq with To - From >= minLen
+func minLength(seq IntervalSeq, minLen int) []Interval {
+	result := make([]Interval, 0, 10)
+	for intrvl := range seq {
+		// int64 as in Lengths
+		if int64(intrvl.To)-int64(intrvl.From) >= int64(minLen) {
+			result = append(result, intrvl)
+		}
+	}
+	return result
+}
+
+// Query interval and assign result to non-overlapping layers
+func (t *stree) QueryLayered(from, to int) [][]Interval {
+	return Layers(t.Query(from, to))
+}
+
+// LayerCount returns the number of layers of QueryLayered without building
+// them. The intervals found all overlap the query, so two of them overlap
+// inside the query if they overlap at all and clipping them to the query
+// doesn't change the count.
+func (t *stree) LayerCount(from, to int) int {
+	return LayerCount(t.Query(from, to))
+}
+
 // queryMulti traverse tree in search of overlaps with multiple intervals
-func queryMulti(node *node, from, to []int, result *map[int]Interval) {
+func queryMulti(node *node, from, to []int, overlaps OverlapFunc, visited visitedSet, result *[]Interval) {
 	hitsFrom := make([]int, 0, 2)
 	hitsTo := make([]int, 0, 2)
 	for i, fromvalue := range from {
-		if !node.segment.Disjoint(fromvalue, to[i]) {
+		if overlaps(node.segment, fromvalue, to[i]) {
 			for _, pintrvl := range node.overlap {
-				(*result)[pintrvl.Id] = *pintrvl
+				if visited.visit(pintrvl.Id) {
+					*result = append(*result, *pintrvl)
+				}
 			}
 			hitsFrom = append(hitsFrom, fromvalue)
 			hitsTo = append(hitsTo, to[i])
@@ -305,17 +1431,60 @@ func queryMulti(node *node, from, to []int, result *map[int]Interval) {
 	// search in children only with overlapping intervals of parent
 	if len(hitsFrom) != 0 {
 		if node.right != nil {
-			queryMulti(node.right, hitsFrom, hitsTo, result)
+			queryMulti(node.right, hitsFrom, hitsTo, overlaps, visited, result)
+		}
+		if node.left != nil {
+			queryMulti(node.left, hitsFrom, hitsTo, overlaps, visited, result)
+		}
+	}
+}
+
+// QueryArrayAnnotated queries the interval array like QueryArray and
+// returns every interval with the indices of the queries it overlaps. The
+// indices of the queries that overlap a node are passed down the tree.
+func (t *stree) QueryArrayAnnotated(from, to []int) []AnnotatedInterval {
+	if len(from) != len(to) {
+		panic(ErrArrayLength)
+	}
+	t.checkBuilt()
+	ranges := make([]int, len(from))
+	for i := range ranges {
+		ranges[i] = i
+	}
+	result := make(map[int]*AnnotatedInterval)
+	queryAnnotated(t.root, from, to, ranges, t.overlapFunc(), result)
+	return annotated(result)
+}
+
+// queryAnnotated traverses tree like queryMulti, ranges holds the indices
+// of the queries (from[i], to[i]) in the original array
+func queryAnnotated(node *node, from, to, ranges []int, overlaps OverlapFunc, result map[int]*AnnotatedInterval) {
+	hitsFrom := make([]int, 0, 2)
+	hitsTo := make([]int, 0, 2)
+	hitsRanges := make([]int, 0, 2)
+	for i, fromvalue := range from {
+		if overlaps(node.segment, fromvalue, to[i]) {
+			for _, pintrvl := range node.overlap {
+				annotate(result, *pintrvl, ranges[i])
+			}
+			hitsFrom = append(hitsFrom, fromvalue)
+			hitsTo = append(hitsTo, to[i])
+			hitsRanges = append(hitsRanges, ranges[i])
+		}
+	}
+	if len(hitsFrom) != 0 {
+		if node.right != nil {
+			queryAnnotated(node.right, hitsFrom, hitsTo, hitsRanges, overlaps, result)
 		}
 		if node.left != nil {
-			queryMulti(node.left, hitsFrom, hitsTo, result)
+			queryAnnotated(node.left, hitsFrom, hitsTo, hitsRanges, overlaps, result)
 		}
 	}
 }
 
 // Traverse tree recursively call enter when entering node, resp. leave
 func traverse(node Node, enter, leave NodeReceive) {
-	if reflect.ValueOf(node).IsNil() {
+	if node == nil || reflect.ValueOf(node).IsNil() {
 		return
 	}
 	if enter != nil {
@@ -328,12 +1497,115 @@ func traverse(node Node, enter, leave NodeReceive) {
 	}
 }
 
+// CanonicalNodes returns the canonical decomposition of the query interval:
+// the maximal subtree roots whose segments are contained in (from, to). The
+// segments of these nodes are disjoint and partition the part of the query
+// interval covered by the tree, nodes are in ascending order of their segments.
+// An interval stored at a canonical node contains the node's segment, yet
+// a query also visits the ancestors of canonical nodes and the nodes that
+// partially overlap the query, which is why the set of visited nodes is larger.
+func CanonicalNodes(root Node, from, to int) []Node {
+	nodes := make([]Node, 0, 10)
+	query := Segment{from, to}
+	var canonical func(node Node)
+	canonical = func(node Node) {
+		if reflect.ValueOf(node).IsNil() {
+			return
+		}
+		segment := node.Segment()
+		switch segment.CompareTo(&query) {
+		case SUBSET:
+			nodes = append(nodes, node)
+		case INTERSECT_OR_SUPERSET:
+			canonical(node.Left())
+			canonical(node.Right())
+		case DISJOINT:
+			// nothing to do
+		}
+	}
+	canonical(root)
+	return nodes
+}
+
+// Enclosing returns the intervals stored in the tree that contain (from, to),
+// i.e. From <= from and To >= to, an equal interval encloses it too. An
+// enclosing interval contains from, so only the path from root to the leaf
+// of from is searched. An interval is stored at most once on a path, no
+// deduplication is needed. The result is empty if from > to.
+func Enclosing(root Node, from, to int) []Interval {
+	result := make([]Interval, 0, 10)
+	if from > to {
+		return result
+	}
+	node := root
+	for !reflect.ValueOf(node).IsNil() {
+		segment := node.Segment()
+		if segment.Disjoint(from, from) {
+			break
+		}
+		for _, intrvl := range node.Overlap() {
+			if intrvl.To >= to {
+				result = append(result, intrvl)
+			}
+		}
+		left := node.Left()
+		if !reflect.ValueOf(left).IsNil() && from <= left.Segment().To {
+			node = left
+		} else {
+			node = node.Right()
+		}
+	}
+	return result
+}
+
+// Nearest returns the interval stored in the tree that is nearest to point,
+// its distance to point, 0 if it contains point, and false if the tree holds
+// no intervals. Of intervals at the same distance the lowest Id wins.
+// An interval is stored at the node of its coordinate nearest to point or at
+// a node nearer to point, and children are never nearer than their parent.
+// So the child on the side of point is searched first, the other side of
+// the split only if its segment is not farther than the best interval found.
+func Nearest(root Node, point int) (Interval, int, bool) {
+	var best Interval
+	distance, found := 0, false
+	var search func(node Node)
+	search = func(node Node) {
+		if reflect.ValueOf(node).IsNil() {
+			return
+		}
+		segment := node.Segment()
+		if found && segment.Distance(point) > distance {
+			return
+		}
+		for _, intrvl := range node.Overlap() {
+			d := intrvl.Distance(point)
+			if !found || d < distance || d == distance && intrvl.Id < best.Id {
+				best, distance, found = intrvl, d, true
+			}
+		}
+		left, right := node.Left(), node.Right()
+		if !reflect.ValueOf(right).IsNil() && point >= right.Segment().From {
+			left, right = right, left
+		}
+		search(left)
+		search(right)
+	}
+	search(root)
+	return best, distance, found
+}
+
 // Print tree recursively to sdout
 func Print(root Node) {
+	Fprint(os.Stdout, root)
+}
+
+// Fprint writes tree recursively to w, every node as line of its segment
+// followed by lines of its intervals
+func Fprint(w io.Writer, root Node) {
 	traverse(root, func(node Node) {
-		fmt.Printf("\nSegment: (%d,%d)", node.Segment().From, node.Segment().To)
+		fmt.Fprintf(w, "\nSegment: (%d,%d)", node.Segment().From, node.Segment().To)
 		for _, intrvl := range node.Overlap() {
-			fmt.Printf("\nInterval %d: (%d,%d)", intrvl.Id, intrvl.From, intrvl.To)
+			fmt.Fprintf(w, "\nInterval %d: (%d,%d)", intrvl.Id, intrvl.From, intrvl.To)
 		}
 	}, nil)
 }
@@ -347,3 +1619,22 @@ func Tree2Array(root Node) []SegmentOverlap {
 	}, nil)
 	return array
 }
+
+// Tree2Seq yields the nodes of tree in the order of Tree2Array, the
+// overlapping intervals of a node are copied only when it is reached
+func Tree2Seq(root Node) iter.Seq[SegmentOverlap] {
+	return func(yield func(SegmentOverlap) bool) {
+		yieldNodes(root, yield)
+	}
+}
+
+// yieldNodes traverses tree in preorder, returns false if yield stopped the traversal
+func yieldNodes(node Node, yield func(SegmentOverlap) bool) bool {
+	if reflect.ValueOf(node).IsNil() {
+		return true
+	}
+	if !yield(SegmentOverlap{Segment: node.Segment(), Interval: node.Overlap()}) {
+		return false
+	}
+	return yieldNodes(node.Right(), yield) && yieldNodes(node.Left(), yield)
+}
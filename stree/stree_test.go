@@ -5,49 +5,52 @@
 package stree
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
 	"math"
 	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
 	"testing"
 )
 
 func TestTreeEqualSerial(t *testing.T) {
 	tree := NewTree()
 	serial := NewSerial()
-	for i := 0; i < 100000; i++ {
-		min := rand.Int()
-		max := rand.Int()
-		if min > max {
-			min, max = max, min
-		}
-		tree.Push(min, max)
-		serial.Push(min, max)
-	}
+	from, to := GenerateIntervals(100000, math.MaxInt, 1, UNIFORM)
+	tree.PushArray(from, to)
+	serial.PushArray(from, to)
 	tree.BuildTree()
-	treeresult := tree.Query(0, 1000000)
-	//fmt.Println(treeresult)
-	serialresult := serial.Query(0, 1000000)
-	//fmt.Println(serialresult)
-	treemap := make(map[int]Segment)
-	fail := false
-	if len(treeresult) != len(serialresult) {
-		fail = true
-		fmt.Printf("unequal result length")
-		goto Fail
+	if !EqualResults(tree, serial, []Segment{{0, 1000000}}) {
+		t.Errorf("Result not equal")
 	}
-	for _, value := range treeresult {
-		treemap[value.Id] = value.Segment
+}
+
+func TestEqual(t *testing.T) {
+	a := NewTree()
+	b := NewTree()
+	a.PushArray([]int{1, 2, 5}, []int{3, 8, 9})
+	b.PushArray([]int{1, 2, 5}, []int{3, 8, 9})
+	a.BuildTree()
+	b.BuildTree()
+	queries := []Segment{{0, 1}, {3, 3}, {4, 6}, {0, 10}}
+	if !Equal(a, b) || !EqualResults(a, b, queries) {
+		t.Errorf("fail equal trees")
 	}
-	for _, intrvl := range serialresult {
-		if treemap[intrvl.Id] != intrvl.Segment {
-			fail = true
-			fmt.Printf("result interval mismatch")
-			break
-		}
+	b.Clear()
+	b.PushArray([]int{1, 2, 5}, []int{3, 8, 10})
+	b.BuildTree()
+	if Equal(a, b) || EqualResults(a, b, queries) {
+		t.Errorf("fail unequal trees")
 	}
-Fail:
-	if fail {
-		t.Errorf("Result not equal")
+	if !EqualResults(a, b, queries[:2]) {
+		t.Errorf("fail equal results of unequal trees")
 	}
 }
 
@@ -135,15 +138,9 @@ var ser Tree
 func init() {
 	tree = NewTree()
 	ser = NewSerial()
-	for j := 0; j < 100000; j++ {
-		min := rand.Int()
-		max := rand.Int()
-		if min > max {
-			min, max = max, min
-		}
-		tree.Push(min, max)
-		ser.Push(min, max)
-	}
+	from, to := GenerateIntervals(100000, math.MaxInt, 1, UNIFORM)
+	tree.PushArray(from, to)
+	ser.PushArray(from, to)
 	tree.BuildTree()
 }
 
@@ -163,6 +160,35 @@ func BenchmarkQueryTree(b *testing.B) {
 	}
 }
 
+// narrowQueries benchmarks queries of short ranges at the starts of
+// clustered intervals, most children of the visited nodes are disjoint
+func narrowQueries(b *testing.B, sparse bool) {
+	tree := NewTree()
+	from, to := GenerateIntervals(100000, 1000000000, 1, CLUSTERED)
+	for i := range from {
+		if sparse {
+			// queried with deduplication
+			tree.PushWithId(2*i, from[i], to[i])
+		} else {
+			tree.Push(from[i], to[i])
+		}
+	}
+	tree.BuildTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := from[i%len(from)]
+		tree.Query(p, p+10)
+	}
+}
+
+func BenchmarkQueryTreeNarrow(b *testing.B) {
+	narrowQueries(b, false)
+}
+
+func BenchmarkQueryTreeNarrowSparse(b *testing.B) {
+	narrowQueries(b, true)
+}
+
 func BenchmarkQuerySerial(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		ser.Query(0, 100000)
@@ -181,6 +207,85 @@ func BenchmarkQuerySerialMax(b *testing.B) {
 	}
 }
 
+func BenchmarkQueryTreeAll(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree.Query(math.MinInt, math.MaxInt)
+	}
+}
+
+// most of the intervals overlap the lower half of the coordinates
+func BenchmarkQueryTreeHalf(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree.Query(0, math.MaxInt/2)
+	}
+}
+
+func BenchmarkQuerySerialHalf(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ser.Query(0, math.MaxInt/2)
+	}
+}
+
+func BenchmarkCountTree(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree.Count(0, math.MaxInt/2)
+	}
+}
+
+func BenchmarkCountTreeLenQuery(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = len(tree.Query(0, math.MaxInt/2))
+	}
+}
+
+func BenchmarkCountSerial(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		ser.Count(0, math.MaxInt/2)
+	}
+}
+
+func BenchmarkCountSerialLenQuery(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = len(ser.Query(0, math.MaxInt/2))
+	}
+}
+
+func BenchmarkStabTree(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree.Stab(math.MaxInt / 4)
+	}
+}
+
+func BenchmarkStabTreeQuery(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree.Query(math.MaxInt/4, math.MaxInt/4)
+	}
+}
+
+// countVisited benchmarks Count of a tree with dense Ids given by PushWithId
+// in random order, which are deduplicated with the visited set of newSet
+func countVisited(b *testing.B, newSet func(t *stree) visitedSet) {
+	tree := NewTree().(*stree)
+	from, to := GenerateIntervals(100000, math.MaxInt, 1, UNIFORM)
+	ids := rand.New(rand.NewSource(1)).Perm(len(from))
+	for i := range from {
+		tree.PushWithId(ids[i], from[i], to[i])
+	}
+	tree.BuildTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		countSingle(tree.root, 0, math.MaxInt/2, Overlaps, newSet(tree))
+	}
+}
+
+func BenchmarkCountVisitedBits(b *testing.B) {
+	countVisited(b, func(t *stree) visitedSet { return make(visitedBits, (t.count+63)/64) })
+}
+
+func BenchmarkCountVisitedMap(b *testing.B) {
+	countVisited(b, func(t *stree) visitedSet { return make(visitedMap) })
+}
+
 func BenchmarkQueryTreeArray(b *testing.B) {
 	from := []int{0, 1000000, 2000000, 3000000, 4000000, 5000000, 6000000, 7000000, 8000000, 9000000}
 	to := []int{10, 1000010, 2000010, 3000010, 4000010, 5000010, 6000010, 7000010, 8000010, 9000010}
@@ -207,15 +312,12 @@ func buildTree(b *testing.B, tree Tree, count int) {
 	}
 }
 
+// seed of next intervals pushed by pushRandom
+var seed int64
+
 func pushRandom(tree Tree, count int) {
-	for j := 0; j < count; j++ {
-		min := rand.Int()
-		max := rand.Int()
-		if min > max {
-			min, max = max, min
-		}
-		tree.Push(min, max)
-	}
+	seed++
+	tree.PushArray(GenerateIntervals(count, math.MaxInt, seed, UNIFORM))
 }
 
 func BenchmarkEndpoints100000(b *testing.B) {
@@ -237,7 +339,7 @@ func BenchmarkInsertNodes100000(b *testing.B) {
 		endpoint, tree.min, tree.max = Endpoints(tree.base)
 		//fmt.Println(len(endpoint))
 		b.StartTimer()
-		tree.root = tree.insertNodes(endpoint)
+		tree.root = tree.insertNodes(ElementaryIntervals(endpoint))
 	}
 }
 
@@ -248,10 +350,2765 @@ func BenchmarkInsertIntervals100000(b *testing.B) {
 		pushRandom(tree, 100000)
 		var endpoint []int
 		endpoint, tree.min, tree.max = Endpoints(tree.base)
-		tree.root = tree.insertNodes(endpoint)
+		tree.root = tree.insertNodes(ElementaryIntervals(endpoint))
 		b.StartTimer()
 		for i := range tree.base {
 			insertInterval(tree.root, &tree.base[i])
 		}
 	}
 }
+
+func TestEndpointsOrdered(t *testing.T) {
+	base := []Interval{{Id: 0, Segment: Segment{1, 4}}, {Id: 1, Segment: Segment{2, 3}}, {Id: 2, Segment: Segment{2, 8}}, {Id: 3, Segment: Segment{5, 9}}}
+	result, min, max := Endpoints(base)
+	expected := []int{1, 2, 3, 4, 5, 8, 9}
+	if !reflect.DeepEqual(result, expected) || min != 1 || max != 9 {
+		t.Errorf("fail endpoints of unordered intervals: %v", result)
+	}
+	base = []Interval{{Id: 0, Segment: Segment{1, 4}}, {Id: 1, Segment: Segment{2, 4}}, {Id: 2, Segment: Segment{3, 8}}, {Id: 3, Segment: Segment{6, 9}}}
+	result, min, max = Endpoints(base)
+	expected = []int{1, 2, 3, 4, 6, 8, 9}
+	if !reflect.DeepEqual(result, expected) || min != 1 || max != 9 {
+		t.Errorf("fail endpoints of ordered intervals: %v", result)
+	}
+}
+
+func TestDedupSorted(t *testing.T) {
+	if result := Dedup([]int{3, 1, 3, 2, 1}); !reflect.DeepEqual(result, []int{1, 2, 3}) {
+		t.Errorf("fail dedup of unsorted slice: %v", result)
+	}
+	if result := DedupSorted([]int{1, 1, 2, 3, 3}); !reflect.DeepEqual(result, []int{1, 2, 3}) {
+		t.Errorf("fail dedup of sorted slice: %v", result)
+	}
+}
+
+// sortedEndpoints returns count pre-sorted endpoints with duplicates
+func sortedEndpoints(count int) []int {
+	endpoints := make([]int, count)
+	for i := range endpoints {
+		endpoints[i] = i / 2
+	}
+	return endpoints
+}
+
+func BenchmarkSortDedupSorted1M(b *testing.B) {
+	endpoints := sortedEndpoints(1000000)
+	for i := 0; i < b.N; i++ {
+		// unconditional sort as done before the sorted fast path
+		sort.Sort(sort.IntSlice(endpoints))
+		DedupSorted(endpoints)
+	}
+}
+
+func TestSortParallel(t *testing.T) {
+	for _, n := range []int{1, 7, 1000, 1001} {
+		sl := rand.New(rand.NewSource(int64(n))).Perm(n)
+		for _, parts := range []int{1, 2, 3, 8} {
+			unsorted := slices.Clone(sl)
+			sortParallel(unsorted, parts)
+			if !sort.IntsAreSorted(unsorted) || len(unsorted) != n {
+				t.Errorf("fail sort parallel %d in %d parts", n, parts)
+			}
+		}
+	}
+}
+
+// randomEndpoints returns count unsorted endpoints
+func randomEndpoints(count int) []int {
+	from, to := GenerateIntervals(count/2, math.MaxInt, 1, UNIFORM)
+	return append(from, to...)
+}
+
+func BenchmarkSort10M(b *testing.B) {
+	endpoints := randomEndpoints(10000000)
+	sl := make([]int, len(endpoints))
+	for i := 0; i < b.N; i++ {
+		copy(sl, endpoints)
+		sort.Sort(sort.IntSlice(sl))
+	}
+}
+
+func BenchmarkSortParallel10M(b *testing.B) {
+	endpoints := randomEndpoints(10000000)
+	sl := make([]int, len(endpoints))
+	for i := 0; i < b.N; i++ {
+		copy(sl, endpoints)
+		sortParallel(sl, max(runtime.NumCPU(), 4))
+	}
+}
+
+func BenchmarkDedupSorted1M(b *testing.B) {
+	endpoints := sortedEndpoints(1000000)
+	for i := 0; i < b.N; i++ {
+		Dedup(endpoints)
+	}
+}
+
+func BenchmarkEndpointsOrdered1M(b *testing.B) {
+	tree := NewTree().(*stree)
+	for j := 0; j < 500000; j++ {
+		tree.Push(j*2, j*2+3)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Endpoints(tree.base)
+	}
+}
+
+func TestSortIntervals(t *testing.T) {
+	intervals := []Interval{{Id: 0, Segment: Segment{5, 9}}, {Id: 1, Segment: Segment{1, 8}}, {Id: 2, Segment: Segment{1, 3}}, {Id: 3, Segment: Segment{4, 5}}}
+	ids := func() []int {
+		result := make([]int, len(intervals))
+		for i, intrvl := range intervals {
+			result[i] = intrvl.Id
+		}
+		return result
+	}
+	SortByFrom(intervals)
+	if result := ids(); !reflect.DeepEqual(result, []int{2, 1, 3, 0}) {
+		t.Errorf("fail sort by from: %v", result)
+	}
+	SortByTo(intervals)
+	if result := ids(); !reflect.DeepEqual(result, []int{2, 3, 1, 0}) {
+		t.Errorf("fail sort by to: %v", result)
+	}
+	SortByLength(intervals)
+	if result := ids(); !reflect.DeepEqual(result, []int{3, 2, 0, 1}) {
+		t.Errorf("fail sort by length: %v", result)
+	}
+	// lengths beyond the range of int
+	wide := []Interval{{Id: 0, Segment: Segment{math.MinInt, math.MaxInt}}, {Id: 1, Segment: Segment{0, 10}}, {Id: 2, Segment: Segment{-1, math.MaxInt}}}
+	SortByLength(wide)
+	if wide[0].Id != 1 || wide[1].Id != 2 || wide[2].Id != 0 {
+		t.Errorf("fail sort of wide intervals by length: %v", wide)
+	}
+}
+
+func TestQueryLayered(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial()} {
+		tree.PushArray([]int{1, 2, 5, 4, 6}, []int{1, 3, 7, 6, 9})
+		if _, ok := tree.(*stree); ok {
+			tree.BuildTree()
+		}
+		layers := tree.(LinearQueries).QueryLayered(0, 10)
+		if len(layers) != 3 {
+			t.Errorf("fail query layered: %v", layers)
+		}
+		count := 0
+		for _, layer := range layers {
+			for i := 1; i < len(layer); i++ {
+				if !layer[i].Disjoint(layer[i-1].From, layer[i-1].To) {
+					t.Errorf("fail overlapping intervals in layer: %v", layer)
+				}
+			}
+			count += len(layer)
+		}
+		if count != 5 {
+			t.Errorf("fail number of intervals in layers: %d", count)
+		}
+	}
+}
+
+func TestElementaryIntervals(t *testing.T) {
+	leaves := ElementaryIntervals([]int{1, 2, 5, 9})
+	expected := []Segment{{1, 1}, {2, 2}, {3, 4}, {5, 5}, {6, 8}, {9, 9}}
+	if !reflect.DeepEqual(leaves, expected) {
+		t.Errorf("fail elementary intervals: %v", leaves)
+	}
+	// distance of endpoints overflows int
+	leaves = ElementaryIntervals([]int{math.MinInt, math.MaxInt})
+	expected = []Segment{{math.MinInt, math.MinInt}, {math.MinInt + 1, math.MaxInt - 1}, {math.MaxInt, math.MaxInt}}
+	if !reflect.DeepEqual(leaves, expected) {
+		t.Errorf("fail elementary intervals of extreme endpoints: %v", leaves)
+	}
+	defer func() {
+		if r := recover(); r != ErrInvalidEndpoints {
+			t.Errorf("fail panic on duplicate endpoints: %v", r)
+		}
+	}()
+	ElementaryIntervals([]int{5, 5, 7})
+}
+
+func TestQueryBetweenEndpoints(t *testing.T) {
+	tree := NewTree()
+	serial := NewSerial()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(10000)
+		to := from + rand.Intn(100)
+		tree.Push(from, to)
+		serial.Push(from, to)
+	}
+	tree.BuildTree()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(10100)
+		to := from + rand.Intn(10)
+		if len(tree.Query(from, to)) != len(serial.Query(from, to)) {
+			t.Errorf("fail query (%d, %d) between endpoints", from, to)
+		}
+	}
+}
+
+func TestOverlapEntryCount(t *testing.T) {
+	tree := NewTree()
+	pushRandom(tree, 1000)
+	// wide interval that is not a subset of the root
+	tree.Push(0, math.MaxInt/2)
+	tree.BuildTree()
+	endpoint, _, _ := Endpoints(tree.(*stree).base)
+	depth := int(math.Ceil(math.Log2(float64(len(ElementaryIntervals(endpoint))))))
+	count := 0
+	for _, seg := range tree.Tree2Array() {
+		for _, intrvl := range seg.Interval {
+			if intrvl.Id == 1000 {
+				count++
+			}
+		}
+	}
+	var maxDepth MaxDepth
+	tree.Aggregate(&maxDepth)
+	if maxDepth.Depth != depth {
+		t.Errorf("fail max depth %d != %d", maxDepth.Depth, depth)
+	}
+	// canonical bound of segment tree: at most two nodes per level
+	if count == 0 || count > 2*depth {
+		t.Errorf("fail overlap entry count %d for tree of depth %d", count, depth)
+	}
+}
+
+func TestCircularTree(t *testing.T) {
+	tree := NewCircularTree(360)
+	tree.Push(350, 10)
+	tree.Push(100, 200)
+	tree.Push(5, 20)
+	tree.BuildTree()
+	ids := func(result []Interval) map[int]Segment {
+		m := make(map[int]Segment)
+		for _, intrvl := range result {
+			m[intrvl.Id] = intrvl.Segment
+		}
+		return m
+	}
+	if result := ids(tree.Query(355, 5)); len(result) != 2 || result[0] != (Segment{350, 10}) || result[2] != (Segment{5, 20}) {
+		t.Errorf("fail wrapping query (355, 5): %v", result)
+	}
+	if result := ids(tree.Query(0, 0)); len(result) != 1 || result[0] != (Segment{350, 10}) {
+		t.Errorf("fail query (0, 0): %v", result)
+	}
+	if result := ids(tree.Query(15, 150)); len(result) != 2 || result[1] != (Segment{100, 200}) {
+		t.Errorf("fail query (15, 150): %v", result)
+	}
+	if result := ids(tree.Query(300, 340)); len(result) != 0 {
+		t.Errorf("fail query (300, 340): %v", result)
+	}
+	safe := NewSafeTree(tree)
+	if result := safe.Query(300, 360); result != nil || safe.LastError() != ErrOutsidePeriod {
+		t.Errorf("fail error of query outside of period: %v", safe.LastError())
+	}
+	defer func() {
+		if r := recover(); r != ErrInvalidPeriod {
+			t.Errorf("fail panic on invalid period: %v", r)
+		}
+	}()
+	NewCircularTree(0)
+}
+
+func TestLengthsOverflow(t *testing.T) {
+	// the sums exceed int on 32 bit platforms
+	intervals := make([]Interval, 10000)
+	for i := range intervals {
+		intervals[i] = Interval{Id: i, Segment: Segment{0, math.MaxInt32}}
+	}
+	stats := Lengths(intervals)
+	if stats.Count != 10000 || stats.Sum != 10000*int64(math.MaxInt32) || stats.Min != math.MaxInt32 || stats.Max != math.MaxInt32 {
+		t.Errorf("fail length stats: %v", stats)
+	}
+	if coverage := Coverage(intervals); coverage != int64(math.MaxInt32)+1 {
+		t.Errorf("fail coverage: %d", coverage)
+	}
+	histogram := DensityHistogram(intervals, 0, math.MaxInt32, 2)
+	if half := 10000 * (int64(math.MaxInt32) + 1) / 2; histogram[0] != half || histogram[1] != half {
+		t.Errorf("fail density histogram: %v", histogram)
+	}
+}
+
+func TestDensityHistogram(t *testing.T) {
+	intervals := []Interval{{Id: 0, Segment: Segment{0, 9}}, {Id: 1, Segment: Segment{5, 5}}, {Id: 2, Segment: Segment{-5, 2}}, {Id: 3, Segment: Segment{20, 30}}}
+	// buckets of the 10 coordinates 0 to 9 are 0-3, 4-6 and 7-9
+	if histogram := DensityHistogram(intervals, 0, 9, 3); !reflect.DeepEqual(histogram, []int64{7, 4, 3}) {
+		t.Errorf("fail density histogram: %v", histogram)
+	}
+	if histogram := DensityHistogram(intervals, 10, 15, 1); !reflect.DeepEqual(histogram, []int64{0}) {
+		t.Errorf("fail density histogram of empty range: %v", histogram)
+	}
+	defer func() {
+		if r := recover(); r != ErrInvalidViewport {
+			t.Errorf("fail panic on invalid buckets: %v", r)
+		}
+	}()
+	DensityHistogram(intervals, 0, 9, 0)
+}
+
+func TestCoverage(t *testing.T) {
+	intervals := []Interval{{Id: 0, Segment: Segment{1, 3}}, {Id: 1, Segment: Segment{4, 5}}, {Id: 2, Segment: Segment{2, 4}}, {Id: 3, Segment: Segment{8, 9}}}
+	if coverage := Coverage(intervals); coverage != 7 {
+		t.Errorf("fail coverage: %d", coverage)
+	}
+	if stats := Lengths(intervals); stats.Sum != 6 || stats.Mean() != 1.5 || stats.Min != 1 || stats.Max != 2 {
+		t.Errorf("fail length stats: %v", stats)
+	}
+}
+
+func TestShrinkToFit(t *testing.T) {
+	tree := NewTree()
+	pushRandom(tree, 1000)
+	tree.BuildTree()
+	before := tree.Tree2Array()
+	tree.ShrinkToFit()
+	traverse(tree.(*stree).root, func(n Node) {
+		if overlap := n.(*node).overlap; len(overlap) != cap(overlap) {
+			t.Errorf("fail shrink overlap of length %d, capacity %d", len(overlap), cap(overlap))
+		}
+	}, nil)
+	if after := tree.Tree2Array(); !reflect.DeepEqual(before, after) {
+		t.Errorf("fail tree modified by shrink")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := NewTree()
+	old.PushArray([]int{1, 2, 5}, []int{3, 8, 9})
+	old.BuildTree()
+	new := NewSerial()
+	new.PushArray([]int{1, 2}, []int{3, 8})
+	added, removed := Diff(old, new, 0, 10)
+	if len(added) != 0 || len(removed) != 1 || removed[0] != (Interval{Id: 2, Segment: Segment{5, 9}}) {
+		t.Errorf("fail diff: added %v, removed %v", added, removed)
+	}
+	added, removed = Diff(new, old, 4, 6)
+	if len(added) != 1 || len(removed) != 0 || added[0] != (Interval{Id: 2, Segment: Segment{5, 9}}) {
+		t.Errorf("fail diff: added %v, removed %v", added, removed)
+	}
+	if added, removed = Diff(old, old, 0, 10); len(added) != 0 || len(removed) != 0 {
+		t.Errorf("fail diff of same tree: added %v, removed %v", added, removed)
+	}
+}
+
+func TestCompareQueryStreaming(t *testing.T) {
+	from, to := GenerateIntervals(2000, 10000, 15, UNIFORM)
+	a := NewTree()
+	b := NewSerial()
+	for i := range from {
+		// Ids span several windows, including negative ones
+		id := (i - 1000) * (streamWindow / 300)
+		a.PushWithId(id, from[i], to[i])
+		b.PushWithId(id, from[i], to[i])
+	}
+	a.BuildTree()
+	if equal, err := CompareQueryStreaming(a, b, 0, 10000); !equal || err != nil {
+		t.Errorf("fail streaming compare of equal results: %v %v", equal, err)
+	}
+	if equal, err := CompareQueryStreaming(a, b, 20000, 30000); !equal || err != nil {
+		t.Errorf("fail streaming compare of empty results: %v %v", equal, err)
+	}
+	// a single Id differs within a middle window
+	c := NewSerial()
+	for i := range from {
+		id := (i - 1000) * (streamWindow / 300)
+		if i == 1000 {
+			id++
+		}
+		c.PushWithId(id, from[i], to[i])
+	}
+	if equal, err := CompareQueryStreaming(a, c, 0, 10000); equal || err != nil {
+		t.Errorf("fail streaming compare of different results: %v %v", equal, err)
+	}
+	// sparse Ids far apart take a pass per Id, not per window in between
+	sparse := NewTree()
+	sparse.PushWithId(math.MinInt, 0, 10)
+	sparse.PushWithId(0, 5, 15)
+	sparse.PushWithId(math.MaxInt/2, 10, 20)
+	sparse.PushWithId(math.MaxInt, 0, 20)
+	sparse.BuildTree()
+	other := NewSerial()
+	other.PushIntervals(sparse.Intervals())
+	if equal, err := CompareQueryStreaming(sparse, other, 0, 20); !equal || err != nil {
+		t.Errorf("fail streaming compare of sparse Ids: %v %v", equal, err)
+	}
+	other.(Updater).Remove(math.MaxInt / 2)
+	other.PushWithId(math.MaxInt/2+1, 10, 20)
+	if equal, err := CompareQueryStreaming(sparse, other, 0, 20); equal || err != nil {
+		t.Errorf("fail streaming compare of different sparse Ids: %v %v", equal, err)
+	}
+	if equal, err := CompareQueryStreaming(NewTree(), b, 0, 10000); equal || err != ErrEmptyTree {
+		t.Errorf("fail streaming compare of empty tree: %v %v", equal, err)
+	}
+}
+
+func TestQueryHint(t *testing.T) {
+	for _, expected := range []int{0, 10, 1000} {
+		if result := tree.QueryHint(0, math.MaxInt, expected); len(result) != 100000 {
+			t.Errorf("fail query hint %d: %d", expected, len(result))
+		}
+		if result := ser.QueryHint(0, math.MaxInt, expected); len(result) != 100000 {
+			t.Errorf("fail serial query hint %d: %d", expected, len(result))
+		}
+	}
+}
+
+func BenchmarkQueryTreeFull(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree.Query(0, math.MaxInt)
+	}
+}
+
+func BenchmarkQueryTreeFullHint(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree.QueryHint(0, math.MaxInt, 100000)
+	}
+}
+
+func TestPushKey(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100)} {
+		tree.PushKey(1, 3, "a")
+		tree.Push(2, 5)
+		tree.PushKey(4, 8, "c")
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		keys := make(map[int]string)
+		for _, intrvl := range tree.Query(3, 4) {
+			keys[intrvl.Id] = intrvl.Key
+		}
+		if !reflect.DeepEqual(keys, map[int]string{0: "a", 1: "", 2: "c"}) {
+			t.Errorf("fail keys of query result: %v", keys)
+		}
+		if intrvl, ok := tree.GetByKey("c"); !ok || intrvl.Id != 2 || intrvl.Segment != (Segment{4, 8}) {
+			t.Errorf("fail get by key: %v", intrvl)
+		}
+		if _, ok := tree.GetByKey("b"); ok {
+			t.Errorf("fail get by unknown key")
+		}
+	}
+}
+
+func BenchmarkBuildTree1(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree := NewTree()
+		tree.Push(3, 7)
+		tree.BuildTree()
+	}
+}
+
+func BenchmarkQueryTree1(b *testing.B) {
+	tree := NewTree()
+	tree.Push(3, 7)
+	tree.BuildTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Query(5, 10)
+	}
+}
+
+func TestQueryView(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100)} {
+		tree.PushArray([]int{1, 2, 5, 4, 6}, []int{1, 3, 7, 6, 9})
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		ids := make(map[int]bool)
+		for intrvl := range tree.QueryView(3, 6) {
+			if ids[intrvl.Id] {
+				t.Errorf("fail interval %d yielded twice", intrvl.Id)
+			}
+			ids[intrvl.Id] = true
+		}
+		if len(ids) != 4 {
+			t.Errorf("fail query view: %v", ids)
+		}
+		count := 0
+		for range tree.QueryView(0, 10) {
+			count++
+			if count == 2 {
+				break
+			}
+		}
+		if count != 2 {
+			t.Errorf("fail break out of query view")
+		}
+	}
+}
+
+func TestQueryArrayLength(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100)} {
+		tree.Push(1, 5)
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != ErrArrayLength {
+					t.Errorf("fail panic on unequal length: %v", r)
+				}
+			}()
+			tree.QueryArray([]int{1, 2}, []int{3})
+		}()
+	}
+}
+
+func TestAllGaps(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial()} {
+		tree.PushArray([]int{1, 2, 6, 9, 15, 12}, []int{3, 4, 7, 11, 20, 12})
+		if gaps := tree.AllGaps(); !reflect.DeepEqual(gaps, []Segment{{5, 5}, {8, 8}, {13, 14}}) {
+			t.Errorf("fail all gaps: %v", gaps)
+		}
+		tree.Clear()
+		tree.PushArray([]int{1, 5}, []int{4, 9})
+		if gaps := tree.AllGaps(); len(gaps) != 0 {
+			t.Errorf("fail all gaps of covered span: %v", gaps)
+		}
+	}
+}
+
+func TestSetOverlapFunc(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial()} {
+		tree.PushArray([]int{1, 8, 20, 40}, []int{3, 10, 25, 41})
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		tree.SetOverlapFunc(WithinDistance(4))
+		result := tree.Query(13, 16)
+		sort.Sort(ById(result))
+		if len(result) != 2 || result[0].Id != 1 || result[1].Id != 2 {
+			t.Errorf("fail query within distance: %v", result)
+		}
+		if result := tree.QueryArray([]int{5, 44}, []int{5, 44}); len(result) != 3 {
+			t.Errorf("fail query array within distance: %v", result)
+		}
+		tree.SetOverlapFunc(nil)
+		if result := tree.Query(13, 16); len(result) != 0 {
+			t.Errorf("fail restore default overlap: %v", result)
+		}
+	}
+}
+
+func TestCanonicalNodes(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{1, 4, 6, 9}, []int{4, 8, 9, 12})
+	tree.BuildTree()
+	segments := func(nodes []Node) []Segment {
+		sl := make([]Segment, len(nodes))
+		for i, node := range nodes {
+			sl[i] = node.Segment()
+		}
+		return sl
+	}
+	// leaves: [1,1] [2,3] [4,4] [5,5] [6,6] [7,7] [8,8] [9,9] [10,11] [12,12]
+	if s := segments(tree.CanonicalNodes(2, 11)); !reflect.DeepEqual(s, []Segment{{2, 3}, {4, 6}, {7, 8}, {9, 9}, {10, 11}}) {
+		t.Errorf("fail canonical nodes: %v", s)
+	}
+	if s := segments(tree.CanonicalNodes(0, 20)); !reflect.DeepEqual(s, []Segment{{1, 12}}) {
+		t.Errorf("fail canonical nodes of full range: %v", s)
+	}
+	if s := segments(tree.CanonicalNodes(13, 20)); len(s) != 0 {
+		t.Errorf("fail canonical nodes outside of tree: %v", s)
+	}
+	// every interval stored at a canonical node is a query result
+	for _, node := range tree.CanonicalNodes(5, 7) {
+		for _, intrvl := range node.Overlap() {
+			if intrvl.Disjoint(5, 7) {
+				t.Errorf("fail overlap of canonical node: %v", intrvl)
+			}
+		}
+	}
+}
+
+func TestAccumulatorTree(t *testing.T) {
+	tree := NewAccumulatorTree()
+	if err := tree.BuildTree(); err != ErrNoIntervals {
+		t.Errorf("fail build of empty accumulator tree: %v", err)
+	}
+	values := make([]int, 120)
+	add := func(from, to, delta int) {
+		tree.AddToRange(from, to, delta)
+		for i := from; i <= to; i++ {
+			values[i] += delta
+		}
+	}
+	check := func(msg string) {
+		for i, value := range values {
+			if v := tree.ValueAt(i); v != value {
+				t.Errorf("fail value at %d %s: %d != %d", i, msg, v, value)
+			}
+		}
+	}
+	for i := 0; i < 50; i++ {
+		from := 10 + rand.Intn(90)
+		add(from, from+rand.Intn(10), rand.Intn(21)-10)
+	}
+	tree.BuildTree()
+	check("after build")
+	// aligned with boundaries of the built tree
+	add(10, 10, 5)
+	check("after aligned update")
+	// outside of the built tree
+	add(0, 115, 1)
+	check("after update outside of tree")
+}
+
+func TestQueryDetailed(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{1, 4, 6, 9}, []int{4, 8, 9, 12})
+	tree.BuildTree()
+	detailed := tree.QueryDetailed(5, 7)
+	found := make([]Interval, 0, 10)
+	for _, seg := range detailed {
+		found = append(found, seg.Interval...)
+	}
+	// (4,8) is found at [4,6] and [7,8], (6,9) at [6,6] and [7,8]
+	if len(found) != 4 {
+		t.Errorf("fail intervals of query detailed: %v", detailed)
+	}
+	if !equalIntervals(uniqueIntervals(found), tree.Query(5, 7)) {
+		t.Errorf("fail query detailed equal to query: %v", detailed)
+	}
+}
+
+// uniqueIntervals removes intervals with duplicate Id
+func uniqueIntervals(intervals []Interval) []Interval {
+	seen := make(map[int]bool)
+	unique := make([]Interval, 0, len(intervals))
+	for _, intrvl := range intervals {
+		if !seen[intrvl.Id] {
+			seen[intrvl.Id] = true
+			unique = append(unique, intrvl)
+		}
+	}
+	return unique
+}
+
+func TestSafeTree(t *testing.T) {
+	tree := NewSafeTree(NewTree())
+	tree.BuildTree()
+	if tree.LastError() != ErrNoIntervals {
+		t.Errorf("fail error of build without intervals: %v", tree.LastError())
+	}
+	if result := tree.Query(1, 2); result != nil || tree.LastError() != ErrEmptyTree {
+		t.Errorf("fail error of query on empty tree: %v", tree.LastError())
+	}
+	tree.Push(1, 5)
+	tree.BuildTree()
+	if result := tree.Query(1, 2); len(result) != 1 || tree.LastError() != nil {
+		t.Errorf("fail query after error: %v %v", result, tree.LastError())
+	}
+	tree.QueryArray([]int{1}, nil)
+	if tree.LastError() != ErrArrayLength {
+		t.Errorf("fail error of query array: %v", tree.LastError())
+	}
+	// every query of an empty tree fails with ErrEmptyTree
+	emptyTree := NewTree()
+	empty := NewSafeTree(emptyTree)
+	less := func(a, b Interval) bool { return a.Id < b.Id }
+	for i, query := range []func(){
+		func() { empty.QueryOrdered(1, 2) },
+		func() { empty.QueryFunc(1, 2, func(Interval) bool { return true }) },
+		func() { empty.Depth(1) },
+		func() { empty.Try(func() { emptyTree.Enclosing(1, 2) }) },
+		func() { empty.QueryTopK(1, 2, 3, less) },
+		func() { empty.StabBest(1, LONGEST) },
+		func() { empty.Try(func() { emptyTree.QueryStartsIn(1, 2) }) },
+		func() { empty.Try(func() { emptyTree.QueryEndsIn(1, 2) }) },
+		func() { empty.Try(func() { emptyTree.FlowCounts(1, 2) }) },
+		func() { empty.FullyCovered(1, 2) },
+		func() { empty.QueryRecent(1, 2, 3) },
+		func() { empty.QueryInsertionOrder(1, 2) },
+		func() { empty.QueryMinLength(1, 2, 3) },
+		func() { empty.QueryArrayAll([]int{1}, []int{2}) },
+		func() { empty.QueryArrayAnnotated([]int{1}, []int{2}) },
+		func() { empty.Try(func() { emptyTree.QueryWithGaps(1, 2) }) },
+		func() { empty.Try(func() { emptyTree.QueryRelative(1, 2) }) },
+		func() { empty.Try(func() { emptyTree.CloneEmpty() }) },
+	} {
+		query()
+		if empty.LastError() != ErrEmptyTree {
+			t.Errorf("fail error of query %d on empty tree: %v", i, empty.LastError())
+		}
+	}
+	// a lazy tree fails to build an empty stack
+	lazy := NewSafeTree(NewLazyTree())
+	if lazy.Print(); lazy.LastError() != ErrNoIntervals {
+		t.Errorf("fail error of print of empty lazy tree: %v", lazy.LastError())
+	}
+	// panics that are not of type Error are passed through
+	serial := NewSafeTree(NewSerial())
+	defer func() {
+		if r := recover(); r != "BuildTree() not supported for serial data structure" {
+			t.Errorf("fail pass through of panic: %v", r)
+		}
+	}()
+	serial.BuildTree()
+}
+
+func TestQueryStartsIn(t *testing.T) {
+	tree := NewTree()
+	serial := NewSerial()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(10000)
+		to := from + rand.Intn(100)
+		tree.Push(from, to)
+		serial.Push(from, to)
+	}
+	tree.BuildTree()
+	for i := 0; i < 100; i++ {
+		from := rand.Intn(10100)
+		to := from + rand.Intn(200)
+		if a, b := tree.QueryStartsIn(from, to), serial.(LinearQueries).QueryStartsIn(from, to); !reflect.DeepEqual(a, b) {
+			t.Errorf("fail query starts in (%d, %d): %v != %v", from, to, a, b)
+		}
+		if a, b := tree.QueryEndsIn(from, to), serial.(LinearQueries).QueryEndsIn(from, to); !reflect.DeepEqual(a, b) {
+			t.Errorf("fail query ends in (%d, %d): %v != %v", from, to, a, b)
+		}
+	}
+	// index is rebuilt with tree
+	tree.Clear()
+	tree.PushArray([]int{5, 1, 3}, []int{6, 9, 3})
+	tree.BuildTree()
+	if result := tree.QueryStartsIn(0, 4); len(result) != 2 || result[0].Id != 1 || result[1].Id != 2 {
+		t.Errorf("fail query starts in after rebuild: %v", result)
+	}
+	if result := tree.QueryEndsIn(6, 6); len(result) != 1 || result[0].Id != 0 {
+		t.Errorf("fail query ends in after rebuild: %v", result)
+	}
+	if result := tree.QueryEndsIn(10, 20); len(result) != 0 {
+		t.Errorf("fail query ends in after max: %v", result)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	active := NewTree()
+	active.PushArray([]int{1, 10, 20}, []int{5, 15, 30})
+	active.BuildTree()
+	alerts := NewTree()
+	alerts.PushArray([]int{25, 4, 16, 12}, []int{26, 12, 19, 13})
+	alerts.BuildTree()
+	if pairs := active.Join(alerts); !reflect.DeepEqual(pairs, [][2]int{{0, 1}, {1, 1}, {1, 3}, {2, 0}}) {
+		t.Errorf("fail join: %v", pairs)
+	}
+	if pairs := alerts.Join(active); !reflect.DeepEqual(pairs, [][2]int{{0, 2}, {1, 0}, {1, 1}, {3, 1}}) {
+		t.Errorf("fail reverse join: %v", pairs)
+	}
+	if pairs := active.Join(active); len(pairs) != 3 {
+		t.Errorf("fail self join: %v", pairs)
+	}
+}
+
+func TestPushWithId(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100)} {
+		tree.PushWithId(1000, 1, 5)
+		tree.PushWithId(7, 4, 8)
+		tree.Push(6, 9)
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		result := tree.Query(4, 6)
+		sort.Sort(ById(result))
+		if len(result) != 3 || result[0].Id != 7 || result[1].Id != 1000 || result[2].Id != 1001 {
+			t.Errorf("fail query with given Ids: %v", result)
+		}
+		if result := tree.Query(9, 9); len(result) != 1 || result[0].Id != 1001 {
+			t.Errorf("fail query with given Ids: %v", result)
+		}
+	}
+}
+
+func TestFullyCovered(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial()} {
+		tree.PushArray([]int{1, 6, 3, 12}, []int{4, 9, 5, 20})
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		for _, c := range []struct {
+			from, to int
+			covered  bool
+		}{{1, 9, true}, {2, 2, true}, {1, 10, false}, {11, 12, false}, {12, 20, true},
+			{0, 5, false}, {15, 21, false}, {5, 3, false}, {10, 11, false}} {
+			if covered := tree.FullyCovered(c.from, c.to); covered != c.covered {
+				t.Errorf("fail fully covered (%d, %d): %v", c.from, c.to, covered)
+			}
+		}
+	}
+	circular := NewCircularTree(24)
+	circular.PushArray([]int{20, 2}, []int{3, 6})
+	circular.BuildTree()
+	if !circular.FullyCovered(22, 5) || circular.FullyCovered(6, 21) {
+		t.Errorf("fail fully covered of circular tree")
+	}
+}
+
+func TestBuildTreeWithEndpoints(t *testing.T) {
+	grid := []int{0, 2, 5, 7, 10}
+	tree := NewTree()
+	tree.PushArray([]int{0, 5}, []int{2, 10})
+	tree.BuildTreeWithEndpoints(grid, 0, 10)
+	other := NewTree()
+	other.PushArray([]int{0, 5}, []int{2, 10})
+	other.BuildTree()
+	if !EqualResults(tree, other, []Segment{{0, 0}, {3, 4}, {6, 8}, {11, 12}}) {
+		t.Errorf("fail query of tree with endpoints")
+	}
+	for _, c := range []struct {
+		endpoint []int
+		min, max int
+	}{{[]int{0, 5, 2, 10}, 0, 10}, {[]int{0, 2, 2, 5, 10}, 0, 10}, {[]int{0, 2, 10}, 0, 10}, {grid, 0, 7}} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrInvalidEndpoints {
+					t.Errorf("fail validation of endpoints %v: %v", c.endpoint, r)
+				}
+			}()
+			tree.BuildTreeWithEndpoints(c.endpoint, c.min, c.max)
+		}()
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{1, 4, 6, 9}, []int{4, 8, 9, 12})
+	tree.BuildTree()
+	var nodes NodeCount
+	var depth MaxDepth
+	var entries OverlapEntryCount
+	tree.Aggregate(&nodes, &depth, &entries)
+	array := tree.Tree2Array()
+	count := 0
+	for _, seg := range array {
+		count += len(seg.Interval)
+	}
+	// 10 leaves
+	if nodes.Count != len(array) || nodes.Count != 19 {
+		t.Errorf("fail node count: %d", nodes.Count)
+	}
+	if depth.Depth != 4 {
+		t.Errorf("fail max depth: %d", depth.Depth)
+	}
+	if entries.Count != count {
+		t.Errorf("fail overlap entry count: %d != %d", entries.Count, count)
+	}
+}
+
+func TestQueryMinLength(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100)} {
+		tree.PushArray([]int{1, 3, 4, 2}, []int{10, 3, 6, 5})
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		result := tree.QueryMinLength(3, 4, 2)
+		sort.Sort(ById(result))
+		if len(result) != 3 || result[0].Id != 0 || result[1].Id != 2 || result[2].Id != 3 {
+			t.Errorf("fail query min length: %v", result)
+		}
+		if result := tree.QueryMinLength(3, 4, 0); len(result) != 4 {
+			t.Errorf("fail query min length 0: %v", result)
+		}
+	}
+}
+
+func TestRoot(t *testing.T) {
+	tree := NewTree()
+	if tree.Root() != nil {
+		t.Errorf("fail root of empty tree")
+	}
+	tree.PushArray([]int{1, 4}, []int{4, 8})
+	tree.BuildTree()
+	root := tree.Root()
+	if root == nil || root.Segment() != (Segment{1, 8}) {
+		t.Errorf("fail root of tree: %v", root)
+	}
+	if !reflect.DeepEqual(Tree2Array(root), tree.Tree2Array()) {
+		t.Errorf("fail tree to array of root")
+	}
+}
+
+func TestProjectedTree(t *testing.T) {
+	// IPv4 addresses as uint32
+	ip := func(a, b, c, d uint32) uint32 { return a<<24 | b<<16 | c<<8 | d }
+	tree := NewProjectedTree(func(addr uint32) int { return int(addr) })
+	tree.Push(ip(10, 0, 0, 0), ip(10, 255, 255, 255))
+	tree.Push(ip(192, 168, 0, 0), ip(192, 168, 255, 255))
+	tree.Push(ip(192, 168, 1, 0), ip(192, 168, 1, 255))
+	tree.BuildTree()
+	result := tree.Query(ip(192, 168, 1, 20), ip(192, 168, 1, 20))
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	if len(result) != 2 || result[0].Lo != ip(192, 168, 0, 0) || result[1].Hi != ip(192, 168, 1, 255) {
+		t.Errorf("fail projected query: %v", result)
+	}
+	if result := tree.Query(ip(11, 0, 0, 0), ip(12, 0, 0, 0)); len(result) != 0 {
+		t.Errorf("fail projected query between intervals: %v", result)
+	}
+}
+
+func TestIdTree(t *testing.T) {
+	type id struct {
+		rule    string
+		version int
+	}
+	tree := NewIdTree[id]()
+	tree.Push(id{"a", 1}, 0, 10)
+	tree.Push(id{"b", 1}, 5, 15)
+	tree.Push(id{"a", 1}, 20, 30)
+	tree.BuildTree()
+	result := tree.QueryArray([]int{8, 25}, []int{8, 25})
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	if len(result) != 2 || result[0].ID != (id{"a", 1}) || result[1].ID != (id{"b", 1}) {
+		t.Errorf("fail query id tree: %v", result)
+	}
+	if result := tree.Query(25, 25); len(result) != 1 || result[0].ID.rule != "a" {
+		t.Errorf("fail query id tree: %v", result)
+	}
+	if count := tree.Count(0, 30); count != 2 {
+		t.Errorf("fail count id tree: %d", count)
+	}
+	if result := tree.Stab(12); len(result) != 1 || result[0].ID.rule != "b" {
+		t.Errorf("fail stab id tree: %v", result)
+	}
+	for intrvl := range tree.QueryView(25, 30) {
+		if intrvl.ID != (id{"a", 1}) {
+			t.Errorf("fail query view id tree: %v", intrvl)
+		}
+	}
+	tree.Push(id{"b", 1}, 10, 20)
+	tree.BuildTree()
+	if result := tree.Stab(12); len(result) != 1 || len(tree.Query(12, 12)) != 1 {
+		t.Errorf("fail stab id tree with merged ID: %v", result)
+	}
+	if !tree.Remove(id{"a", 1}) || tree.Remove(id{"a", 1}) || tree.Remove(id{"c", 1}) || tree.Len() != 2 {
+		t.Errorf("fail remove id tree: %d intervals", tree.Len())
+	}
+	if result := tree.Query(0, 30); len(result) != 1 || result[0].ID.rule != "b" {
+		t.Errorf("fail query id tree after remove: %v", result)
+	}
+}
+
+func TestEnclosing(t *testing.T) {
+	tree := NewTree()
+	serial := NewSerial()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(10000)
+		to := from + rand.Intn(500)
+		tree.Push(from, to)
+		serial.Push(from, to)
+	}
+	tree.PushArray([]int{2000}, []int{2100})
+	serial.PushArray([]int{2000}, []int{2100})
+	tree.BuildTree()
+	for _, probe := range []Segment{{2000, 2100}, {2050, 2060}, {-5, 3}, {9990, 10600}} {
+		a, b := tree.Enclosing(probe.From, probe.To), serial.(LinearQueries).Enclosing(probe.From, probe.To)
+		sort.Sort(ById(a))
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("fail enclosing %v: %v != %v", probe, a, b)
+		}
+	}
+	// equal bounds enclose
+	if result := tree.Enclosing(2000, 2100); !containsId(result, 1000) {
+		t.Errorf("fail enclosing equal interval: %v", result)
+	}
+	if result := tree.Enclosing(10, 5); len(result) != 0 {
+		t.Errorf("fail enclosing with from > to: %v", result)
+	}
+}
+
+func containsId(intervals []Interval, id int) bool {
+	for _, intrvl := range intervals {
+		if intrvl.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGenerateIntervals(t *testing.T) {
+	for _, dist := range []Distribution{UNIFORM, CLUSTERED} {
+		from, to := GenerateIntervals(1000, 10000, 42, dist)
+		if len(from) != 1000 || len(to) != 1000 {
+			t.Fatalf("fail number of intervals: %d %d", len(from), len(to))
+		}
+		for i := range from {
+			if from[i] < 0 || from[i] > to[i] || to[i] > 10000 {
+				t.Errorf("fail interval (%d,%d)", from[i], to[i])
+			}
+		}
+		again, _ := GenerateIntervals(1000, 10000, 42, dist)
+		if !reflect.DeepEqual(from, again) {
+			t.Errorf("fail generate same intervals with same seed")
+		}
+	}
+	defer func() {
+		if r := recover(); r != ErrUnknownDistribution {
+			t.Errorf("fail panic on unknown distribution: %v", r)
+		}
+	}()
+	GenerateIntervals(10, 100, 42, CLUSTERED+1)
+}
+
+func BenchmarkQueryClustered(b *testing.B) {
+	tree := NewTree()
+	tree.PushArray(GenerateIntervals(100000, 10000000, 1, CLUSTERED))
+	tree.BuildTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Query(0, 1000000)
+	}
+}
+
+func TestQueryOrdered(t *testing.T) {
+	tree := NewTree()
+	serial := NewSerial()
+	from, to := GenerateIntervals(1000, 10000, 3, CLUSTERED)
+	tree.PushArray(from, to)
+	serial.PushArray(from, to)
+	tree.BuildTree()
+	for i := 0; i < 100; i++ {
+		qfrom := rand.Intn(10000)
+		qto := qfrom + rand.Intn(1000)
+		if a, b := tree.QueryOrdered(qfrom, qto), serial.QueryOrdered(qfrom, qto); !reflect.DeepEqual(a, b) {
+			t.Errorf("fail query ordered (%d, %d)", qfrom, qto)
+		}
+	}
+	// sparse Ids fall back to map
+	tree.Clear()
+	tree.PushWithId(10, 1, 5)
+	tree.Push(2, 3)
+	tree.BuildTree()
+	if result := tree.QueryOrdered(1, 5); len(result) != 2 || result[0].Id != 10 || result[1].Id != 11 {
+		t.Errorf("fail query ordered with sparse Ids: %v", result)
+	}
+}
+
+func BenchmarkQueryOrdered(b *testing.B) {
+	tree := NewTree()
+	tree.PushArray(GenerateIntervals(100000, 10000000, 1, CLUSTERED))
+	tree.BuildTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.QueryOrdered(0, 1000000)
+	}
+}
+
+func TestMergedSegments(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial()} {
+		tree.PushArray([]int{1, 5, 11, 14, 20, 12}, []int{5, 9, 12, 15, 20, 12})
+		expected := []Segment{{1, 9}, {11, 12}, {14, 15}, {20, 20}}
+		if merged := tree.MergedSegments(); !reflect.DeepEqual(merged, expected) {
+			t.Errorf("fail merged segments: %v", merged)
+		}
+	}
+}
+
+func TestQueryOutside(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{10, 15}, []int{20, 30})
+	tree.BuildTree()
+	if result := tree.Query(0, 9); result == nil || len(result) != 0 {
+		t.Errorf("fail query below min: %v", result)
+	}
+	if result := tree.QueryArray([]int{0, 31}, []int{9, 40}); result == nil || len(result) != 0 {
+		t.Errorf("fail query array outside: %v", result)
+	}
+	if result := tree.QueryArray([]int{0, 30}, []int{9, 40}); len(result) != 1 {
+		t.Errorf("fail query array partially outside: %v", result)
+	}
+	// custom overlap matches beyond max
+	tree.SetOverlapFunc(WithinDistance(5))
+	if result := tree.Query(33, 35); len(result) != 1 {
+		t.Errorf("fail query outside with custom overlap: %v", result)
+	}
+}
+
+func TestQueryRecent(t *testing.T) {
+	tree := NewTree()
+	serial := NewSerial()
+	from, to := GenerateIntervals(1000, 10000, 5, UNIFORM)
+	tree.PushArray(from, to)
+	serial.PushArray(from, to)
+	tree.BuildTree()
+	for _, n := range []int{0, 1, 5, 100, 2000} {
+		a, b := tree.QueryRecent(2000, 3000, n), serial.QueryRecent(2000, 3000, n)
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("fail query recent %d: %v != %v", n, a, b)
+		}
+	}
+	// Ids given in any order
+	tree.Clear()
+	tree.PushWithId(3, 1, 10)
+	tree.PushWithId(9, 2, 5)
+	tree.PushWithId(1, 4, 8)
+	tree.BuildTree()
+	if result := tree.QueryRecent(4, 5, 2); len(result) != 2 || result[0].Id != 9 || result[1].Id != 3 {
+		t.Errorf("fail query recent with given Ids: %v", result)
+	}
+}
+
+func TestHasOverlaps(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial()} {
+		tree.PushArray([]int{10, 1, 6}, []int{12, 5, 9})
+		if tree.HasOverlaps() {
+			t.Errorf("fail has overlaps of disjoint intervals")
+		}
+		tree.Push(9, 9)
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		if !tree.HasOverlaps() {
+			t.Errorf("fail has overlaps of touching intervals")
+		}
+	}
+}
+
+func TestEmptyResults(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100)} {
+		tree.PushArray([]int{10, 1}, []int{20, 1})
+		if _, ok := tree.(*serial); !ok {
+			tree.BuildTree()
+		}
+		for name, result := range map[string][]Interval{
+			"Query":          tree.Query(50, 60),
+			"QueryHint":      tree.QueryHint(5, 6, 10),
+			"QueryArray":     tree.QueryArray([]int{50}, []int{60}),
+			"QueryOrdered":   tree.QueryOrdered(5, 6),
+			"QueryMinLength": tree.QueryMinLength(1, 1, 5),
+			"QueryRecent":    tree.QueryRecent(5, 6, 2),
+		} {
+			if data, _ := json.Marshal(result); result == nil || string(data) != "[]" {
+				t.Errorf("fail empty result of %s: %s", name, data)
+			}
+		}
+	}
+	tree := NewTree()
+	tree.Push(1, 1)
+	tree.Push(5, 6)
+	tree.BuildTree()
+	array := tree.Tree2Array()
+	var fromJson, fromGob []SegmentOverlap
+	data, _ := json.Marshal(array)
+	if err := json.Unmarshal(data, &fromJson); err != nil || !reflect.DeepEqual(array, fromJson) {
+		t.Errorf("fail json round trip: %v", fromJson)
+	}
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(array)
+	if err := gob.NewDecoder(&buf).Decode(&fromGob); err != nil || !reflect.DeepEqual(array, fromGob) {
+		t.Errorf("fail gob round trip: %v", fromGob)
+	}
+}
+
+func TestQueryTopK(t *testing.T) {
+	tree := NewTree()
+	serial := NewSerial()
+	from, to := GenerateIntervals(1000, 10000, 6, CLUSTERED)
+	tree.PushArray(from, to)
+	serial.PushArray(from, to)
+	tree.BuildTree()
+	// longest first, ties by lower Id
+	longer := func(a, b Interval) bool {
+		if a.To-a.From != b.To-b.From {
+			return a.To-a.From < b.To-b.From
+		}
+		return a.Id > b.Id
+	}
+	for _, k := range []int{0, 1, 10, 2000} {
+		a, b := tree.QueryTopK(0, 5000, k, longer), serial.QueryTopK(0, 5000, k, longer)
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("fail query top %d: %v != %v", k, a, b)
+		}
+	}
+}
+
+func TestLayerCount(t *testing.T) {
+	tree := NewTree()
+	from, to := GenerateIntervals(500, 10000, 7, CLUSTERED)
+	tree.PushArray(from, to)
+	tree.Push(20000, 20000)
+	tree.Push(20000, 20005)
+	tree.BuildTree()
+	for _, q := range [][2]int{{0, 10000}, {2000, 2100}, {5000, 5000}, {20000, 20000}, {30000, 40000}} {
+		if n, layers := tree.LayerCount(q[0], q[1]), tree.QueryLayered(q[0], q[1]); n != len(layers) {
+			t.Errorf("fail layer count %v: %d != %d", q, n, len(layers))
+		}
+	}
+	if n := tree.LayerCount(20000, 20000); n != 2 {
+		t.Errorf("fail layer count of touching intervals: %d", n)
+	}
+}
+
+func TestTree2Seq(t *testing.T) {
+	tree := NewTree()
+	from, to := GenerateIntervals(200, 1000, 8, UNIFORM)
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	array := make([]SegmentOverlap, 0, 10)
+	for seg := range tree.Tree2Seq() {
+		array = append(array, seg)
+	}
+	if !reflect.DeepEqual(array, tree.Tree2Array()) {
+		t.Errorf("fail tree to sequence: %v", array)
+	}
+	n := 0
+	for range tree.Tree2Seq() {
+		if n++; n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("fail break tree to sequence: %d", n)
+	}
+}
+
+func TestPointIntervalCount(t *testing.T) {
+	for _, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100)} {
+		tree.Push(1, 5)
+		tree.Push(7, 7)
+		tree.PushWithId(10, 3, 3)
+		tree.PushArray([]int{4, 8}, []int{4, 9})
+		tree.PushKey(99, 99, "p")
+		if n := tree.PointIntervalCount(); n != 4 {
+			t.Errorf("fail point interval count: %d", n)
+		}
+		tree.Clear()
+		if n := tree.PointIntervalCount(); n != 0 {
+			t.Errorf("fail point interval count after clear: %d", n)
+		}
+	}
+}
+
+func TestQueryWithGaps(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{2, 4, 10, 12, 20}, []int{5, 6, 10, 15, 30})
+	tree.BuildTree()
+	result, gaps := tree.QueryWithGaps(3, 25)
+	expected := []Interval{{Id: 0, Segment: Segment{3, 5}}, {Id: 1, Segment: Segment{4, 6}}, {Id: 2, Segment: Segment{10, 10}}, {Id: 3, Segment: Segment{12, 15}}, {Id: 4, Segment: Segment{20, 25}}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("fail query with gaps, result: %v", result)
+	}
+	if expectedGaps := []Segment{{7, 9}, {11, 11}, {16, 19}}; !reflect.DeepEqual(gaps, expectedGaps) {
+		t.Errorf("fail query with gaps, gaps: %v", gaps)
+	}
+	if _, gaps := tree.QueryWithGaps(0, 40); !reflect.DeepEqual(gaps, []Segment{{0, 1}, {7, 9}, {11, 11}, {16, 19}, {31, 40}}) {
+		t.Errorf("fail query with gaps at borders: %v", gaps)
+	}
+	if result, gaps := tree.QueryWithGaps(50, 60); len(result) != 0 || !reflect.DeepEqual(gaps, []Segment{{50, 60}}) {
+		t.Errorf("fail query with gaps outside tree: %v %v", result, gaps)
+	}
+	if _, gaps := ClipWithGaps([]Interval{{Segment: Segment{0, math.MaxInt}}}, math.MaxInt-1, math.MaxInt); len(gaps) != 0 {
+		t.Errorf("fail query with gaps at max int: %v", gaps)
+	}
+}
+
+func TestRestoreOverlaps(t *testing.T) {
+	endpoint := []int{0, 10, 20, 30, 40}
+	tree := NewTree()
+	tree.PushArray([]int{0, 10, 20}, []int{20, 30, 40})
+	tree.BuildTreeWithEndpoints(endpoint, 0, 40)
+	a := tree.SnapshotOverlaps()
+	resultA := tree.Query(15, 15)
+	sort.Sort(ById(resultA))
+	tree.Clear()
+	tree.PushKey(0, 40, "all")
+	tree.PushWithId(5, 30, 30)
+	tree.BuildTreeWithEndpoints(endpoint, 0, 40)
+	b := tree.SnapshotOverlaps()
+	resultB := tree.Query(15, 30)
+	sort.Sort(ById(resultB))
+	tree.Remove(5)
+	tree.RestoreOverlaps(a)
+	result := tree.Query(15, 15)
+	if sort.Sort(ById(result)); !reflect.DeepEqual(result, resultA) {
+		t.Errorf("fail restore overlaps: %v != %v", result, resultA)
+	}
+	if ratio := tree.RemovedRatio(); ratio != 0 {
+		t.Errorf("fail restore overlaps, removed ratio %v", ratio)
+	}
+	if _, ok := tree.GetByKey("all"); ok {
+		t.Errorf("fail restore overlaps, key of other snapshot found")
+	}
+	tree.RestoreOverlaps(b)
+	result = tree.Query(15, 30)
+	if sort.Sort(ById(result)); !reflect.DeepEqual(result, resultB) {
+		t.Errorf("fail restore overlaps: %v != %v", result, resultB)
+	}
+	if intrvl, ok := tree.GetByKey("all"); !ok || intrvl.Id != 0 {
+		t.Errorf("fail restore overlaps, key not found")
+	}
+	other := NewTree()
+	other.PushArray([]int{0, 10}, []int{20, 30})
+	other.BuildTree()
+	if err := NewSafeTree(other).Try(func() { other.RestoreOverlaps(a) }); err != ErrStateMismatch {
+		t.Errorf("fail restore overlaps of different structure: %v", err)
+	}
+	// a short Overlap and an unknown Id don't match
+	safe := NewSafeTree(tree)
+	short := b
+	short.Overlap = b.Overlap[:len(b.Overlap)-1]
+	unknown := b
+	unknown.Overlap = slices.Clone(b.Overlap)
+	unknown.Overlap[0] = []int{99}
+	for _, state := range []OverlapState{short, unknown} {
+		if err := safe.Try(func() { tree.RestoreOverlaps(state) }); err != ErrStateMismatch {
+			t.Errorf("fail restore invalid overlaps: %v", err)
+		}
+	}
+	if result = tree.Query(15, 30); len(result) != len(resultB) {
+		t.Errorf("fail tree changed by invalid restore: %v", result)
+	}
+}
+
+func TestQueryArrayAll(t *testing.T) {
+	from := []int{0, 5, 10, 20}
+	to := []int{30, 12, 15, 25}
+	for i, tree := range []Tree{NewSerial(), NewTree(), NewCircularTree(100)} {
+		tree.PushArray(from, to)
+		if i > 0 {
+			tree.BuildTree()
+		}
+		result := tree.QueryArrayAll([]int{11, 21}, []int{11, 21})
+		if len(result) != 1 || result[0].Id != 0 {
+			t.Errorf("fail query array all: %v", result)
+		}
+		result = tree.QueryArrayAll([]int{6, 11}, []int{6, 11})
+		sort.Sort(ById(result))
+		if len(result) != 2 || result[0].Id != 0 || result[1].Id != 1 {
+			t.Errorf("fail query array all: %v", result)
+		}
+		if result := tree.QueryArrayAll([]int{0, 50}, []int{1, 60}); len(result) != 0 {
+			t.Errorf("fail query array all without common intervals: %v", result)
+		}
+		// union of overlapping queries holds every interval once
+		if result := tree.QueryArray([]int{6, 14}, []int{6, 14}); len(result) != 3 {
+			t.Errorf("fail query array: %v", result)
+		}
+	}
+}
+
+func TestFlatTree(t *testing.T) {
+	tree := NewTree()
+	from, to := GenerateIntervals(2000, 100000, 10, CLUSTERED)
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	var buf bytes.Buffer
+	if err := WriteFlat(&buf, tree.Root()); err != nil {
+		t.Fatalf("fail write flat: %v", err)
+	}
+	flat, err := NewFlatTree(buf.Bytes())
+	if err != nil {
+		t.Fatalf("fail new flat tree: %v", err)
+	}
+	for _, q := range [][2]int{{0, 100000}, {500, 600}, {42, 42}, {-10, -1}, {200000, 300000}} {
+		a, b := tree.Query(q[0], q[1]), flat.Query(q[0], q[1])
+		if !equalIntervals(a, b) {
+			t.Errorf("fail query flat tree %v: %d != %d results", q, len(b), len(a))
+		}
+	}
+	a, b := tree.QueryArray([]int{10, 50000}, []int{20000, 60000}), flat.QueryArray([]int{10, 50000}, []int{20000, 60000})
+	if !equalIntervals(a, b) {
+		t.Errorf("fail query array flat tree: %d != %d results", len(b), len(a))
+	}
+	n := 0
+	for range flat.QueryView(0, 100000) {
+		if n++; n == 5 {
+			break
+		}
+	}
+	if n != 5 {
+		t.Errorf("fail break query view of flat tree: %d", n)
+	}
+	if _, err := NewFlatTree(buf.Bytes()[:buf.Len()-8]); err != ErrInvalidFlat {
+		t.Errorf("fail truncated flat tree: %v", err)
+	}
+	if count := flat.Count(500, 600); count != len(tree.Query(500, 600)) {
+		t.Errorf("fail count flat tree: %d", count)
+	}
+	if !equalIntervals(tree.Stab(42), flat.Stab(42)) {
+		t.Errorf("fail stab flat tree")
+	}
+	// corrupt sections: a child referencing the root, an overlap entry
+	// beyond the intervals
+	intervals := int(binary.LittleEndian.Uint64(buf.Bytes()[16:]))
+	nodes := (flatHeader + intervals*flatInterval) * 8
+	entries := len(buf.Bytes()) - 8
+	for _, corrupt := range []struct{ offset, value int }{{nodes + 2*8, 0}, {entries, intervals}} {
+		data := bytes.Clone(buf.Bytes())
+		binary.LittleEndian.PutUint64(data[corrupt.offset:], uint64(corrupt.value))
+		if _, err := NewFlatTree(data); err != ErrInvalidFlat {
+			t.Errorf("fail corrupt flat tree at %d: %v", corrupt.offset, err)
+		}
+	}
+	if err := WriteFlat(&buf, NewTree().Root()); err != ErrEmptyTree {
+		t.Errorf("fail write flat of empty tree: %v", err)
+	}
+}
+
+func TestOverlapDegrees(t *testing.T) {
+	from, to := GenerateIntervals(300, 2000, 11, CLUSTERED)
+	tree := NewTree()
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	degrees := tree.OverlapDegrees()
+	if len(degrees) != 300 {
+		t.Errorf("fail overlap degrees: %d intervals", len(degrees))
+	}
+	for i := range from {
+		if expected := len(tree.Query(from[i], to[i])) - 1; degrees[i] != expected {
+			t.Errorf("fail overlap degree of %d: %d != %d", i, degrees[i], expected)
+		}
+	}
+}
+
+func TestNewTreeOrdered(t *testing.T) {
+	from, to := GenerateIntervals(500, 1000, 12, UNIFORM)
+	byId := func(a, b Interval) bool { return a.Id < b.Id }
+	for i, tree := range []Tree{NewSerialOrdered(byId), NewTreeOrdered(byId)} {
+		tree.PushArray(from, to)
+		if i > 0 {
+			tree.BuildTree()
+		}
+		if result := tree.Query(0, 500); !sort.IsSorted(ById(result)) || len(result) == 0 {
+			t.Errorf("fail ordered query: %v", result)
+		}
+		if result := tree.QueryArray([]int{0, 600}, []int{10, 700}); !sort.IsSorted(ById(result)) || len(result) == 0 {
+			t.Errorf("fail ordered query array: %v", result)
+		}
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	from, to := GenerateIntervals(1000, 10000, 14, UNIFORM)
+	ids := rand.New(rand.NewSource(14)).Perm(len(from))
+	trees := []Tree{NewSerial(), NewTree(), NewIntervalTree(), NewTreeOrdered(func(a, b Interval) bool { return a.Id < b.Id })}
+	for i, tree := range trees {
+		// Ids don't follow the order intervals are pushed in
+		for j := range from {
+			tree.PushWithId(ids[j], from[j], to[j])
+		}
+		if i > 0 {
+			tree.BuildTree()
+		}
+	}
+	expected := trees[0].QueryInsertionOrder(2000, 4000)
+	if !sort.IsSorted(ById(expected)) || len(expected) == 0 {
+		t.Fatalf("fail insertion order of serial: %v", expected)
+	}
+	for i, tree := range trees {
+		// the same query returns the same order, sparse Ids are deduplicated
+		query := tree.Query(2000, 4000)
+		array := tree.QueryArray([]int{2000, 3000, 6000}, []int{2500, 4000, 7000})
+		for run := 0; run < 2; run++ {
+			result := tree.QueryInsertionOrder(2000, 4000)
+			if i == 3 {
+				result = tree.Query(2000, 4000)
+			}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("fail order of tree %d in run %d", i, run)
+			}
+			if result := tree.Query(2000, 4000); !reflect.DeepEqual(result, query) {
+				t.Errorf("fail query order of tree %d in run %d", i, run)
+			}
+			if result := tree.QueryArray([]int{2000, 3000, 6000}, []int{2500, 4000, 7000}); !reflect.DeepEqual(result, array) {
+				t.Errorf("fail query array order of tree %d in run %d", i, run)
+			}
+		}
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	from, to := GenerateIntervals(100, 1000, 15, UNIFORM)
+	for i, tree := range []Tree{NewSerial(), NewTree(), NewIntervalTree(), NewCircularTree(1001)} {
+		tree.PushArray(from, to)
+		tree.PushKey(5, 5, "point")
+		if i > 0 {
+			tree.BuildTree()
+		}
+		intervals := tree.Intervals()
+		if len(intervals) != len(from)+1 || intervals[len(from)].Key != "point" {
+			t.Fatalf("fail intervals of tree %d: %d intervals", i, len(intervals))
+		}
+		for j := range from {
+			if intervals[j] != (Interval{Id: j, Segment: Segment{from[j], to[j]}}) {
+				t.Errorf("fail interval %d of tree %d: %v", j, i, intervals[j])
+			}
+		}
+		// a copy, the stack is not changed
+		intervals[0].From = -1
+		if tree.Intervals()[0].From != from[0] {
+			t.Errorf("fail copy of intervals of tree %d", i)
+		}
+	}
+	halfOpen := NewTreeHalfOpen()
+	halfOpen.Push(1, 3)
+	if intervals := halfOpen.Intervals(); len(intervals) != 1 || intervals[0].Segment != (Segment{1, 3}) {
+		t.Errorf("fail intervals of half-open tree: %v", intervals)
+	}
+}
+
+func TestLenBuilt(t *testing.T) {
+	for i, tree := range []Tree{NewTree(), NewSerial(), NewIntervalTree(), NewCircularTree(100)} {
+		if tree.Len() != 0 || tree.Built() != (i == 1) {
+			t.Errorf("fail len and built of new tree %d", i)
+		}
+		tree.PushWithId(10, 1, 5)
+		tree.Push(90, 10)
+		if tree.Len() != 2 {
+			t.Errorf("fail len of tree %d: %d", i, tree.Len())
+		}
+		if i != 1 {
+			tree.BuildTree()
+		}
+		if !tree.Built() {
+			t.Errorf("fail built of tree %d", i)
+		}
+		tree.Clear()
+		if tree.Len() != 0 || tree.Built() != (i == 1) {
+			t.Errorf("fail len and built of cleared tree %d", i)
+		}
+	}
+}
+
+func TestVisitedSet(t *testing.T) {
+	for _, visited := range []visitedSet{make(visitedBits, 2), make(visitedMap)} {
+		for _, id := range []int{0, 63, 64, 127} {
+			if !visited.visit(id) || visited.visit(id) {
+				t.Errorf("fail visit %d of %T", id, visited)
+			}
+		}
+	}
+	// dense Ids of PushWithId are deduplicated with a bitset
+	tree := NewTree().(*stree)
+	tree.PushWithId(3, 1, 5)
+	tree.PushWithId(1, 2, 9)
+	tree.PushWithId(0, 4, 4)
+	if _, ok := tree.newVisited().(visitedBits); !ok {
+		t.Errorf("fail visited set of dense Ids")
+	}
+	tree.PushWithId(-1, 3, 8)
+	if _, ok := tree.newVisited().(visitedMap); !ok {
+		t.Errorf("fail visited set of negative Id")
+	}
+	tree.BuildTree()
+	if count := tree.Count(3, 4); count != 4 {
+		t.Errorf("fail count with negative Id: %d", count)
+	}
+	if result := tree.QueryOrdered(3, 4); len(result) != 4 || result[0].Id != 3 {
+		t.Errorf("fail ordered query with negative Id: %v", result)
+	}
+	tree.Clear()
+	tree.PushWithId(1000, 1, 5)
+	if _, ok := tree.newVisited().(visitedMap); !ok {
+		t.Errorf("fail visited set of sparse Ids")
+	}
+}
+
+func TestPushIntervals(t *testing.T) {
+	intervals := []Interval{
+		{Id: 1042, Segment: Segment{1, 5}, Key: "a"},
+		{Id: 7, Segment: Segment{3, 9}},
+		{Id: 300, Segment: Segment{8, 9}, Key: "b"},
+	}
+	for i, tree := range []Tree{NewSerial(), NewTree(), NewIntervalTree(), NewCircularTree(100), NewTreeHalfOpen()} {
+		tree.PushIntervals(intervals)
+		tree.Push(20, 30)
+		if i != 0 {
+			tree.BuildTree()
+		}
+		result := tree.QueryInsertionOrder(0, 10)
+		if !reflect.DeepEqual(result, []Interval{intervals[1], intervals[2], intervals[0]}) {
+			t.Errorf("fail query of tree %d: %v", i, result)
+		}
+		if intrvl, ok := tree.GetByKey("b"); !ok || intrvl != intervals[2] {
+			t.Errorf("fail key of tree %d: %v", i, intrvl)
+		}
+		// Push continues after the highest Id
+		if result := tree.Query(20, 25); len(result) != 1 || result[0].Id != 1043 {
+			t.Errorf("fail Id after push intervals of tree %d: %v", i, result)
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	from, to := GenerateIntervals(2000, 100000, 17, UNIFORM)
+	a, b, single := NewTree(), NewSerial(), NewTree()
+	a.PushArray(from[:1000], to[:1000])
+	b.PushWithId(5000, from[1000], to[1000])
+	b.PushArray(from[1001:], to[1001:])
+	a.PushKey(1, 2, "a")
+	b.PushKey(3, 4, "b")
+	single.PushArray(from[:1000], to[:1000])
+	single.PushKey(1, 2, "a")
+	single.PushArray(from[1000:], to[1000:])
+	single.PushKey(3, 4, "b")
+	union := Union(a, b)
+	if union.Built() || union.Len() != 2002 {
+		t.Errorf("fail union: built %v, len %d", union.Built(), union.Len())
+	}
+	if err := union.BuildTree(); err != nil {
+		t.Fatalf("fail build union: %v", err)
+	}
+	single.BuildTree()
+	a.BuildTree()
+	for _, query := range [][2]int{{0, 100000}, {20000, 20100}, {50000, 50000}, {-5, -1}} {
+		if len(union.Query(query[0], query[1])) != len(a.Query(query[0], query[1]))+len(b.Query(query[0], query[1])) {
+			t.Errorf("fail union query (%d, %d)", query[0], query[1])
+		}
+	}
+	queries := make([]Segment, 0, 100)
+	for i := 0; i < 100; i++ {
+		queries = append(queries, Segment{i * 1000, i*1000 + 500})
+	}
+	// Ids are assigned in the order of the trees, a single tree gets the same
+	if !EqualResults(union, single, queries) {
+		t.Errorf("fail union differs from a single tree of all intervals")
+	}
+	if intrvl, ok := union.GetByKey("b"); !ok || intrvl.Id != 2001 {
+		t.Errorf("fail key of union: %v", intrvl)
+	}
+}
+
+func TestFlowCounts(t *testing.T) {
+	from, to := GenerateIntervals(1000, 5000, 13, CLUSTERED)
+	for i, tree := range []Tree{NewSerial(), NewTree()} {
+		tree.PushArray(from, to)
+		if i > 0 {
+			tree.BuildTree()
+		}
+		for _, q := range [][2]int{{0, 5000}, {100, 200}, {300, 300}, {50, 10}} {
+			starts, ends := tree.(LinearQueries).FlowCounts(q[0], q[1])
+			if starts != len(tree.(LinearQueries).QueryStartsIn(q[0], q[1])) || ends != len(tree.(LinearQueries).QueryEndsIn(q[0], q[1])) {
+				t.Errorf("fail flow counts %v: %d, %d", q, starts, ends)
+			}
+		}
+	}
+}
+
+func TestInsert(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{0, 10, 20}, []int{20, 30, 40})
+	tree.BuildTree()
+	before := tree.Root()
+	// endpoints of the grid, inserted into the nodes
+	tree.Insert(10, 20)
+	tree.Insert(20, 20)
+	if tree.Root() != before {
+		t.Errorf("fail insert, tree rebuilt")
+	}
+	// not a boundary of a leaf, the tree is rebuilt
+	tree.Insert(5, 25)
+	if tree.Root() == before {
+		t.Errorf("fail insert, tree not rebuilt")
+	}
+	other := NewTree()
+	other.PushArray([]int{0, 10, 20, 10, 20, 5}, []int{20, 30, 40, 20, 20, 25})
+	other.BuildTree()
+	if !EqualResults(tree, other, []Segment{{0, 40}, {12, 12}, {20, 20}, {21, 29}, {33, 50}}) {
+		t.Errorf("fail insert, results differ")
+	}
+	if starts := tree.QueryStartsIn(10, 20); len(starts) != 4 {
+		t.Errorf("fail insert, endpoint index not updated: %v", starts)
+	}
+}
+
+func TestEndpointIndexUpdate(t *testing.T) {
+	tree := NewTree().(*stree)
+	tree.PushArray([]int{0, 10, 20, 0}, []int{20, 30, 40, 40})
+	tree.BuildTree()
+	tree.Insert(10, 20)
+	tree.Insert(0, 20)
+	tree.Remove(1)
+	tree.Insert(20, 30)
+	tree.Remove(5)
+	tree.Remove(0)
+	if expected := NewEndpointIndex(tree.base); !reflect.DeepEqual(tree.index, expected) {
+		t.Errorf("fail endpoint index update: %v, expected %v", tree.index, expected)
+	}
+	// pushed intervals are missing in the index, a removal doesn't update it
+	tree.Push(5, 10)
+	tree.Remove(2)
+	if tree.index.Len() != 0 || !tree.HasOverlaps() {
+		t.Errorf("fail endpoint index update, stale index used")
+	}
+}
+
+func TestCloneEmpty(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{0, 10, 20}, []int{20, 30, 40})
+	tree.BuildTree()
+	clone := tree.CloneEmpty()
+	for _, seg := range clone.Tree2Array() {
+		if seg.Interval != nil {
+			t.Errorf("fail clone empty, node %v has intervals", seg.Segment)
+		}
+	}
+	clone.Insert(20, 30)
+	clone.Insert(0, 10)
+	other := NewTree()
+	other.PushArray([]int{20, 0}, []int{30, 10})
+	other.BuildTreeWithEndpoints([]int{0, 10, 20, 30, 40}, 0, 40)
+	if !Equal(clone, other) {
+		t.Errorf("fail clone empty, insert into clone")
+	}
+	if result := tree.Query(25, 25); len(result) != 2 {
+		t.Errorf("fail clone empty, original modified: %v", result)
+	}
+}
+
+func TestQueryRelative(t *testing.T) {
+	tree := NewTreeOrdered(func(a, b Interval) bool { return a.Id < b.Id })
+	tree.PushArray([]int{0, 12, 15, 30}, []int{11, 14, 40, 35})
+	tree.BuildTree()
+	expected := []Segment{{0, 1}, {2, 4}, {5, 10}}
+	if result := tree.QueryRelative(10, 20); !reflect.DeepEqual(result, expected) {
+		t.Errorf("fail query relative: %v", result)
+	}
+}
+
+func TestQueryUnique(t *testing.T) {
+	from, to := GenerateIntervals(2000, 10000, 14, CLUSTERED)
+	tree := NewTree().(*stree)
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	duplicates := false
+	for _, q := range [][2]int{{0, 10000}, {100, 3000}, {4000, 4000}, {9000, 20000}} {
+		// intervals are found at several nodes by one query
+		raw := make([]Interval, 0, 10)
+		for _, seg := range tree.QueryDetailed(q[0], q[1]) {
+			raw = append(raw, seg.Interval...)
+		}
+		deduped := make([]Interval, 0, 10)
+		querySingle(tree.root, q[0], q[1], Overlaps, tree.newVisited(), &deduped)
+		duplicates = duplicates || len(raw) > len(deduped)
+		// but only one of these nodes contains max(From, from)
+		result := tree.Query(q[0], q[1])
+		if len(result) != len(deduped) || len(uniqueIntervals(result)) != len(result) {
+			t.Errorf("fail query unique %v: %d != %d", q, len(result), len(deduped))
+		}
+		n := 0
+		for range tree.QueryView(q[0], q[1]) {
+			n++
+		}
+		if n != len(deduped) {
+			t.Errorf("fail query view unique %v: %d != %d", q, n, len(deduped))
+		}
+	}
+	if !duplicates {
+		t.Errorf("fail query unique, no interval found at several nodes")
+	}
+}
+
+func TestStabBest(t *testing.T) {
+	for i, tree := range []Tree{NewSerial(), NewTree(), NewCircularTree(100)} {
+		tree.PushArray([]int{0, 5, 8, 2, 5}, []int{50, 10, 11, 9, 10})
+		if i > 0 {
+			tree.BuildTree()
+		}
+		for prefer, id := range map[Preference]int{SHORTEST: 2, LONGEST: 0, HIGHEST_ID: 4, LOWEST_FROM: 0} {
+			if best, ok := tree.StabBest(9, prefer); !ok || best.Id != id {
+				t.Errorf("fail stab best %d: %v", prefer, best)
+			}
+		}
+		if _, ok := tree.StabBest(60, SHORTEST); ok {
+			t.Errorf("fail stab best outside")
+		}
+	}
+}
+
+func TestNamedTree(t *testing.T) {
+	tree := NewNamedTree(NewDictionary("fetch", "compile", "test", "package", "deploy"))
+	tree.PushNamed("fetch", "compile")
+	tree.PushNamed("test", "deploy")
+	tree.BuildTree()
+	result := tree.QueryNamed("compile", "test")
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	if len(result) != 2 || result[0].Lo != "fetch" || result[1].Hi != "deploy" {
+		t.Errorf("fail query named: %v", result)
+	}
+	if result := tree.QueryNamed("lint", "test"); len(result) != 0 {
+		t.Errorf("fail query named with unknown label: %v", result)
+	}
+	defer func() {
+		if r := recover(); r != ErrUnknownLabel {
+			t.Errorf("fail panic on unknown label: %v", r)
+		}
+	}()
+	tree.PushNamed("fetch", "lint")
+}
+
+func TestQueryCoveringTree(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{0, 10, 20}, []int{20, 30, 40})
+	tree.BuildTree()
+	result := tree.Query(-5, 40)
+	sort.Sort(ById(result))
+	if len(result) != 3 || result[2].Id != 2 {
+		t.Errorf("fail query covering tree: %v", result)
+	}
+	if result := tree.QueryArray([]int{50, 0}, []int{60, 100}); len(result) != 3 {
+		t.Errorf("fail query array covering tree: %v", result)
+	}
+	// intervals with the same Id are merged
+	tree.Clear()
+	tree.PushWithId(1, 0, 10)
+	tree.PushWithId(1, 5, 20)
+	tree.BuildTree()
+	if result := tree.Query(0, 20); len(result) != 1 {
+		t.Errorf("fail query covering tree with equal Ids: %v", result)
+	}
+}
+
+func TestLazyTree(t *testing.T) {
+	tree := NewLazyTree()
+	tree.PushArray([]int{0, 10}, []int{20, 30})
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if result := tree.Query(15, 15); len(result) != 2 {
+				t.Errorf("fail lazy query: %v", result)
+			}
+		}()
+	}
+	wait.Wait()
+	root := tree.Root()
+	tree.Query(0, 0)
+	if tree.Root() != root {
+		t.Errorf("fail lazy query, tree built twice")
+	}
+	// push after the first query rebuilds the tree
+	tree.Push(40, 50)
+	if result := tree.QueryArray([]int{45}, []int{45}); len(result) != 1 {
+		t.Errorf("fail lazy query after push: %v", result)
+	}
+	// every query builds the tree
+	for i, query := range []func(lazy SegmentTree) bool{
+		func(lazy SegmentTree) bool { return lazy.Count(15, 15) == 1 },
+		func(lazy SegmentTree) bool { return len(lazy.Stab(15)) == 1 },
+		func(lazy SegmentTree) bool { return lazy.Depth(15) == 1 },
+		func(lazy SegmentTree) bool { _, _, ok := lazy.Nearest(25); return ok },
+		func(lazy SegmentTree) bool { return len(lazy.QueryOrdered(0, 20)) == 1 },
+		func(lazy SegmentTree) bool { return len(lazy.Enclosing(5, 6)) == 1 },
+	} {
+		lazy := NewLazyTree()
+		lazy.Push(0, 20)
+		if !query(lazy) {
+			t.Errorf("fail lazy query %d", i)
+		}
+	}
+	tree.PushIntervals([]Interval{{Id: 10, Segment: Segment{60, 70}}})
+	if result := tree.Query(65, 65); len(result) != 1 || result[0].Id != 10 {
+		t.Errorf("fail lazy query after push of intervals: %v", result)
+	}
+	empty := NewLazyTree()
+	if empty.HasOverlaps() || len(empty.AllGaps()) != 0 {
+		t.Errorf("fail lazy methods of stack on empty tree")
+	}
+	defer func() {
+		if r := recover(); r != ErrNoIntervals {
+			t.Errorf("fail panic on lazy query of empty tree: %v", r)
+		}
+	}()
+	empty.Query(0, 0)
+}
+
+func TestQueryArrayAnnotated(t *testing.T) {
+	from, to := GenerateIntervals(500, 5000, 15, CLUSTERED)
+	qFrom, qTo := []int{100, 2000, 150, 4000}, []int{900, 2100, 160, 4000}
+	for i, tree := range []Tree{NewSerial(), NewTree()} {
+		tree.PushArray(from, to)
+		if i > 0 {
+			tree.BuildTree()
+		}
+		result := tree.QueryArrayAnnotated(qFrom, qTo)
+		if len(result) != len(tree.QueryArray(qFrom, qTo)) {
+			t.Errorf("fail query array annotated: %d results", len(result))
+		}
+		for _, a := range result {
+			for q := range qFrom {
+				overlaps := a.Interval.From <= qTo[q] && a.Interval.To >= qFrom[q]
+				if overlaps != slices.Contains(a.Ranges, q) {
+					t.Errorf("fail query array annotated: %v, query %d", a, q)
+				}
+			}
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	from, to := GenerateIntervals(1000, 10000, 16, CLUSTERED)
+	tree := NewTree()
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	c := NewCursor(tree)
+	seen := make(map[int]bool)
+	for _, r := range [][2]int{{5000, 5000}, {4900, 5100}, {4900, 5100}, {3000, 5200}, {3000, 9000}, {6000, 9500}} {
+		for _, intrvl := range c.Expand(r[0], r[1]) {
+			if seen[intrvl.Id] {
+				t.Errorf("fail cursor %v: interval %d returned twice", r, intrvl.Id)
+			}
+			seen[intrvl.Id] = true
+		}
+		// everything overlapping the range was returned by now
+		for _, intrvl := range tree.Query(r[0], r[1]) {
+			if !seen[intrvl.Id] {
+				t.Errorf("fail cursor %v: interval %d missing", r, intrvl.Id)
+			}
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	a, b := NewTree(), NewSerial()
+	a.PushArray([]int{5, 1, 5, 1}, []int{9, 4, 9, 2})
+	b.PushWithId(7, 1, 2)
+	b.PushArray([]int{5, 1, 5}, []int{9, 4, 9})
+	expected := []SegmentCount{{Segment{1, 2}, 1}, {Segment{1, 4}, 1}, {Segment{5, 9}, 2}}
+	if result := a.CanonicalCounted(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("fail canonical counted: %v", result)
+	}
+	if !reflect.DeepEqual(a.CanonicalCounted(), b.CanonicalCounted()) {
+		t.Errorf("fail canonical counted of equal sets")
+	}
+	if result := b.Canonical(); !reflect.DeepEqual(result, []Segment{{1, 2}, {1, 4}, {5, 9}}) {
+		t.Errorf("fail canonical: %v", result)
+	}
+}
+
+func TestQueryInsertionOrder(t *testing.T) {
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	for i, tree := range []Tree{NewTree(), NewSerial(), NewCircularTree(100001)} {
+		tree.PushArray(from, to)
+		if i != 1 {
+			tree.BuildTree()
+		}
+		result := tree.QueryInsertionOrder(20000, 40000)
+		if len(result) == 0 || !sort.IsSorted(ById(result)) {
+			t.Errorf("fail query insertion order %d: %d intervals", i, len(result))
+		}
+		if len(result) != len(tree.Query(20000, 40000)) {
+			t.Errorf("fail query insertion order %d: %d intervals", i, len(result))
+		}
+	}
+}
+
+func TestMaximalCliques(t *testing.T) {
+	tree := NewTree()
+	// 0 and 1 share 3, 1, 2 and 3 share 6, 4 touches 3 at 9, 5 is alone
+	tree.PushArray([]int{1, 3, 5, 6, 9, 12}, []int{3, 7, 6, 9, 10, 12})
+	expected := [][]int{{0, 1}, {1, 2, 3}, {3, 4}, {5}}
+	if result := tree.MaximalCliques(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("fail maximal cliques: %v", result)
+	}
+	if result := MaximalCliques(nil); len(result) != 0 {
+		t.Errorf("fail maximal cliques of no intervals: %v", result)
+	}
+}
+
+func TestIntervalTree(t *testing.T) {
+	tree := NewTree()
+	itree := NewIntervalTree()
+	from, to := GenerateIntervals(2000, 100000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	itree.PushArray(from, to)
+	itree.Push(500, 500)
+	tree.Push(500, 500)
+	tree.BuildTree()
+	itree.BuildTree()
+	queries := [][2]int{{0, 100000}, {500, 500}, {-10, -1}, {100001, 100010}, {20000, 20500}, {99990, 200000}}
+	for i := 0; i < 100; i++ {
+		a, b := rand.Intn(100000), rand.Intn(100000)
+		queries = append(queries, [2]int{min(a, b), max(a, b)})
+	}
+	for _, query := range queries {
+		expected := tree.Query(query[0], query[1])
+		sort.Sort(ById(expected))
+		result := itree.Query(query[0], query[1])
+		if !sort.IsSorted(ByFrom(result)) {
+			t.Errorf("fail interval tree order (%d, %d)", query[0], query[1])
+		}
+		sort.Sort(ById(result))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail interval tree query (%d, %d): %d intervals, expected %d", query[0], query[1], len(result), len(expected))
+		}
+		if result := itree.(LinearQueries).Enclosing(query[0], query[1]); len(result) != len(tree.Enclosing(query[0], query[1])) {
+			t.Errorf("fail interval tree enclosing (%d, %d)", query[0], query[1])
+		}
+	}
+	qfrom, qto := []int{100, 50000, 49000}, []int{5000, 60000, 51000}
+	if result := itree.QueryArray(qfrom, qto); len(result) != len(tree.QueryArray(qfrom, qto)) {
+		t.Errorf("fail interval tree query array: %d intervals", len(result))
+	}
+	count := 0
+	for range itree.QueryView(0, 100000) {
+		if count++; count == 10 {
+			break
+		}
+	}
+	if count != 10 {
+		t.Errorf("fail interval tree query view: %d", count)
+	}
+	tree.Insert(30, 60)
+	itree.(Updater).Insert(30, 60)
+	if result := itree.QueryOrdered(0, 100); !reflect.DeepEqual(result, tree.QueryOrdered(0, 100)) {
+		t.Errorf("fail interval tree insert: %v", result)
+	}
+}
+
+func TestIntervalTreeDuplicateIds(t *testing.T) {
+	tree := NewTree()
+	itree := NewIntervalTree()
+	from, to := GenerateIntervals(1000, 10000, 2, UNIFORM)
+	for i := range from {
+		// every Id is pushed for two intervals
+		tree.PushWithId(i/2, from[i], to[i])
+		itree.PushWithId(i/2, from[i], to[i])
+	}
+	tree.BuildTree()
+	itree.BuildTree()
+	ids := func(result []Interval) []int {
+		ids := make([]int, len(result))
+		for i, intrvl := range result {
+			ids[i] = intrvl.Id
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	for i := 0; i < 100; i++ {
+		a, b := rand.Intn(10000), rand.Intn(10000)
+		qfrom, qto := min(a, b), max(a, b)
+		expected := ids(tree.Query(qfrom, qto))
+		if result := ids(itree.Query(qfrom, qto)); !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail query (%d, %d) with duplicate Ids: %d intervals, expected %d", qfrom, qto, len(result), len(expected))
+		}
+		if count := itree.Count(qfrom, qto); count != len(expected) {
+			t.Errorf("fail count (%d, %d) with duplicate Ids: %d, expected %d", qfrom, qto, count, len(expected))
+		}
+		var view []Interval
+		for intrvl := range itree.QueryView(qfrom, qto) {
+			view = append(view, intrvl)
+		}
+		if result := ids(view); !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail query view (%d, %d) with duplicate Ids", qfrom, qto)
+		}
+		qfroms, qtos := []int{qfrom, qto / 2}, []int{qto, qto}
+		if result := ids(itree.QueryArray(qfroms, qtos)); !reflect.DeepEqual(result, ids(tree.QueryArray(qfroms, qtos))) {
+			t.Errorf("fail query array (%d, %d) with duplicate Ids", qfrom, qto)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	from, to := GenerateIntervals(500, 1000, 1, UNIFORM)
+	trees := []Tree{NewTree(), NewSerial(), NewIntervalTree(), NewCircularTree(1001)}
+	for i, tree := range trees {
+		tree.PushArray(from, to)
+		if i != 1 {
+			tree.BuildTree()
+		}
+	}
+	for point := -1; point <= 1001; point++ {
+		expected := len(trees[1].Query(point, point))
+		for i, tree := range trees {
+			if depth := tree.Depth(point); depth != expected {
+				t.Errorf("fail depth %d of tree %d: %d, expected %d", point, i, depth, expected)
+			}
+		}
+	}
+}
+
+func TestStab(t *testing.T) {
+	from, to := GenerateIntervals(500, 1000, 1, UNIFORM)
+	trees := []Tree{NewTree(), NewSerial(), NewIntervalTree(), NewCircularTree(1001), NewSafeTree(NewTree())}
+	for i, tree := range trees {
+		tree.PushArray(from, to)
+		if i != 1 {
+			tree.BuildTree()
+		}
+	}
+	for point := -1; point <= 1001; point++ {
+		expected := trees[1].Query(point, point)
+		sort.Sort(ById(expected))
+		for i, tree := range trees {
+			if i == 3 && (point < 0 || point > 1000) {
+				// outside of period
+				continue
+			}
+			result := tree.Stab(point)
+			sort.Sort(ById(result))
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("fail stab %d of tree %d: %d intervals, expected %d", point, i, len(result), len(expected))
+			}
+		}
+	}
+	sparse := NewTree()
+	sparse.PushWithId(7, 1, 5)
+	sparse.PushWithId(3, 4, 9)
+	sparse.BuildTree()
+	if result := sparse.Stab(4); len(result) != 2 {
+		t.Errorf("fail stab with sparse Ids: %v", result)
+	}
+	halfOpen := NewTreeHalfOpen()
+	halfOpen.Push(1, 4)
+	halfOpen.Push(4, 9)
+	halfOpen.BuildTree()
+	if result := halfOpen.Stab(4); len(result) != 1 || result[0].Segment != (Segment{4, 9}) {
+		t.Errorf("fail stab of half-open tree: %v", result)
+	}
+}
+
+func TestRenderView(t *testing.T) {
+	tree := NewTree()
+	tree.PushArray([]int{0, 15, 30, 60, 120}, []int{200, 24, 39, 60, 130})
+	tree.BuildTree()
+	// coordinates 10 to 109 on 50 pixels, 2 coordinates per pixel
+	expected := []RenderedInterval{
+		{Interval{Id: 0, Segment: Segment{10, 109}}, 0, 50},
+		{Interval{Id: 1, Segment: Segment{15, 24}}, 2, 7},
+		{Interval{Id: 2, Segment: Segment{30, 39}}, 10, 15},
+		{Interval{Id: 3, Segment: Segment{60, 60}}, 25, 25},
+	}
+	if result := tree.RenderView(10, 109, 50); !reflect.DeepEqual(result, expected) {
+		t.Errorf("fail render view: %v", result)
+	}
+	if result := Render(nil, math.MinInt, math.MaxInt-1, 10); len(result) != 0 {
+		t.Errorf("fail render without intervals: %v", result)
+	}
+	safe := NewSafeTree(tree)
+	if err := safe.Try(func() { tree.RenderView(10, 109, 0) }); err != ErrInvalidViewport {
+		t.Errorf("fail render view of zero width: %v", err)
+	}
+	if err := safe.Try(func() { tree.RenderView(109, 10, 50) }); err != ErrInvalidViewport {
+		t.Errorf("fail render view from > to: %v", err)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	tree := NewTree()
+	from, to := GenerateIntervals(1000, 10000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	window := NewWindow(tree, 300)
+	active := make(map[int]bool)
+	for _, start := range []int{0, 50, 200, 900, 800, 8000, 9990} {
+		entered, exited := window.Advance(start)
+		for _, intrvl := range entered {
+			if active[intrvl.Id] {
+				t.Errorf("fail window %d: %d entered twice", start, intrvl.Id)
+			}
+			active[intrvl.Id] = true
+		}
+		for _, intrvl := range exited {
+			if !active[intrvl.Id] {
+				t.Errorf("fail window %d: %d exited without entering", start, intrvl.Id)
+			}
+			delete(active, intrvl.Id)
+		}
+		if expected := tree.Query(start, start+300); len(active) != len(expected) {
+			t.Errorf("fail window %d: %d active intervals, expected %d", start, len(active), len(expected))
+		}
+	}
+	defer func() {
+		if r := recover(); r != ErrNegativeWidth {
+			t.Errorf("fail panic on negative width: %v", r)
+		}
+	}()
+	NewWindow(tree, -1)
+}
+
+func TestIsPartition(t *testing.T) {
+	tests := []struct {
+		from, to  []int
+		partition bool
+	}{
+		{[]int{1, 5}, []int{4, 9}, true},
+		{[]int{5, 1, 9}, []int{8, 4, 9}, true},
+		// touching intervals overlap at 5
+		{[]int{1, 5}, []int{5, 9}, false},
+		{[]int{1, 6}, []int{4, 9}, false},
+		{[]int{0, 5}, []int{4, 9}, false},
+		{[]int{1, 5}, []int{4, 12}, false},
+		{[]int{1, 5, 7}, []int{4, 9, 8}, false},
+		{[]int{1, 1}, []int{9, 9}, false},
+	}
+	for i, test := range tests {
+		for j, tree := range []Tree{NewTree(), NewSerial()} {
+			tree.PushArray(test.from, test.to)
+			if j == 0 {
+				tree.BuildTree()
+			}
+			if tree.IsPartition(1, 9) != test.partition {
+				t.Errorf("fail partition %d of tree %d", i, j)
+			}
+		}
+	}
+	if IsPartition(nil, 1, 9) {
+		t.Errorf("fail partition without intervals")
+	}
+}
+
+func TestBuildTreeError(t *testing.T) {
+	for i, tree := range []Tree{NewTree(), NewIntervalTree(), NewLazyTree(), NewCircularTree(10)} {
+		if err := tree.BuildTree(); err != ErrNoIntervals {
+			t.Errorf("fail error of build %d without intervals: %v", i, err)
+		}
+		tree.Push(1, 5)
+		if err := tree.BuildTree(); err != nil {
+			t.Errorf("fail build %d: %v", i, err)
+		}
+		if result := tree.Query(2, 3); len(result) != 1 {
+			t.Errorf("fail query %d after build: %v", i, result)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	trees := []Tree{NewTree(), NewSerial(), NewIntervalTree(), NewTree()}
+	for i, tree := range trees {
+		if i == 3 {
+			// sparse Ids are deduplicated in a map
+			for j := range from {
+				tree.PushWithId(2*j, from[j], to[j])
+			}
+		} else {
+			tree.PushArray(from, to)
+		}
+		if i != 1 {
+			tree.BuildTree()
+		}
+	}
+	queries := [][2]int{{0, 100000}, {-5, -1}, {500, 500}, {20000, 40000}, {99999, 200000}}
+	for _, query := range queries {
+		expected := len(trees[1].Query(query[0], query[1]))
+		for i, tree := range trees {
+			if count := tree.Count(query[0], query[1]); count != expected {
+				t.Errorf("fail count (%d, %d) of tree %d: %d, expected %d", query[0], query[1], i, count, expected)
+			}
+		}
+	}
+	circular := NewCircularTree(100001)
+	circular.PushArray(from, to)
+	circular.BuildTree()
+	for _, query := range [][2]int{{20000, 40000}, {90000, 10000}} {
+		if count := circular.Count(query[0], query[1]); count != len(circular.Query(query[0], query[1])) {
+			t.Errorf("fail count (%d, %d) of circular tree: %d", query[0], query[1], count)
+		}
+	}
+	trees[0].SetOverlapFunc(WithinDistance(10))
+	if count := trees[0].Count(20000, 40000); count != len(trees[0].Query(20000, 40000)) {
+		t.Errorf("fail count with overlap function: %d", count)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	for i, tree := range []Tree{NewTree(), NewSerial(), NewIntervalTree()} {
+		tree.PushArray(from, to)
+		tree.PushKey(20, 30, "last")
+		if i != 1 {
+			tree.BuildTree()
+		}
+		// the last interval moves to the place of 3
+		for _, id := range []int{3, 1000, 0, 998} {
+			if !tree.(Updater).Remove(id) {
+				t.Errorf("fail remove %d of tree %d", id, i)
+			}
+		}
+		if tree.(Updater).Remove(3) || tree.(Updater).Remove(5000) {
+			t.Errorf("fail remove of missing interval of tree %d", i)
+		}
+		if _, ok := tree.GetByKey("last"); ok {
+			t.Errorf("fail key of removed interval of tree %d", i)
+		}
+		expected := make([]int, 0, 1000)
+		for id := range from {
+			if id != 3 && id != 0 && id != 998 && Overlaps(Segment{from[id], to[id]}, 20000, 40000) {
+				expected = append(expected, id)
+			}
+		}
+		result := tree.Query(20000, 40000)
+		sort.Sort(ById(result))
+		ids := make([]int, len(result))
+		for j, intrvl := range result {
+			ids[j] = intrvl.Id
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("fail query after remove of tree %d: %d intervals, expected %d", i, len(ids), len(expected))
+		}
+		if count := len(tree.Query(math.MinInt, math.MaxInt)); count != 997 {
+			t.Errorf("fail query of all intervals after remove of tree %d: %d", i, count)
+		}
+	}
+}
+
+func TestIsSkewed(t *testing.T) {
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	tree := NewTree()
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	// intervals inserted in place into the same leaves add no leaves
+	for i := 0; i < 1000; i++ {
+		tree.Insert(from[0], to[0])
+	}
+	lazy := NewLazyTree()
+	lazy.PushArray(from, to)
+	if tree.IsSkewed() || NewTree().IsSkewed() || NewSerial().(Updater).IsSkewed() || lazy.IsSkewed() || !lazy.Built() {
+		t.Errorf("fail skew of tree matching its stack")
+	}
+	for id := 0; id < 700; id++ {
+		tree.Remove(id)
+	}
+	if !tree.IsSkewed() {
+		t.Errorf("fail skew after removals")
+	}
+	tree.Compact()
+	if tree.IsSkewed() {
+		t.Errorf("fail skew after compact")
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	tree := NewTree()
+	auto := NewTreeAutoCompact(0.5)
+	for _, tree := range []Tree{tree, auto} {
+		tree.PushArray(from, to)
+		tree.BuildTree()
+	}
+	nodes := len(tree.Tree2Array())
+	for id := 0; id < 999; id++ {
+		tree.Remove(id)
+		auto.Remove(id)
+		if ratio := auto.RemovedRatio(); ratio >= 0.5 {
+			t.Fatalf("fail auto compact after remove %d: ratio %f", id, ratio)
+		}
+	}
+	// the leaves of removed intervals stay until the tree is compacted
+	if ratio := tree.RemovedRatio(); ratio != 0.999 || len(tree.Tree2Array()) != nodes {
+		t.Errorf("fail removed ratio: %f, %d nodes", ratio, len(tree.Tree2Array()))
+	}
+	if err := tree.Compact(); err != nil || tree.RemovedRatio() != 0 || len(tree.Tree2Array()) >= nodes {
+		t.Errorf("fail compact: %v, %d nodes", err, len(tree.Tree2Array()))
+	}
+	if !Equal(tree, auto) {
+		t.Errorf("fail auto compact, trees not equal")
+	}
+	if tree.Remove(999) && tree.Compact() != ErrNoIntervals {
+		t.Errorf("fail compact of tree without intervals")
+	}
+	lazy := NewLazyTree()
+	lazy.Push(1, 5)
+	if NewTree().Compact() != ErrEmptyTree || NewSerial().(Updater).Compact() != nil || lazy.Compact() != nil {
+		t.Errorf("fail compact of unbuilt tree")
+	}
+	defer func() {
+		if r := recover(); r != ErrInvalidThreshold {
+			t.Errorf("fail panic on invalid threshold: %v", r)
+		}
+	}()
+	NewTreeAutoCompact(1)
+}
+
+func TestQueryFunc(t *testing.T) {
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	for i, tree := range []Tree{NewTree(), NewSerial(), NewIntervalTree(), NewCircularTree(100001)} {
+		tree.PushArray(from, to)
+		if i != 1 {
+			tree.BuildTree()
+		}
+		ids := make(map[int]bool)
+		tree.QueryFunc(20000, 40000, func(intrvl Interval) bool {
+			ids[intrvl.Id] = true
+			return true
+		})
+		if len(ids) != len(tree.Query(20000, 40000)) {
+			t.Errorf("fail query func of tree %d: %d intervals", i, len(ids))
+		}
+		count := 0
+		tree.QueryFunc(0, 100000, func(intrvl Interval) bool {
+			count++
+			return count < 5
+		})
+		if count != 5 {
+			t.Errorf("fail early stop of query func of tree %d: %d", i, count)
+		}
+	}
+}
+
+func TestNewTreeFromState(t *testing.T) {
+	tree := NewTree()
+	from, to := GenerateIntervals(1000, 100000, 1, UNIFORM)
+	tree.PushArray(from, to)
+	tree.PushKey(5, 5, "point")
+	tree.BuildTree()
+	data, err := json.Marshal(tree.SnapshotOverlaps())
+	if err != nil {
+		t.Fatalf("fail marshal: %v", err)
+	}
+	var state OverlapState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("fail unmarshal: %v", err)
+	}
+	loaded, err := NewTreeFromState(state)
+	if err != nil {
+		t.Fatalf("fail tree from state: %v", err)
+	}
+	if !Equal(tree, loaded) {
+		t.Errorf("fail tree from state: trees not equal")
+	}
+	for _, query := range [][2]int{{0, 100000}, {5, 5}, {20000, 40000}, {-10, -1}} {
+		expected := tree.Query(query[0], query[1])
+		result := loaded.Query(query[0], query[1])
+		sort.Sort(ById(expected))
+		sort.Sort(ById(result))
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("fail query (%d, %d) of tree from state", query[0], query[1])
+		}
+	}
+	if intrvl, ok := loaded.GetByKey("point"); !ok || intrvl.Id != 1000 {
+		t.Errorf("fail key of tree from state: %v", intrvl)
+	}
+	state.Segments = state.Segments[1:]
+	state.Overlap = state.Overlap[1:]
+	if _, err := NewTreeFromState(state); err != ErrStateMismatch {
+		t.Errorf("fail tree from invalid state: %v", err)
+	}
+}
+
+func TestGobTree(t *testing.T) {
+	from, to := GenerateIntervals(10000, 1000000, 1, UNIFORM)
+	for i, tree := range []Tree{NewTree(), NewSerial(), NewIntervalTree()} {
+		tree.PushArray(from, to)
+		tree.PushKey(5, 5, "point")
+		if i != 1 {
+			tree.BuildTree()
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(tree); err != nil {
+			t.Fatalf("fail encode %d: %v", i, err)
+		}
+		decoded := NewTree()
+		decoded.Push(1, 2)
+		if err := gob.NewDecoder(&buf).Decode(decoded); err != nil {
+			t.Fatalf("fail decode %d: %v", i, err)
+		}
+		if err := decoded.BuildTree(); err != nil {
+			t.Fatalf("fail build decoded tree %d: %v", i, err)
+		}
+		for _, query := range [][2]int{{0, 1000000}, {5, 5}, {200000, 400000}, {-10, -1}} {
+			expected := tree.Query(query[0], query[1])
+			result := decoded.Query(query[0], query[1])
+			sort.Sort(ById(expected))
+			sort.Sort(ById(result))
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("fail query (%d, %d) of decoded tree %d", query[0], query[1], i)
+			}
+		}
+		if intrvl, ok := decoded.GetByKey("point"); !ok || intrvl.Id != 10000 {
+			t.Errorf("fail key of decoded tree %d: %v", i, intrvl)
+		}
+	}
+}
+
+func TestFprint(t *testing.T) {
+	tree := NewTree()
+	tree.Push(1, 1)
+	tree.Push(2, 3)
+	tree.Push(1, 3)
+	tree.BuildTree()
+	var buf bytes.Buffer
+	Fprint(&buf, tree.Root())
+	expected := "\nSegment: (1,3)\nInterval 2: (1,3)" +
+		"\nSegment: (2,3)\nInterval 1: (2,3)" +
+		"\nSegment: (3,3)" +
+		"\nSegment: (2,2)" +
+		"\nSegment: (1,1)\nInterval 0: (1,1)"
+	if buf.String() != expected {
+		t.Errorf("fail print: %q", buf.String())
+	}
+	buf.Reset()
+	Fprint(&buf, NewTree().Root())
+	if buf.Len() != 0 {
+		t.Errorf("fail print of empty tree: %q", buf.String())
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	tree := NewTree()
+	tree.Push(1, 1)
+	tree.Push(2, 3)
+	tree.Push(1, 3)
+	tree.Push(5, 8)
+	tree.BuildTree()
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, tree.Root()); err != nil {
+		t.Fatalf("fail write DOT: %v", err)
+	}
+	golden, err := os.ReadFile("testdata/tree.dot")
+	if err != nil {
+		t.Fatalf("fail read golden file: %v", err)
+	}
+	if buf.String() != string(golden) {
+		t.Errorf("fail DOT output:\n%s", buf.String())
+	}
+	if err := WriteDOT(&buf, NewTree().Root()); err != ErrEmptyTree {
+		t.Errorf("fail write DOT of empty tree: %v", err)
+	}
+}
+
+func TestHalfOpenTree(t *testing.T) {
+	tree := NewTreeHalfOpen()
+	tree.Push(1, 3)
+	tree.Push(3, 7)
+	tree.PushKey(7, 8, "unit")
+	tree.BuildTree()
+	// touching endpoints don't overlap
+	for _, test := range []struct {
+		from, to int
+		ids      []int
+	}{
+		{2, 3, []int{0}},
+		{3, 4, []int{1}},
+		{2, 4, []int{0, 1}},
+		{0, 1, []int{}},
+		{7, 8, []int{2}},
+		{6, 7, []int{1}},
+		{8, 9, []int{}},
+		{3, 3, []int{}},
+		{5, 2, []int{}},
+		{0, 10, []int{0, 1, 2}},
+	} {
+		result := tree.Query(test.from, test.to)
+		sort.Sort(ById(result))
+		ids := make([]int, 0, len(result))
+		for _, intrvl := range result {
+			ids = append(ids, intrvl.Id)
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("fail query [%d,%d): %v, expected %v", test.from, test.to, ids, test.ids)
+		}
+		if count := tree.Count(test.from, test.to); count != len(test.ids) {
+			t.Errorf("fail count [%d,%d): %d", test.from, test.to, count)
+		}
+	}
+	if result := tree.QueryOrdered(2, 4); len(result) != 2 || result[0].Segment != (Segment{1, 3}) || result[1].Segment != (Segment{3, 7}) {
+		t.Errorf("fail half-open result: %v", result)
+	}
+	if intrvl, ok := tree.GetByKey("unit"); !ok || intrvl.Segment != (Segment{7, 8}) {
+		t.Errorf("fail get by key: %v", intrvl)
+	}
+	if result := tree.QueryArray([]int{2, 5}, []int{3, 5}); len(result) != 1 || result[0].Id != 0 {
+		t.Errorf("fail query array with empty query: %v", result)
+	}
+	if result := tree.Enclosing(3, 7); len(result) != 1 || result[0].Id != 1 {
+		t.Errorf("fail enclosing: %v", result)
+	}
+	if merged := tree.MergedSegments(); !reflect.DeepEqual(merged, []Segment{{1, 8}}) {
+		t.Errorf("fail merged segments: %v", merged)
+	}
+	if !tree.IsPartition(1, 8) || tree.HasOverlaps() || tree.LayerCount(0, 10) != 1 {
+		t.Errorf("fail touching intervals should tile [1,8)")
+	}
+	if tree.Depth(3) != 1 || tree.Depth(8) != 0 {
+		t.Errorf("fail depth at endpoints: %d, %d", tree.Depth(3), tree.Depth(8))
+	}
+	// the exclusive To is the end of an interval
+	if result := tree.QueryEndsIn(4, 8); len(result) != 1 || result[0].Segment != (Segment{3, 7}) {
+		t.Errorf("fail query ends in: %v", result)
+	}
+	if result := tree.QueryEndsIn(math.MinInt, 4); len(result) != 1 || result[0].Segment != (Segment{1, 3}) {
+		t.Errorf("fail query ends in from min int: %v", result)
+	}
+	if result := tree.QueryEndsIn(4, 4); len(result) != 0 {
+		t.Errorf("fail query ends in empty range: %v", result)
+	}
+	if starts, ends := tree.FlowCounts(3, 8); starts != 2 || ends != 2 {
+		t.Errorf("fail flow counts: %d, %d", starts, ends)
+	}
+	if starts, ends := tree.FlowCounts(math.MinInt, math.MinInt+1); starts != 0 || ends != 0 {
+		t.Errorf("fail flow counts at min int: %d, %d", starts, ends)
+	}
+	result, gaps := tree.QueryWithGaps(0, 10)
+	if len(result) != 3 || result[0].Segment != (Segment{1, 3}) || result[2].Segment != (Segment{7, 8}) {
+		t.Errorf("fail query with gaps: %v", result)
+	}
+	if !reflect.DeepEqual(gaps, []Segment{{0, 1}, {8, 10}}) {
+		t.Errorf("fail gaps of query: %v", gaps)
+	}
+	if result, gaps := tree.QueryWithGaps(5, 5); len(result) != 0 || len(gaps) != 0 {
+		t.Errorf("fail query with gaps of empty range: %v %v", result, gaps)
+	}
+	relative := tree.QueryRelative(2, 5)
+	sort.Slice(relative, func(i, j int) bool { return relative[i].From < relative[j].From })
+	if !reflect.DeepEqual(relative, []Segment{{0, 1}, {1, 3}}) {
+		t.Errorf("fail query relative: %v", relative)
+	}
+	if rendered := tree.RenderView(0, 10, 10); len(rendered) != 3 || rendered[1].Interval.Segment != (Segment{3, 7}) || rendered[1].X0 != 3 || rendered[1].X1 != 7 {
+		t.Errorf("fail render view: %v", rendered)
+	}
+	other := tree.CloneEmpty()
+	other.Push(1, 3)
+	other.Push(3, 7)
+	other.Push(7, 8)
+	other.BuildTreeWithEndpoints([]int{1, 3, 7, 8}, 1, 8)
+	for _, query := range [][2]int{{0, 10}, {2, 3}, {3, 4}, {7, 8}, {8, 9}} {
+		if a, b := len(tree.Query(query[0], query[1])), len(other.Query(query[0], query[1])); a != b {
+			t.Errorf("fail query [%d,%d) of tree built with endpoints: %d, expected %d", query[0], query[1], b, a)
+		}
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrInvalidViewport {
+				t.Errorf("fail panic on empty viewport: %v", r)
+			}
+		}()
+		tree.RenderView(5, 5, 10)
+	}()
+	// random half-open intervals against a sequential check
+	tree = NewTreeHalfOpen()
+	from, to := GenerateIntervals(1000, 10000, 1, UNIFORM)
+	for i := range from {
+		to[i]++
+	}
+	tree.PushArray(from, to)
+	tree.BuildTree()
+	for _, query := range [][2]int{{0, 10000}, {500, 501}, {2000, 4000}, {to[0], to[0] + 1}, {from[1] - 1, from[1]}} {
+		expected := 0
+		for i := range from {
+			if from[i] < query[1] && query[0] < to[i] {
+				expected++
+			}
+		}
+		if count := len(tree.Query(query[0], query[1])); count != expected {
+			t.Errorf("fail query [%d,%d): %d intervals, expected %d", query[0], query[1], count, expected)
+		}
+	}
+	defer func() {
+		if r := recover(); r != ErrEmptyHalfOpen {
+			t.Errorf("fail panic on empty half-open interval: %v", r)
+		}
+	}()
+	tree.Push(5, 5)
+}
+
+func TestOptionalInterfaces(t *testing.T) {
+	for i, test := range []struct {
+		tree                            Tree
+		updater, linear, clipped, stree bool
+	}{
+		{NewTree(), true, true, true, true},
+		{NewLazyTree(), true, true, true, true},
+		{NewTreeHalfOpen(), true, true, true, true},
+		{NewSerial(), true, true, true, false},
+		{NewIntervalTree(), true, true, true, false},
+		{NewCircularTree(10), false, false, false, false},
+		{NewSafeTree(NewTree()), false, false, false, false},
+	} {
+		_, updater := test.tree.(Updater)
+		_, linear := test.tree.(LinearQueries)
+		_, clipped := test.tree.(ClippedQueries)
+		_, stree := test.tree.(SegmentTree)
+		if updater != test.updater || linear != test.linear || clipped != test.clipped || stree != test.stree {
+			t.Errorf("fail interfaces of tree %d: %v %v %v %v", i, updater, linear, clipped, stree)
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	// gaps between 5 and 10, 12 and 20, 20 and 30
+	from, to := []int{1, 10, 20, 30, 3}, []int{5, 12, 20, 40, 4}
+	for i, tree := range []Tree{NewSerial(), NewTree(), NewIntervalTree()} {
+		tree.PushArray(from, to)
+		if i > 0 {
+			tree.BuildTree()
+		}
+		for _, probe := range []struct{ point, id, distance int }{
+			{3, 0, 0}, {7, 0, 2}, {8, 1, 2}, {16, 1, 4}, {17, 2, 3},
+			{25, 2, 5}, {26, 3, 4}, {35, 3, 0}, {-10, 0, 11}, {50, 3, 10},
+		} {
+			intrvl, distance, ok := tree.(LinearQueries).Nearest(probe.point)
+			if !ok || intrvl.Id != probe.id || distance != probe.distance {
+				t.Errorf("fail nearest %d of tree %d: %v %d %v", probe.point, i, intrvl, distance, ok)
+			}
+		}
+	}
+	// random intervals with gaps against the serial structure
+	tree, serial := NewTree(), NewSerial()
+	for i := 0; i < 1000; i++ {
+		from := rand.Intn(100000)
+		to := from + rand.Intn(50)
+		tree.Push(from, to)
+		serial.Push(from, to)
+	}
+	tree.BuildTree()
+	for i := 0; i < 1000; i++ {
+		point := rand.Intn(110000) - 5000
+		a, da, _ := tree.Nearest(point)
+		b, db, _ := serial.(LinearQueries).Nearest(point)
+		if a != b || da != db {
+			t.Errorf("fail nearest %d: %v %d != %v %d", point, a, da, b, db)
+		}
+	}
+	if _, _, ok := NewSerial().(LinearQueries).Nearest(0); ok {
+		t.Errorf("fail nearest of empty serial")
+	}
+	halfOpen := NewTreeHalfOpen()
+	halfOpen.Push(1, 5)
+	halfOpen.BuildTree()
+	if intrvl, distance, _ := halfOpen.Nearest(5); intrvl.Segment != (Segment{1, 5}) || distance != 1 {
+		t.Errorf("fail nearest of half-open tree: %v %d", intrvl, distance)
+	}
+}
+
+func TestDirtyTree(t *testing.T) {
+	for i, tree := range []Tree{NewTree(), NewIntervalTree(), NewCircularTree(100), NewTreeHalfOpen()} {
+		tree.PushArray([]int{1, 10}, []int{5, 20})
+		tree.BuildTree()
+		// insert keeps the tree up to date, not supported by the circular tree
+		if i != 2 {
+			tree.(Updater).Insert(5, 10)
+		}
+		if tree.Dirty() {
+			t.Errorf("fail dirty after insert into tree %d", i)
+		}
+		tree.Push(30, 40)
+		if !tree.Dirty() {
+			t.Errorf("fail dirty after push to tree %d", i)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r != ErrDirtyTree {
+					t.Errorf("fail panic on query of dirty tree %d: %v", i, r)
+				}
+			}()
+			tree.Query(30, 35)
+		}()
+		// rebuilt from the stack without Clear
+		tree.BuildTree()
+		if tree.Dirty() || len(tree.Query(30, 35)) != 1 {
+			t.Errorf("fail query of rebuilt tree %d", i)
+		}
+	}
+	serial := NewSerial()
+	serial.Push(1, 5)
+	if serial.Dirty() || len(serial.Query(1, 1)) != 1 {
+		t.Errorf("fail dirty of serial")
+	}
+	// a lazy tree rebuilds on query
+	lazy := NewLazyTree()
+	lazy.Push(1, 5)
+	lazy.Query(1, 1)
+	lazy.Push(7, 9)
+	if result := lazy.Query(8, 8); len(result) != 1 {
+		t.Errorf("fail query of lazy tree after push: %v", result)
+	}
+	safe := NewSafeTree(NewTree())
+	safe.Push(1, 5)
+	safe.BuildTree()
+	safe.Push(7, 9)
+	if result := safe.Stab(8); result != nil || safe.LastError() != ErrDirtyTree {
+		t.Errorf("fail error of dirty tree: %v %v", result, safe.LastError())
+	}
+}
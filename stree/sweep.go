@@ -0,0 +1,446 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"math/bits"
+	"slices"
+	"sort"
+)
+
+// Layers assigns intervals to layers, so that intervals of the same layer
+// do not overlap. The intervals are swept in order of From and every interval
+// is placed into the lowest layer that is free at its start (greedy interval
+// graph coloring), which results in the minimal number of layers.
+func Layers(intervals []Interval) [][]Interval {
+	sorted := make([]Interval, len(intervals))
+	copy(sorted, intervals)
+	SortByFrom(sorted)
+	layers := make([][]Interval, 0, 4)
+	// end of last interval in each layer
+	ends := make([]int, 0, 4)
+	for _, intrvl := range sorted {
+		layer := 0
+		for layer < len(ends) && ends[layer] >= intrvl.From {
+			layer++
+		}
+		if layer == len(ends) {
+			layers = append(layers, make([]Interval, 0, 10))
+			ends = append(ends, 0)
+		}
+		layers[layer] = append(layers[layer], intrvl)
+		ends[layer] = intrvl.To
+	}
+	return layers
+}
+
+// LayerCount returns the number of layers Layers assigns intervals to, the
+// maximum number of intervals sharing a coordinate. Starts and ends are
+// swept separately, so no layers are built.
+func LayerCount(intervals []Interval) int {
+	starts := make([]int, len(intervals))
+	ends := make([]int, len(intervals))
+	for i, intrvl := range intervals {
+		starts[i], ends[i] = intrvl.From, intrvl.To
+	}
+	sort.Ints(starts)
+	sort.Ints(ends)
+	depth, maxDepth := 0, 0
+	for i, j := 0, 0; i < len(starts); {
+		// intervals are closed, an interval starting at the end of another overlaps it
+		if starts[i] <= ends[j] {
+			depth++
+			maxDepth = max(maxDepth, depth)
+			i++
+		} else {
+			depth--
+			j++
+		}
+	}
+	return maxDepth
+}
+
+// OverlapDegrees maps the Id of each interval to the number of other
+// intervals it overlaps, the degree in the interval graph. An interval J
+// doesn't overlap I if J ends before I starts or starts after I ends, both
+// are counted by binary search in the sorted ends and starts, which takes
+// O(n log n) regardless of the number of overlapping pairs.
+func OverlapDegrees(intervals []Interval) map[int]int {
+	n := len(intervals)
+	starts := make([]int, n)
+	ends := make([]int, n)
+	for i, intrvl := range intervals {
+		starts[i], ends[i] = intrvl.From, intrvl.To
+	}
+	sort.Ints(starts)
+	sort.Ints(ends)
+	degrees := make(map[int]int, n)
+	for _, intrvl := range intervals {
+		before := sort.SearchInts(ends, intrvl.From)
+		after := n - sort.Search(n, func(i int) bool { return starts[i] > intrvl.To })
+		degrees[intrvl.Id] = n - before - after - 1
+	}
+	return degrees
+}
+
+// MaximalCliques returns the Ids of the maximal groups of intervals that all
+// overlap each other, the maximal cliques of the interval graph. Intervals
+// of a group share a coordinate, so the intervals open during the sweep form
+// a maximal group whenever an interval ends after another one started. Ids
+// of a group are sorted, groups are in order of their common coordinate.
+func MaximalCliques(intervals []Interval) [][]int {
+	n := len(intervals)
+	starts := make([]int, n)
+	ends := make([]int, n)
+	for i := range intervals {
+		starts[i], ends[i] = i, i
+	}
+	sort.Slice(starts, func(i, j int) bool { return intervals[starts[i]].From < intervals[starts[j]].From })
+	sort.Slice(ends, func(i, j int) bool { return intervals[ends[i]].To < intervals[ends[j]].To })
+	// open intervals and the position of each interval in open
+	open := make([]int, 0, 16)
+	position := make([]int, n)
+	cliques := make([][]int, 0)
+	grown := false
+	for i, j := 0, 0; j < n; {
+		// intervals are closed, an interval starting at the end of another overlaps it
+		if i < n && intervals[starts[i]].From <= intervals[ends[j]].To {
+			position[starts[i]] = len(open)
+			open = append(open, starts[i])
+			grown = true
+			i++
+			continue
+		}
+		if grown {
+			clique := make([]int, len(open))
+			for k, pos := range open {
+				clique[k] = intervals[pos].Id
+			}
+			slices.Sort(clique)
+			cliques = append(cliques, clique)
+			grown = false
+		}
+		// remove ending interval, the last open interval takes its place
+		k, last := position[ends[j]], open[len(open)-1]
+		open[k], position[last] = last, k
+		open = open[:len(open)-1]
+		j++
+	}
+	return cliques
+}
+
+// Aggregated lengths (To - From) of intervals, accumulated in int64
+// to avoid overflow of int on 32 bit platforms. Sum overflows if the
+// lengths add up to more than math.MaxInt64.
+type LengthStats struct {
+	Count    int
+	Min, Max int64
+	Sum      int64
+}
+
+// Mean returns the average length of the intervals
+func (s LengthStats) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// Lengths aggregates the lengths of given intervals
+func Lengths(intervals []Interval) LengthStats {
+	var stats LengthStats
+	for i, intrvl := range intervals {
+		length := int64(intrvl.To) - int64(intrvl.From)
+		if i == 0 || length < stats.Min {
+			stats.Min = length
+		}
+		if i == 0 || length > stats.Max {
+			stats.Max = length
+		}
+		stats.Sum += length
+	}
+	stats.Count = len(intervals)
+	return stats
+}
+
+// Coverage returns the number of coordinates covered by at least one of
+// given intervals, a single interval covers To - From + 1 coordinates.
+// The result is accumulated in int64, see LengthStats.
+func Coverage(intervals []Interval) int64 {
+	var coverage int64
+	for _, seg := range MergedSegments(intervals) {
+		coverage += int64(seg.To) - int64(seg.From) + 1
+	}
+	return coverage
+}
+
+// DensityHistogram divides the range (from, to) into buckets of equal width
+// and returns for each bucket the number of coordinates in it covered by
+// intervals, a coordinate covered by k intervals counts k times. Bucket b
+// holds the coordinates c with (c - from) * buckets / (to - from + 1) = b
+// rounded down, see Render. The counts are accumulated in int64 like
+// LengthStats and overflow if a bucket holds more than math.MaxInt64.
+// Panics with ErrInvalidViewport if buckets <= 0 or from > to. The range
+// must not span the whole range of int.
+func DensityHistogram(intervals []Interval, from, to, buckets int) []int64 {
+	if buckets <= 0 || from > to {
+		panic(ErrInvalidViewport)
+	}
+	span := uint64(to) - uint64(from) + 1
+	histogram := make([]int64, buckets)
+	for _, intrvl := range intervals {
+		lo, hi := max(intrvl.From, from), min(intrvl.To, to)
+		if lo > hi {
+			continue
+		}
+		// offsets to from, coordinates of the range may exceed int
+		loOff, hiOff := uint64(lo)-uint64(from), uint64(hi)-uint64(from)
+		for b := pixel(loOff, span, buckets); b < buckets; b++ {
+			start := bucketStart(b, span, buckets)
+			if start > hiOff {
+				break
+			}
+			end := bucketStart(b+1, span, buckets) - 1
+			histogram[b] += int64(min(hiOff, end) - max(loOff, start) + 1)
+		}
+	}
+	return histogram
+}
+
+// bucketStart returns the offset of the first coordinate of bucket b, that
+// is b * span / buckets rounded up, b <= buckets
+func bucketStart(b int, span uint64, buckets int) uint64 {
+	hi, lo := bits.Mul64(uint64(b), span)
+	quo, rem := bits.Div64(hi, lo, uint64(buckets))
+	if rem != 0 {
+		quo++
+	}
+	return quo
+}
+
+// AllGaps returns the maximal segments between the smallest From and the
+// largest To of intervals that are not covered by any interval, see MergedSegments.
+func AllGaps(intervals []Interval) []Segment {
+	gaps := make([]Segment, 0, 10)
+	segments := MergedSegments(intervals)
+	for i := 1; i < len(segments); i++ {
+		gaps = append(gaps, Segment{segments[i-1].To + 1, segments[i].From - 1})
+	}
+	return gaps
+}
+
+// ClipWithGaps clips intervals to (from, to) and returns them sorted by From
+// together with the maximal segments of (from, to) not covered by any of
+// them, both in one sweep. Intervals that don't intersect (from, to), e.g.
+// found by a custom OverlapFunc, are left out.
+func ClipWithGaps(intervals []Interval, from, to int) ([]Interval, []Segment) {
+	clipped := make([]Interval, 0, len(intervals))
+	for _, intrvl := range intervals {
+		if intrvl.From <= to && intrvl.To >= from {
+			intrvl.From, intrvl.To = max(intrvl.From, from), min(intrvl.To, to)
+			clipped = append(clipped, intrvl)
+		}
+	}
+	SortByFrom(clipped)
+	gaps := make([]Segment, 0, 10)
+	if from > to {
+		return clipped, gaps
+	}
+	// first coordinate not covered so far, pos+1 overflows if to is reached
+	pos, done := from, false
+	for _, intrvl := range clipped {
+		if intrvl.From > pos {
+			gaps = append(gaps, Segment{pos, intrvl.From - 1})
+		}
+		if intrvl.To == to {
+			done = true
+			break
+		}
+		pos = max(pos, intrvl.To+1)
+	}
+	if !done {
+		gaps = append(gaps, Segment{pos, to})
+	}
+	return clipped, gaps
+}
+
+// Relative clips intervals to (from, to) and shifts them so that from
+// becomes 0, the segments are in the order of intervals. Intervals that
+// don't intersect (from, to) are left out. The offsets overflow if to - from
+// exceeds the range of int.
+func Relative(intervals []Interval, from, to int) []Segment {
+	segments := make([]Segment, 0, len(intervals))
+	for _, intrvl := range intervals {
+		if intrvl.From <= to && intrvl.To >= from {
+			segments = append(segments, Segment{max(intrvl.From, from) - from, min(intrvl.To, to) - from})
+		}
+	}
+	return segments
+}
+
+// RenderedInterval is an interval clipped to a viewport with the pixels
+// X0 to X1 it covers in a row of the viewport
+type RenderedInterval struct {
+	Interval Interval
+	X0, X1   int
+}
+
+// Render clips intervals to the viewport (from, to), sorts them by From and
+// maps them to pixels of a row of width pixels, see ClipWithGaps. The
+// coordinates from to to are spread evenly over the row, coordinate c
+// covers the pixels from x(c) to x(c+1) with x(c) = (c - from) * width /
+// (to - from + 1) rounded down, so the viewport spans the pixels 0 to
+// width. Intervals shorter than a pixel may have X0 == X1. Panics with
+// ErrInvalidViewport if width <= 0 or from > to. The viewport must not
+// span the whole range of int.
+func Render(intervals []Interval, from, to, width int) []RenderedInterval {
+	if width <= 0 || from > to {
+		panic(ErrInvalidViewport)
+	}
+	clipped, _ := ClipWithGaps(intervals, from, to)
+	span := uint64(to) - uint64(from) + 1
+	rendered := make([]RenderedInterval, len(clipped))
+	for i, intrvl := range clipped {
+		rendered[i] = RenderedInterval{
+			Interval: intrvl,
+			X0:       pixel(uint64(intrvl.From)-uint64(from), span, width),
+			X1:       pixel(uint64(intrvl.To)-uint64(from)+1, span, width),
+		}
+	}
+	return rendered
+}
+
+// pixel returns offset * width / span rounded down, offset <= span. The
+// product is computed in 128 bits, so it doesn't overflow.
+func pixel(offset, span uint64, width int) int {
+	hi, lo := bits.Mul64(offset, uint64(width))
+	quo, _ := bits.Div64(hi, lo, span)
+	return int(quo)
+}
+
+// AnnotatedInterval is an interval of the result of an interval array
+// query with the indices of the queries it overlaps, in ascending order
+type AnnotatedInterval struct {
+	Interval Interval
+	Ranges   []int
+}
+
+// Annotate merges the results of the queries of an interval array, groups[i]
+// is the result of query i, intervals are identified by Id
+func Annotate(groups [][]Interval) []AnnotatedInterval {
+	result := make(map[int]*AnnotatedInterval)
+	for i, group := range groups {
+		for _, intrvl := range group {
+			annotate(result, intrvl, i)
+		}
+	}
+	return annotated(result)
+}
+
+// annotate adds query index i to the annotation of intrvl
+func annotate(result map[int]*AnnotatedInterval, intrvl Interval, i int) {
+	a, ok := result[intrvl.Id]
+	if !ok {
+		a = &AnnotatedInterval{Interval: intrvl}
+		result[intrvl.Id] = a
+	}
+	// an interval may be found at several nodes for the same query
+	if !slices.Contains(a.Ranges, i) {
+		a.Ranges = append(a.Ranges, i)
+	}
+}
+
+// annotated returns the annotated intervals sorted by Id with sorted indices
+func annotated(result map[int]*AnnotatedInterval) []AnnotatedInterval {
+	sl := make([]AnnotatedInterval, 0, len(result))
+	for _, a := range result {
+		sort.Ints(a.Ranges)
+		sl = append(sl, *a)
+	}
+	sort.Slice(sl, func(i, j int) bool { return sl[i].Interval.Id < sl[j].Interval.Id })
+	return sl
+}
+
+// SegmentCount is a segment of a canonical form with the number of
+// intervals that have this segment
+type SegmentCount struct {
+	Segment Segment
+	Count   int
+}
+
+// CanonicalCounted returns the distinct segments of intervals sorted by From,
+// then To, with the number of intervals of each segment. Two interval sets
+// are equal regardless of order and Ids if their canonical forms are equal.
+func CanonicalCounted(intervals []Interval) []SegmentCount {
+	segments := make([]Segment, len(intervals))
+	for i, intrvl := range intervals {
+		segments[i] = intrvl.Segment
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if segments[i].From != segments[j].From {
+			return segments[i].From < segments[j].From
+		}
+		return segments[i].To < segments[j].To
+	})
+	counted := make([]SegmentCount, 0, len(segments))
+	for _, seg := range segments {
+		if last := len(counted) - 1; last >= 0 && counted[last].Segment == seg {
+			counted[last].Count++
+		} else {
+			counted = append(counted, SegmentCount{Segment: seg, Count: 1})
+		}
+	}
+	return counted
+}
+
+// Canonical returns the distinct segments of intervals sorted by From, then
+// To, duplicate segments are collapsed, see CanonicalCounted
+func Canonical(intervals []Interval) []Segment {
+	counted := CanonicalCounted(intervals)
+	segments := make([]Segment, len(counted))
+	for i, c := range counted {
+		segments[i] = c.Segment
+	}
+	return segments
+}
+
+// FullyCovered returns true if every coordinate of (from, to) is covered
+// by one of given intervals, false if from > to
+func FullyCovered(intervals []Interval, from, to int) bool {
+	return NewEndpointIndex(intervals).Covers(intervals, from, to)
+}
+
+// IsPartition returns true if given intervals tile (from, to) without gaps
+// and overlaps, see EndpointIndex.IsPartition
+func IsPartition(intervals []Interval, from, to int) bool {
+	return NewEndpointIndex(intervals).IsPartition(intervals, from, to)
+}
+
+// HasOverlaps returns true if any two of given intervals overlap
+func HasOverlaps(intervals []Interval) bool {
+	return NewEndpointIndex(intervals).HasOverlaps(intervals)
+}
+
+// MergedSegments returns the union of intervals as sorted, disjoint and
+// maximal segments. Touching intervals like (1,5) and (5,9) are merged to
+// (1,9). Coordinates are integers, so adjacent intervals like (1,5) and
+// (6,9) are merged as well, as no coordinate between them is left uncovered.
+func MergedSegments(intervals []Interval) []Segment {
+	sorted := make([]Interval, len(intervals))
+	copy(sorted, intervals)
+	SortByFrom(sorted)
+	segments := make([]Segment, 0, 10)
+	for _, intrvl := range sorted {
+		last := len(segments) - 1
+		if last >= 0 && (intrvl.From <= segments[last].To || intrvl.From-segments[last].To == 1) {
+			if intrvl.To > segments[last].To {
+				segments[last].To = intrvl.To
+			}
+		} else {
+			segments = append(segments, intrvl.Segment)
+		}
+	}
+	return segments
+}
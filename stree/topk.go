@@ -0,0 +1,172 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// QueryTopK returns the k overlapping intervals that are greatest by less,
+// greatest first. Only the k best intervals found so far are kept in a heap
+// while the tree is traversed, which takes O(m log k) for m overlapping
+// intervals. An interval stored at several nodes is inserted only once.
+func (t *stree) QueryTopK(from, to, k int, less func(a, b Interval) bool) []Interval {
+	t.checkBuilt()
+	if k <= 0 || t.outside(from, to) {
+		return []Interval{}
+	}
+	top := newTopK(k, less)
+	queryTopK(t.root, from, to, t.overlapFunc(), top, false)
+	return top.sorted()
+}
+
+// QueryRecent returns the n overlapping intervals with the highest Ids,
+// highest Id first. Push assigns Ids in insertion order, so these are the
+// most recently pushed intervals, with PushWithId the order is up to the
+// caller. Intervals are inserted into nodes in order of the stack, so unless
+// Ids were given by PushWithId the scan of a node stops at the first Id
+// that doesn't make it into the top n.
+func (t *stree) QueryRecent(from, to, n int) []Interval {
+	t.checkBuilt()
+	if n <= 0 || t.outside(from, to) {
+		return []Interval{}
+	}
+	top := newTopK(n, func(a, b Interval) bool { return a.Id < b.Id })
+	queryTopK(t.root, from, to, t.overlapFunc(), top, !t.sparse)
+	return top.sorted()
+}
+
+// queryTopK traverses tree and offers overlaps to top, the overlapping
+// intervals of a node are scanned backwards, if ascending is true the scan
+// stops at the first rejected interval
+func queryTopK(node *node, from, to int, overlaps OverlapFunc, top *topK, ascending bool) {
+	if !overlaps(node.segment, from, to) {
+		return
+	}
+	for i := len(node.overlap) - 1; i >= 0; i-- {
+		if !top.offer(node.overlap[i]) && ascending {
+			break
+		}
+	}
+	if node.right != nil {
+		queryTopK(node.right, from, to, overlaps, top, ascending)
+	}
+	if node.left != nil {
+		queryTopK(node.left, from, to, overlaps, top, ascending)
+	}
+}
+
+// Preference selects one of several intervals, see Best
+type Preference int
+
+const (
+	// Preferences of Best
+	SHORTEST Preference = iota
+	LONGEST
+	HIGHEST_ID
+	LOWEST_FROM
+)
+
+// better returns true if a is preferred over b, ties go to the lower Id
+func (prefer Preference) better(a, b Interval) bool {
+	switch prefer {
+	case SHORTEST, LONGEST:
+		// lengths in int64 like LengthStats, To - From overflows int for wide intervals
+		la, lb := int64(a.To)-int64(a.From), int64(b.To)-int64(b.From)
+		if la != lb {
+			return (la < lb) == (prefer == SHORTEST)
+		}
+	case HIGHEST_ID:
+		return a.Id > b.Id
+	case LOWEST_FROM:
+		if a.From != b.From {
+			return a.From < b.From
+		}
+	}
+	return a.Id < b.Id
+}
+
+// Best returns the interval of seq that is preferred by prefer, false if seq is empty
+func Best(seq IntervalSeq, prefer Preference) (best Interval, ok bool) {
+	for intrvl := range seq {
+		if !ok || prefer.better(intrvl, best) {
+			best, ok = intrvl, true
+		}
+	}
+	return
+}
+
+// StabBest returns the interval containing point that is preferred by
+// prefer, e.g. the most specific (SHORTEST) of overlapping rules. The
+// intervals are compared while the tree is traversed, nothing is collected.
+func (t *stree) StabBest(point int, prefer Preference) (Interval, bool) {
+	return Best(t.QueryView(point, point), prefer)
+}
+
+// topK is a min-heap that keeps the k greatest intervals by less
+type topK struct {
+	k     int
+	less  func(a, b Interval) bool
+	items []Interval
+	// Ids in heap
+	in map[int]bool
+}
+
+func newTopK(k int, less func(a, b Interval) bool) *topK {
+	return &topK{k: k, less: less, items: make([]Interval, 0, min(k, 10)), in: make(map[int]bool, min(k, 10))}
+}
+
+func (h *topK) Len() int           { return len(h.items) }
+func (h *topK) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *topK) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *topK) Push(x any)         { h.items = append(h.items, x.(Interval)) }
+func (h *topK) Pop() any {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
+
+// offer adds interval to the heap if it is among the k greatest, returns
+// false if it is not greater than the least interval of a full heap. An
+// interval removed from the heap is never greater than the least interval,
+// so it is rejected if offered again.
+func (h *topK) offer(pintrvl *Interval) bool {
+	if len(h.items) == h.k && !h.less(h.items[0], *pintrvl) {
+		return false
+	}
+	if h.in[pintrvl.Id] {
+		return true
+	}
+	if len(h.items) == h.k {
+		delete(h.in, heap.Pop(h).(Interval).Id)
+	}
+	heap.Push(h, *pintrvl)
+	h.in[pintrvl.Id] = true
+	return true
+}
+
+// sorted returns the intervals of the heap, greatest first
+func (h *topK) sorted() []Interval {
+	sort.Slice(h.items, func(i, j int) bool { return h.less(h.items[j], h.items[i]) })
+	return h.items
+}
+
+// greatest returns the k intervals of result that are greatest by less, greatest first
+func greatest(result []Interval, k int, less func(a, b Interval) bool) []Interval {
+	sort.SliceStable(result, func(i, j int) bool { return less(result[j], result[i]) })
+	if k < 0 {
+		k = 0
+	}
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
+
+// mostRecent returns the n intervals of result with the highest Ids, highest first
+func mostRecent(result []Interval, n int) []Interval {
+	return greatest(result, n, func(a, b Interval) bool { return a.Id < b.Id })
+}
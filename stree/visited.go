@@ -0,0 +1,47 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+// visitedSet records the Ids of the intervals a traversal has reported.
+// An interval is stored at several nodes, unless a query finds it at a
+// single node, see queryUnique, the set makes sure it is reported once.
+type visitedSet interface {
+	// visit marks id, returns false if it was marked before
+	visit(id int) bool
+}
+
+// visitedMap is the visited set for any Ids
+type visitedMap map[int]struct{}
+
+func (s visitedMap) visit(id int) bool {
+	if _, ok := s[id]; ok {
+		return false
+	}
+	s[id] = struct{}{}
+	return true
+}
+
+// visitedBits is the visited set for dense Ids in [0, 64 * len), one bit
+// per Id instead of a map entry
+type visitedBits []uint64
+
+func (s visitedBits) visit(id int) bool {
+	word, bit := id/64, uint64(1)<<(id%64)
+	if s[word]&bit != 0 {
+		return false
+	}
+	s[word] |= bit
+	return true
+}
+
+// newVisited returns a bitset if the Ids of the tree are dense, i.e. not
+// negative and less than twice the number of intervals, otherwise a map.
+// Ids of Push are dense, Ids of PushWithId or Remove may be.
+func (t *stack) newVisited() visitedSet {
+	if !t.negative && t.count <= 2*len(t.base)+64 {
+		return make(visitedBits, (t.count+63)/64)
+	}
+	return make(visitedMap)
+}
@@ -0,0 +1,63 @@
+// Copyright 2012 Thomas Oberndörfer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package stree
+
+import (
+	"reflect"
+)
+
+// NodeVisitor accumulates statistics of a tree, Enter is called for every
+// node with its depth, the root has depth 0
+type NodeVisitor interface {
+	Enter(node Node, depth int)
+}
+
+// Aggregate traverses the tree once and passes every node to all visitors,
+// so several statistics are computed in a single walk
+func Aggregate(root Node, visitors ...NodeVisitor) {
+	aggregate(root, 0, visitors)
+}
+
+// aggregate visits node and its children recursively
+func aggregate(node Node, depth int, visitors []NodeVisitor) {
+	if reflect.ValueOf(node).IsNil() {
+		return
+	}
+	for _, visitor := range visitors {
+		visitor.Enter(node, depth)
+	}
+	aggregate(node.Right(), depth+1, visitors)
+	aggregate(node.Left(), depth+1, visitors)
+}
+
+// NodeCount counts the nodes of a tree
+type NodeCount struct {
+	Count int
+}
+
+func (v *NodeCount) Enter(node Node, depth int) {
+	v.Count++
+}
+
+// MaxDepth determines the depth of the deepest node
+type MaxDepth struct {
+	Depth int
+}
+
+func (v *MaxDepth) Enter(node Node, depth int) {
+	if depth > v.Depth {
+		v.Depth = depth
+	}
+}
+
+// OverlapEntryCount counts the intervals stored in all nodes, an interval
+// is counted once for every node it is stored at
+type OverlapEntryCount struct {
+	Count int
+}
+
+func (v *OverlapEntryCount) Enter(node Node, depth int) {
+	v.Count += len(node.Overlap())
+}